@@ -0,0 +1,90 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/AkshatMadhani/nanopulse/engine"
+)
+
+// TransferRequest moves cash and/or shares of one symbol in or out of an
+// account.
+type TransferRequest struct {
+	Cash   engine.Price `json:"cash"`
+	Symbol string       `json:"symbol"`
+	Qty    int          `json:"qty"`
+}
+
+func (s *Server) handleAccount(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	parts := strings.Split(r.URL.Path, "/")
+	if len(parts) < 3 || parts[2] == "" {
+		s.respondError(w, "Account ID required in URL path", http.StatusBadRequest)
+		return
+	}
+
+	acct, exists := s.engine.GetAccount(parts[2])
+	if !exists {
+		s.respondError(w, "Account not found", http.StatusNotFound)
+		return
+	}
+	s.respondJSON(w, acct, http.StatusOK)
+}
+
+func (s *Server) handleAdminAccount(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(r.URL.Path, "/")
+	if len(parts) != 5 || parts[3] == "" {
+		s.respondError(w, "Expected /admin/accounts/{id}/deposit or /withdraw", http.StatusBadRequest)
+		return
+	}
+	id := parts[3]
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req TransferRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.respondError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Symbol = strings.ToUpper(req.Symbol)
+	if req.Cash < 0 || req.Qty < 0 || (req.Cash == 0 && req.Qty == 0) || (req.Qty > 0 && req.Symbol == "") {
+		s.respondError(w, "Invalid transfer - cash and/or symbol with qty required", http.StatusBadRequest)
+		return
+	}
+
+	var err error
+	switch parts[4] {
+	case "deposit":
+		err = s.engine.Deposit(id, req.Cash, req.Symbol, req.Qty)
+	case "withdraw":
+		err = s.engine.Withdraw(id, req.Cash, req.Symbol, req.Qty)
+	default:
+		s.respondError(w, "Expected /admin/accounts/{id}/deposit or /withdraw", http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case errors.Is(err, engine.ErrUnknownAccount):
+		s.respondError(w, "Account not found", http.StatusNotFound)
+		return
+	case errors.Is(err, engine.ErrInsufficientFunds):
+		s.respondError(w, "Insufficient available balance", http.StatusConflict)
+		return
+	case err != nil:
+		s.logger.Error("Account transfer failed", "account", id, "error", err)
+		s.respondError(w, "Transfer failed", http.StatusServiceUnavailable)
+		return
+	}
+
+	acct, _ := s.engine.GetAccount(id)
+	s.respondJSON(w, acct, http.StatusOK)
+}
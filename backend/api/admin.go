@@ -0,0 +1,44 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/AkshatMadhani/nanopulse/snapshot"
+)
+
+func (s *Server) SetAdminToken(token string) {
+	s.adminToken = token
+}
+
+func (s *Server) SetSnapshotter(m *snapshot.Manager) {
+	s.snapshots = m
+}
+
+func (s *Server) adminOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.adminToken != "" && r.Header.Get("X-Admin-Token") != s.adminToken {
+			s.respondError(w, "Admin token required", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (s *Server) handleAdminSnapshot(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.snapshots == nil {
+		s.respondError(w, "Snapshots are disabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	info, err := s.snapshots.Take()
+	if err != nil {
+		s.logger.Error("Snapshot failed", "error", err)
+		s.respondError(w, "Snapshot failed", http.StatusInternalServerError)
+		return
+	}
+	s.respondJSON(w, info, http.StatusOK)
+}
@@ -9,15 +9,16 @@ import (
 
 type SystemState struct {
 	Timestamp    int64
-	BestBid      *float64
-	BestAsk      *float64
-	Spread       *float64
+	BestBid      *engine.Price
+	BestAsk      *engine.Price
+	Spread       *engine.Price
 	LatencyUs    float64
 	MaxLatencyUs float64
 	Mode         string
 	QueueDepth   int
 	MMProfit     float64
 	TotalTrades  int64
+	Seq          uint64
 	OrderBooks   map[string]interface{}
 	OrderBook    interface{}
 	RecentTrade  *TradeEvent
@@ -26,7 +27,7 @@ type SystemState struct {
 type TradeEvent struct {
 	ID        string
 	Symbol    string
-	Price     float64
+	Price     engine.Price
 	Qty       int
 	Side      string
 	Timestamp int64
@@ -98,11 +99,11 @@ func (s *Server) collectSystemState() SystemState {
 	monitorStats := s.monitor.GetStats()
 	mmStats := s.marketMaker.GetStats()
 
-	symbols := []string{"RELIANCE", "TCS", "INFY", "HDFC", "ICICI"}
+	symbols := s.engine.Instruments().Symbols()
 
 	orderBooks := make(map[string]interface{})
 	var primaryOrderBook interface{}
-	var bestBid, bestAsk, spread *float64
+	var bestBid, bestAsk, spread *engine.Price
 
 	for i, symbol := range symbols {
 		book := s.engine.GetBook(symbol)
@@ -120,6 +121,9 @@ func (s *Server) collectSystemState() SystemState {
 				"best_bid":  bid,
 				"best_ask":  ask,
 				"spread":    spr,
+				"trading":   snapshot.Trading,
+				"auction":   snapshot.Auction,
+				"seq":       snapshot.Seq,
 			}
 
 			orderBooks[symbol] = orderBookData
@@ -169,6 +173,7 @@ func (s *Server) collectSystemState() SystemState {
 		QueueDepth:   s.engine.GetQueueDepth(),
 		MMProfit:     mmStats.Profit,
 		TotalTrades:  monitorStats.TotalTrades,
+		Seq:          s.engine.LastSeq(),
 		OrderBooks:   orderBooks,
 		OrderBook:    primaryOrderBook,
 		RecentTrade:  recentTrade,
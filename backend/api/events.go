@@ -0,0 +1,104 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/AkshatMadhani/nanopulse/engine"
+)
+
+const (
+	defaultEventLimit = 1000
+	maxEventLimit     = 10000
+)
+
+type EventMessage struct {
+	Type  string       `json:"type"`
+	Event engine.Event `json:"event"`
+}
+
+// TradingStateMessage goes to every client when a symbol halts, resumes or
+// closes, whether or not it subscribed to events.
+type TradingStateMessage struct {
+	Type    string               `json:"type"`
+	Symbol  string               `json:"symbol"`
+	Trading engine.TradingStatus `json:"trading"`
+	Reason  string               `json:"reason,omitempty"`
+	Seq     uint64               `json:"seq"`
+}
+
+type EventsResponse struct {
+	Type     string         `json:"type,omitempty"`
+	Events   []engine.Event `json:"events"`
+	FirstSeq uint64         `json:"first_seq"`
+	LastSeq  uint64         `json:"last_seq"`
+	Message  string         `json:"message,omitempty"`
+}
+
+func (s *Server) startEventListener() {
+	for ev := range s.engine.GetEventChan() {
+		s.wsHub.Publish(eventsTopic, EventMessage{Type: "event", Event: ev})
+		if ev.Type == engine.EVENT_TRADING_STATE && ev.Trading != nil {
+			s.wsHub.Broadcast(TradingStateMessage{
+				Type:    "trading_state",
+				Symbol:  ev.Symbol,
+				Trading: *ev.Trading,
+				Reason:  ev.Reason,
+				Seq:     ev.Seq,
+			})
+		}
+	}
+}
+
+// recoverEvents answers a gap request. It reports false when part of the
+// range has already left the engine's event log.
+func (s *Server) recoverEvents(from, to uint64, symbol string, limit int) (EventsResponse, bool) {
+	if limit <= 0 {
+		limit = defaultEventLimit
+	}
+	events, first, last := s.engine.Events(from, to, strings.ToUpper(symbol), min(limit, maxEventLimit))
+
+	resp := EventsResponse{Events: events, FirstSeq: first, LastSeq: last}
+	if first > 0 && from < first {
+		resp.Events = []engine.Event{}
+		resp.Message = "Requested events are no longer retained"
+		return resp, false
+	}
+	return resp, true
+}
+
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	from, err := strconv.ParseUint(query.Get("from"), 10, 64)
+	if err != nil || from == 0 {
+		s.respondError(w, "Invalid from - must be a sequence number", http.StatusBadRequest)
+		return
+	}
+	var to uint64
+	if v := query.Get("to"); v != "" {
+		if to, err = strconv.ParseUint(v, 10, 64); err != nil || to < from {
+			s.respondError(w, "Invalid to - must be at least from", http.StatusBadRequest)
+			return
+		}
+	}
+	limit := 0
+	if v := query.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+			s.respondError(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	resp, ok := s.recoverEvents(from, to, query.Get("symbol"), limit)
+	status := http.StatusOK
+	if !ok {
+		status = http.StatusGone
+	}
+	s.respondJSON(w, resp, status)
+}
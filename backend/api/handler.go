@@ -1,25 +1,70 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/AkshatMadhani/nanopulse/engine"
+	"github.com/google/uuid"
 )
 
+const syncOrderTimeout = 5 * time.Second
+
 type OrderRequest struct {
-	Symbol string
-	Side   string
-	Price  float64
-	Qty    int
-	UserID string
+	Symbol       string       `json:"symbol"`
+	Side         string       `json:"side"`
+	Type         string       `json:"type"`
+	TimeInForce  string       `json:"time_in_force"`
+	GoodTillDate string       `json:"good_till_date"`
+	PostOnly     string       `json:"post_only"`
+	Price        engine.Price `json:"price"`
+	StopPrice    engine.Price `json:"stop_price"`
+	Qty          int          `json:"qty"`
+	DisplayQty   int          `json:"display_qty"`
+	SelfTrade    string       `json:"self_trade"`
+	UserID       string       `json:"user_id"`
+}
+
+// SubmitResponse answers POST /order once the engine has journaled and
+// processed the order.
+type SubmitResponse struct {
+	OrderStatusResponse
+	Reason string         `json:"reason,omitempty"`
+	Trades []engine.Trade `json:"trades"`
+}
+
+type AmendRequest struct {
+	Price engine.Price `json:"price"`
+	Qty   int          `json:"qty"`
 }
 
 type OrderResponse struct {
 	Status  string
 	OrderID string
 	Message string
+	Reason  string `json:",omitempty"`
+}
+
+type OrderStatusResponse struct {
+	OrderID      string       `json:"order_id"`
+	Symbol       string       `json:"symbol"`
+	Side         string       `json:"side"`
+	Type         string       `json:"type"`
+	TimeInForce  string       `json:"time_in_force"`
+	GoodTillDate string       `json:"good_till_date,omitempty"`
+	Status       string       `json:"status"`
+	Price        engine.Price `json:"price"`
+	StopPrice    engine.Price `json:"stop_price"`
+	Qty          int          `json:"qty"`
+	FilledQty    int          `json:"filled_qty"`
+	LeavesQty    int          `json:"leaves_qty"`
+	AvgPrice     engine.Price `json:"avg_price"`
+	UserID       string       `json:"user_id"`
+	Timestamp    int64        `json:"timestamp"`
 }
 
 func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
@@ -54,11 +99,106 @@ func (s *Server) handleOrder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.Symbol == "" || req.Price <= 0 || req.Qty <= 0 {
+	req.Symbol = strings.ToUpper(req.Symbol)
+	inst, exists := s.engine.GetInstrument(req.Symbol)
+	if !exists {
+		s.respondError(w, "Unknown symbol", http.StatusBadRequest)
+		return
+	}
+	if inst.Status != engine.ACTIVE {
+		s.respondError(w, "Instrument is "+inst.Status.String(), http.StatusBadRequest)
+		return
+	}
+	if status := s.engine.TradingStatus(req.Symbol); status.State == engine.HALTED || status.State == engine.CLOSED {
+		s.respondError(w, "Trading is "+status.State.String(), http.StatusBadRequest)
+		return
+	}
+
+	orderType := engine.LIMIT
+	switch strings.ToUpper(req.Type) {
+	case "", "LIMIT":
+	case "MARKET":
+		orderType = engine.MARKET
+	case "STOP":
+		orderType = engine.STOP
+	case "STOP_LIMIT":
+		orderType = engine.STOP_LIMIT
+	default:
+		s.respondError(w, "Invalid type - must be LIMIT, MARKET, STOP or STOP_LIMIT", http.StatusBadRequest)
+		return
+	}
+
+	tif := engine.GTC
+	if orderType == engine.MARKET {
+		tif = engine.IOC
+	}
+	switch strings.ToUpper(req.TimeInForce) {
+	case "":
+	case "GTC":
+		tif = engine.GTC
+	case "IOC":
+		tif = engine.IOC
+	case "FOK":
+		tif = engine.FOK
+	case "DAY":
+		tif = engine.DAY
+	case "GTD":
+		tif = engine.GTD
+	default:
+		s.respondError(w, "Invalid time_in_force - must be GTC, IOC, FOK, DAY or GTD", http.StatusBadRequest)
+		return
+	}
+
+	if (tif == engine.GTD) != (req.GoodTillDate != "") {
+		s.respondError(w, "good_till_date is required for GTD orders and not allowed otherwise", http.StatusBadRequest)
+		return
+	}
+
+	needsPrice := orderType == engine.LIMIT || orderType == engine.STOP_LIMIT
+	needsStop := orderType == engine.STOP || orderType == engine.STOP_LIMIT
+	if req.Symbol == "" || req.Qty <= 0 || (needsPrice && req.Price <= 0) || (needsStop && req.StopPrice <= 0) {
 		s.respondError(w, "Invalid order parameters", http.StatusBadRequest)
 		return
 	}
 
+	if orderType == engine.MARKET && tif != engine.IOC && tif != engine.FOK {
+		s.respondError(w, "Market orders must be IOC or FOK", http.StatusBadRequest)
+		return
+	}
+
+	postOnly := engine.POST_ONLY_NONE
+	switch strings.ToUpper(req.PostOnly) {
+	case "":
+	case "REJECT":
+		postOnly = engine.POST_ONLY_REJECT
+	case "SLIDE":
+		postOnly = engine.POST_ONLY_SLIDE
+	default:
+		s.respondError(w, "Invalid post_only - must be REJECT or SLIDE", http.StatusBadRequest)
+		return
+	}
+
+	if postOnly != engine.POST_ONLY_NONE && (orderType != engine.LIMIT || tif == engine.IOC || tif == engine.FOK) {
+		s.respondError(w, "Post-only orders must be resting limit orders", http.StatusBadRequest)
+		return
+	}
+
+	if req.DisplayQty < 0 || req.DisplayQty > req.Qty {
+		s.respondError(w, "Invalid display_qty - must be between 1 and qty", http.StatusBadRequest)
+		return
+	}
+
+	if req.DisplayQty > 0 && (orderType != engine.LIMIT || tif == engine.IOC || tif == engine.FOK) {
+		s.respondError(w, "Iceberg orders must be resting limit orders", http.StatusBadRequest)
+		return
+	}
+
+	selfTrade, err := engine.ParseSelfTradeMode(req.SelfTrade)
+	if err != nil {
+		s.respondError(w, "Invalid self_trade - must be OFF, CANCEL_NEWEST, CANCEL_OLDEST, CANCEL_BOTH or DECREMENT", http.StatusBadRequest)
+		return
+	}
+
 	queueDepth := s.engine.GetQueueDepth()
 	if s.monitor.ShouldThrottle(queueDepth) {
 		s.respondError(w, "System under heavy load - order throttled", http.StatusServiceUnavailable)
@@ -76,7 +216,57 @@ func (s *Server) handleOrder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	order := engine.NewOrder(req.Symbol, side, req.Price, req.Qty, req.UserID)
+	var order *engine.Order
+	switch orderType {
+	case engine.MARKET:
+		order = s.engine.NewMarketOrder(req.Symbol, side, req.Qty, tif, req.UserID)
+	case engine.STOP:
+		order = s.engine.NewStopOrder(req.Symbol, side, req.StopPrice, 0, req.Qty, req.UserID)
+		order.TimeInForce = tif
+	case engine.STOP_LIMIT:
+		order = s.engine.NewStopOrder(req.Symbol, side, req.StopPrice, req.Price, req.Qty, req.UserID)
+		order.TimeInForce = tif
+	default:
+		order = s.engine.NewOrder(req.Symbol, side, req.Price, req.Qty, req.UserID)
+		order.TimeInForce = tif
+		order.PostOnly = postOnly
+		order.DisplayQty = req.DisplayQty
+	}
+
+	order.SelfTrade = selfTrade
+	order.GoodTillDate = req.GoodTillDate
+
+	if err := inst.ValidateOrder(order); err != nil {
+		s.respondError(w, "Invalid order - "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if reason := s.engine.CheckSession(order); reason != "" {
+		s.respondError(w, "Invalid order - "+reason, http.StatusBadRequest)
+		return
+	}
+
+	if rejection := s.risk.Check(order); rejection != nil {
+		s.rejectRisk(w, order.UserID, rejection)
+		return
+	}
+
+	if reason := s.engine.CheckFunds(order); reason != "" {
+		s.respondError(w, "Order rejected - "+reason, http.StatusBadRequest)
+		return
+	}
+
+	// Only a submitted order is journaled before the response; a queued
+	// one is lost if the process dies first.
+	if r.URL.Query().Get("async") != "true" {
+		s.submitOrder(w, r, order)
+		return
+	}
+
+	if s.engine.Stopped() {
+		s.respondError(w, "Engine stopped", http.StatusServiceUnavailable)
+		return
+	}
 
 	select {
 	case s.engine.GetOrderChan() <- order:
@@ -84,12 +274,15 @@ func (s *Server) handleOrder(w http.ResponseWriter, r *http.Request) {
 			"order_id", order.ID,
 			"symbol", order.Symbol,
 			"side", order.Side,
+			"type", order.Type,
+			"time_in_force", order.TimeInForce,
 			"price", order.Price,
+			"stop_price", order.StopPrice,
 			"qty", order.Qty,
 		)
 
 		s.respondJSON(w, OrderResponse{
-			Status:  "accepted",
+			Status:  "queued",
 			OrderID: order.ID.String(),
 		}, http.StatusAccepted)
 	default:
@@ -97,6 +290,148 @@ func (s *Server) handleOrder(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (s *Server) submitOrder(w http.ResponseWriter, r *http.Request, order *engine.Order) {
+	ctx, cancel := context.WithTimeout(r.Context(), syncOrderTimeout)
+	defer cancel()
+
+	result, err := s.engine.Submit(ctx, order)
+	if errors.Is(err, engine.ErrEngineStopped) {
+		s.respondError(w, "Engine stopped", http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		s.logger.Warn("Synchronous order timed out", "order_id", order.ID, "error", err)
+		s.respondJSON(w, OrderResponse{
+			Status:  "timeout",
+			OrderID: order.ID.String(),
+			Message: err.Error(),
+		}, http.StatusGatewayTimeout)
+		return
+	}
+
+	if result.Trades == nil {
+		result.Trades = []engine.Trade{}
+	}
+	status := http.StatusOK
+	if result.Order.Status == engine.REJECTED {
+		status = http.StatusBadRequest
+	}
+	s.respondJSON(w, SubmitResponse{
+		OrderStatusResponse: orderStatus(result.Order),
+		Reason:              result.Reason,
+		Trades:              result.Trades,
+	}, status)
+}
+
+func (s *Server) handleOrderByID(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(r.URL.Path, "/")
+	if len(parts) < 3 || parts[2] == "" {
+		s.respondError(w, "Order ID required in URL path", http.StatusBadRequest)
+		return
+	}
+
+	orderID, err := uuid.Parse(parts[2])
+	if err != nil {
+		s.respondError(w, "Invalid order ID", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		s.handleGetOrder(w, orderID)
+	case http.MethodDelete:
+		s.handleCancelOrder(w, orderID)
+	case http.MethodPatch:
+		s.handleAmendOrder(w, r, orderID)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleGetOrder(w http.ResponseWriter, orderID uuid.UUID) {
+	order, exists := s.engine.GetOrder(orderID)
+	if !exists {
+		s.respondError(w, "Order not found", http.StatusNotFound)
+		return
+	}
+
+	s.respondJSON(w, orderStatus(order), http.StatusOK)
+}
+
+func orderStatus(order engine.Order) OrderStatusResponse {
+	return OrderStatusResponse{
+		OrderID:      order.ID.String(),
+		Symbol:       order.Symbol,
+		Side:         order.Side.String(),
+		Type:         order.Type.String(),
+		TimeInForce:  order.TimeInForce.String(),
+		GoodTillDate: order.GoodTillDate,
+		Status:       order.Status.String(),
+		Price:        order.Price,
+		StopPrice:    order.StopPrice,
+		Qty:          order.OrigQty(),
+		FilledQty:    order.FilledQty,
+		LeavesQty:    order.LeavesQty(),
+		AvgPrice:     order.AvgPrice,
+		UserID:       order.UserID,
+		Timestamp:    order.Timestamp,
+	}
+}
+
+func (s *Server) handleCancelOrder(w http.ResponseWriter, orderID uuid.UUID) {
+	result := s.engine.CancelOrder(orderID)
+
+	status := http.StatusOK
+	switch result {
+	case engine.CANCEL_FILLED, engine.CANCEL_ALREADY_CANCELLED, engine.CANCEL_ALREADY_EXPIRED, engine.CANCEL_ALREADY_REJECTED:
+		status = http.StatusConflict
+	case engine.CANCEL_UNKNOWN:
+		status = http.StatusNotFound
+	case engine.CANCEL_REJECTED:
+		status = http.StatusServiceUnavailable
+	}
+
+	s.respondJSON(w, OrderResponse{
+		Status:  result.String(),
+		OrderID: orderID.String(),
+	}, status)
+}
+
+func (s *Server) handleAmendOrder(w http.ResponseWriter, r *http.Request, orderID uuid.UUID) {
+	var req AmendRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.respondError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if order, exists := s.engine.GetOrder(orderID); exists {
+		if rejection := s.risk.CheckAmend(order, req.Price, req.Qty); rejection != nil {
+			s.rejectRisk(w, order.UserID, rejection)
+			return
+		}
+	}
+
+	result := s.engine.AmendOrder(orderID, req.Price, req.Qty)
+
+	status := http.StatusOK
+	switch result {
+	case engine.AMEND_FILLED, engine.AMEND_ALREADY_CANCELLED, engine.AMEND_ALREADY_EXPIRED,
+		engine.AMEND_ALREADY_REJECTED, engine.AMEND_HALTED:
+		status = http.StatusConflict
+	case engine.AMEND_UNKNOWN:
+		status = http.StatusNotFound
+	case engine.AMEND_INVALID, engine.AMEND_INSUFFICIENT_FUNDS:
+		status = http.StatusBadRequest
+	case engine.AMEND_REJECTED:
+		status = http.StatusServiceUnavailable
+	}
+
+	s.respondJSON(w, OrderResponse{
+		Status:  result.String(),
+		OrderID: orderID.String(),
+	}, status)
+}
+
 func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -110,8 +445,14 @@ func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
 		"monitor":         monitorStats,
 		"market_maker":    mmStats,
 		"queue_depth":     s.engine.GetQueueDepth(),
+		"shard_queues":    s.engine.ShardQueueDepths(),
+		"last_seq":        s.engine.LastSeq(),
+		"fees":            s.monitor.GetFeeStats(),
 		"injection_count": s.selfHealer.GetInjectionCount(),
 	}
+	if phases := s.engine.SessionPhases(); phases != nil {
+		response["session"] = phases
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
@@ -164,6 +505,75 @@ func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 	s.logger.Info("WebSocket client connected", "remote", r.RemoteAddr)
 }
 
+func (s *Server) handleClientMessage(client *WebSocketClient, message []byte) {
+	var msg ClientMessage
+	if err := json.Unmarshal(message, &msg); err != nil {
+		s.wsHub.Send(client, OrderResponse{Status: "error", Message: "Invalid message"})
+		return
+	}
+
+	switch msg.Action {
+	case "cancel":
+		orderID, err := uuid.Parse(msg.OrderID)
+		if err != nil {
+			s.wsHub.Send(client, OrderResponse{Status: "error", Message: "Invalid order ID"})
+			return
+		}
+		result := s.engine.CancelOrder(orderID)
+		s.wsHub.Send(client, OrderResponse{
+			Status:  result.String(),
+			OrderID: orderID.String(),
+		})
+	case "amend":
+		orderID, err := uuid.Parse(msg.OrderID)
+		if err != nil {
+			s.wsHub.Send(client, OrderResponse{Status: "error", Message: "Invalid order ID"})
+			return
+		}
+		if order, exists := s.engine.GetOrder(orderID); exists {
+			if rejection := s.risk.CheckAmend(order, msg.Price, msg.Qty); rejection != nil {
+				s.wsHub.Send(client, OrderResponse{
+					Status:  "rejected",
+					OrderID: orderID.String(),
+					Message: rejection.Message,
+					Reason:  string(rejection.Code),
+				})
+				return
+			}
+		}
+		result := s.engine.AmendOrder(orderID, msg.Price, msg.Qty)
+		s.wsHub.Send(client, OrderResponse{
+			Status:  result.String(),
+			OrderID: orderID.String(),
+		})
+	case "subscribe":
+		switch msg.Channel {
+		case "", eventsTopic:
+			s.wsHub.Subscribe(client, eventsTopic)
+			s.wsHub.Send(client, EventsResponse{Type: "subscribed", Events: []engine.Event{}, LastSeq: s.engine.LastSeq()})
+		case positionsTopic:
+			if msg.User == "" {
+				s.wsHub.Send(client, OrderResponse{Status: "error", Message: "User required"})
+				return
+			}
+			s.wsHub.Subscribe(client, positionsTopic+":"+msg.User)
+			s.wsHub.Send(client, PositionMessage{Type: "positions", Positions: s.positions.Get(msg.User)})
+		default:
+			s.wsHub.Send(client, OrderResponse{Status: "error", Message: "Unknown channel"})
+		}
+	case "recover":
+		if msg.From == 0 || (msg.To != 0 && msg.To < msg.From) {
+			s.wsHub.Send(client, OrderResponse{Status: "error", Message: "Invalid sequence range"})
+			return
+		}
+		resp, _ := s.recoverEvents(msg.From, msg.To, msg.Symbol, 0)
+		resp.Type = "events"
+		s.wsHub.Send(client, resp)
+	default:
+		s.wsHub.Send(client, OrderResponse{Status: "error", Message: "Unknown action"})
+	}
+}
+
 func (s *Server) respondJSON(w http.ResponseWriter, data interface{}, status int) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
@@ -0,0 +1,149 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/AkshatMadhani/nanopulse/engine"
+)
+
+func (s *Server) handleInstruments(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	parts := strings.Split(r.URL.Path, "/")
+	if len(parts) < 3 || parts[2] == "" {
+		s.respondJSON(w, s.engine.Instruments().List(), http.StatusOK)
+		return
+	}
+
+	inst, exists := s.engine.GetInstrument(strings.ToUpper(parts[2]))
+	if !exists {
+		s.respondError(w, "Instrument not found", http.StatusNotFound)
+		return
+	}
+	s.respondJSON(w, inst, http.StatusOK)
+}
+
+func (s *Server) handleAdminInstrument(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(r.URL.Path, "/")
+	if len(parts) < 4 || parts[3] == "" {
+		s.respondError(w, "Symbol required in URL path", http.StatusBadRequest)
+		return
+	}
+	symbol := strings.ToUpper(parts[3])
+
+	if len(parts) == 5 {
+		switch parts[4] {
+		case "status":
+			s.handleInstrumentStatus(w, r, symbol)
+			return
+		case "halt":
+			s.handleTradingState(w, r, symbol, engine.HALTED)
+			return
+		case "resume":
+			s.handleTradingState(w, r, symbol, engine.CONTINUOUS)
+			return
+		case "auction":
+			s.handleTradingState(w, r, symbol, engine.AUCTION)
+			return
+		}
+	}
+
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	inst := engine.DefaultInstrument(symbol)
+	if existing, exists := s.engine.GetInstrument(symbol); exists {
+		inst = existing
+	}
+	if err := json.NewDecoder(r.Body).Decode(&inst); err != nil {
+		s.respondError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	inst.Symbol = symbol
+
+	if err := s.engine.SetInstrument(inst); err != nil {
+		s.respondError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.logger.Info("Instrument updated",
+		"symbol", inst.Symbol,
+		"tick_size", inst.TickSize,
+		"lot_size", inst.LotSize,
+		"status", inst.Status,
+	)
+	s.respondJSON(w, inst, http.StatusOK)
+}
+
+func (s *Server) handleInstrumentStatus(w http.ResponseWriter, r *http.Request, symbol string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Status engine.InstrumentStatus `json:"status"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.respondError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.engine.SetInstrumentStatus(symbol, req.Status); err != nil {
+		s.respondError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	s.logger.Info("Instrument status changed", "symbol", symbol, "status", req.Status)
+	inst, _ := s.engine.GetInstrument(symbol)
+	s.respondJSON(w, inst, http.StatusOK)
+}
+
+// handleTradingState halts, resumes or starts a call auction in symbol by
+// hand. Resuming from an auction uncrosses the book. The body is optional
+// and may give a reason, which goes out on the trading state event.
+func (s *Server) handleTradingState(w http.ResponseWriter, r *http.Request, symbol string, state engine.TradingState) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		s.respondError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Reason == "" {
+		switch state {
+		case engine.HALTED:
+			req.Reason = "manual halt"
+		case engine.AUCTION:
+			req.Reason = "manual auction"
+		default:
+			req.Reason = "manual resume"
+		}
+	}
+
+	if err := s.engine.SetTradingState(symbol, state, req.Reason); err != nil {
+		status := http.StatusServiceUnavailable
+		if errors.Is(err, engine.ErrUnknownSymbol) {
+			status = http.StatusNotFound
+		}
+		s.respondError(w, err.Error(), status)
+		return
+	}
+
+	s.logger.Info("Trading state set by admin", "symbol", symbol, "state", state, "reason", req.Reason)
+	s.respondJSON(w, s.engine.TradingStatus(symbol), http.StatusOK)
+}
@@ -0,0 +1,33 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/AkshatMadhani/nanopulse/positions"
+)
+
+type PositionMessage struct {
+	Type      string            `json:"type"`
+	Positions positions.Summary `json:"positions"`
+}
+
+func (s *Server) startPositionListener() {
+	for summary := range s.positions.Updates() {
+		s.wsHub.Publish(positionsTopic+":"+summary.User, PositionMessage{Type: "positions", Positions: summary})
+	}
+}
+
+func (s *Server) handlePositions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	parts := strings.Split(r.URL.Path, "/")
+	if len(parts) < 3 || parts[2] == "" {
+		s.respondError(w, "User required in URL path", http.StatusBadRequest)
+		return
+	}
+	s.respondJSON(w, s.positions.Get(parts[2]), http.StatusOK)
+}
@@ -0,0 +1,135 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/AkshatMadhani/nanopulse/risk"
+)
+
+func (s *Server) rejectRisk(w http.ResponseWriter, userID string, rejection *risk.Rejection) {
+	s.logger.Warn("Order rejected by risk check",
+		"user_id", userID,
+		"reason", rejection.Code,
+		"message", rejection.Message,
+	)
+
+	status := http.StatusBadRequest
+	if rejection.Code == risk.REJECT_ORDER_RATE {
+		status = http.StatusTooManyRequests
+	}
+	s.respondJSON(w, OrderResponse{
+		Status:  "rejected",
+		Message: rejection.Message,
+		Reason:  string(rejection.Code),
+	}, status)
+}
+
+// handleAdminRisk serves GET and PUT /admin/risk for the whole set of
+// limits, and PUT or DELETE /admin/risk/instruments/{symbol} and
+// /admin/risk/accounts/{id} for one override. A PUT override starts from
+// the limits currently in force, so fields left out keep their values.
+func (s *Server) handleAdminRisk(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimSuffix(r.URL.Path, "/"), "/")
+	if len(parts) == 3 {
+		s.handleRiskLimits(w, r)
+		return
+	}
+	if len(parts) != 5 || parts[4] == "" {
+		s.respondError(w, "Expected /admin/risk/instruments/{symbol} or /admin/risk/accounts/{id}", http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodPut && r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limits := s.risk.Limits()
+	switch parts[3] {
+	case "instruments":
+		symbol := strings.ToUpper(parts[4])
+		if r.Method == http.MethodDelete {
+			s.risk.ClearInstrumentLimits(symbol)
+			break
+		}
+		inst, exists := limits.Instruments[symbol]
+		if !exists {
+			inst = limits.Instrument
+		}
+		if err := json.NewDecoder(r.Body).Decode(&inst); err != nil {
+			s.respondError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if err := s.risk.SetInstrumentLimits(symbol, inst); err != nil {
+			s.respondError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.logger.Info("Instrument risk limits updated",
+			"symbol", symbol,
+			"max_order_qty", inst.MaxOrderQty,
+			"max_notional", inst.MaxNotional,
+			"price_collar_pct", inst.PriceCollarPct,
+		)
+	case "accounts":
+		id := parts[4]
+		if r.Method == http.MethodDelete {
+			s.risk.ClearAccountLimits(id)
+			break
+		}
+		acct, exists := limits.Accounts[id]
+		if !exists {
+			acct = limits.Account
+		}
+		if err := json.NewDecoder(r.Body).Decode(&acct); err != nil {
+			s.respondError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if err := s.risk.SetAccountLimits(id, acct); err != nil {
+			s.respondError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.logger.Info("Account risk limits updated",
+			"account", id,
+			"max_order_qty", acct.MaxOrderQty,
+			"max_notional", acct.MaxNotional,
+			"max_open_orders", acct.MaxOpenOrders,
+			"max_orders_per_sec", acct.MaxOrdersPerSec,
+		)
+	default:
+		s.respondError(w, "Expected /admin/risk/instruments/{symbol} or /admin/risk/accounts/{id}", http.StatusNotFound)
+		return
+	}
+
+	s.respondJSON(w, s.risk.Limits(), http.StatusOK)
+}
+
+func (s *Server) handleRiskLimits(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var limits risk.Limits
+		if err := json.NewDecoder(r.Body).Decode(&limits); err != nil {
+			s.respondError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		instruments := make(map[string]risk.InstrumentLimits, len(limits.Instruments))
+		for symbol, inst := range limits.Instruments {
+			instruments[strings.ToUpper(symbol)] = inst
+		}
+		limits.Instruments = instruments
+		if err := s.risk.SetLimits(limits); err != nil {
+			s.respondError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.logger.Info("Risk limits replaced",
+			"instruments", len(limits.Instruments),
+			"accounts", len(limits.Accounts),
+		)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.respondJSON(w, s.risk.Limits(), http.StatusOK)
+}
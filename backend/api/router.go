@@ -1,12 +1,17 @@
 package api
 
 import (
+	"context"
+	"errors"
 	"net/http"
 
 	"github.com/AkshatMadhani/nanopulse/engine"
 	"github.com/AkshatMadhani/nanopulse/logger"
 	"github.com/AkshatMadhani/nanopulse/market"
 	"github.com/AkshatMadhani/nanopulse/monitor"
+	"github.com/AkshatMadhani/nanopulse/positions"
+	"github.com/AkshatMadhani/nanopulse/risk"
+	"github.com/AkshatMadhani/nanopulse/snapshot"
 	"github.com/gorilla/websocket"
 )
 
@@ -23,10 +28,15 @@ type Server struct {
 	monitor     *monitor.Monitor
 	marketMaker *market.Bot
 	selfHealer  *monitor.SelfHealer
+	positions   *positions.Keeper
+	risk        *risk.Gateway
 	logger      *logger.Logger
 	wsHub       *WebSocketHub
 	tradeChan   <-chan *engine.Trade
 	tradeBuffer *TradeBuffer
+	adminToken  string
+	snapshots   *snapshot.Manager
+	httpServer  *http.Server
 }
 
 func NewServer(
@@ -34,20 +44,29 @@ func NewServer(
 	mon *monitor.Monitor,
 	mm *market.Bot,
 	sh *monitor.SelfHealer,
+	pos *positions.Keeper,
+	gw *risk.Gateway,
+	trades <-chan *engine.Trade,
 	log *logger.Logger,
 ) *Server {
 	hub := NewWebSocketHub(log)
 
-	return &Server{
+	s := &Server{
 		engine:      eng,
 		monitor:     mon,
 		marketMaker: mm,
 		selfHealer:  sh,
+		positions:   pos,
+		risk:        gw,
 		logger:      log,
 		wsHub:       hub,
-		tradeChan:   eng.GetTradeChan(),
+		tradeChan:   trades,
 		tradeBuffer: NewTradeBuffer(),
+		httpServer:  &http.Server{},
 	}
+	hub.onMessage = s.handleClientMessage
+
+	return s
 }
 
 func (s *Server) SetupRoutes() *http.ServeMux {
@@ -55,10 +74,22 @@ func (s *Server) SetupRoutes() *http.ServeMux {
 
 	mux.HandleFunc("/health", s.handleHealth)
 	mux.HandleFunc("/order", s.handleOrder)
+	mux.HandleFunc("/order/", s.handleOrderByID)
 	mux.HandleFunc("/stats", s.handleStats)
 	mux.HandleFunc("/book/", s.handleOrderBook)
+	mux.HandleFunc("/events", s.handleEvents)
+	mux.HandleFunc("/instruments", s.handleInstruments)
+	mux.HandleFunc("/instruments/", s.handleInstruments)
+	mux.HandleFunc("/accounts/", s.handleAccount)
+	mux.HandleFunc("/positions/", s.handlePositions)
 	mux.HandleFunc("/ws", s.handleWebSocket)
 
+	mux.HandleFunc("/admin/instruments/", s.adminOnly(s.handleAdminInstrument))
+	mux.HandleFunc("/admin/accounts/", s.adminOnly(s.handleAdminAccount))
+	mux.HandleFunc("/admin/risk", s.adminOnly(s.handleAdminRisk))
+	mux.HandleFunc("/admin/risk/", s.adminOnly(s.handleAdminRisk))
+	mux.HandleFunc("/admin/snapshot", s.adminOnly(s.handleAdminSnapshot))
+
 	return mux
 }
 
@@ -67,17 +98,30 @@ func (s *Server) Start(port string) error {
 
 	go s.wsHub.Run()
 	go s.startTradeListener()
+	go s.startEventListener()
+	go s.startPositionListener()
 	go s.broadcastSystemState()
 
 	mux := s.SetupRoutes()
-	return http.ListenAndServe(":"+port, s.corsMiddleware(mux))
+	s.httpServer.Addr = ":" + port
+	s.httpServer.Handler = s.corsMiddleware(mux)
+	if err := s.httpServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Shutdown stops accepting requests and waits for those in flight, so
+// nothing reaches the engine once it returns.
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.httpServer.Shutdown(ctx)
 }
 
 func (s *Server) corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Admin-Token")
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusOK)
 			return
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"time"
 
+	"github.com/AkshatMadhani/nanopulse/engine"
 	"github.com/AkshatMadhani/nanopulse/logger"
 	"github.com/gorilla/websocket"
 )
@@ -21,21 +22,64 @@ type WebSocketClient struct {
 	send chan []byte
 }
 
+// Topics a client can subscribe to. Position topics are per user:
+// positionsTopic + ":" + user.
+const (
+	eventsTopic    = "events"
+	positionsTopic = "positions"
+)
+
 type WebSocketHub struct {
-	clients    map[*WebSocketClient]bool
-	broadcast  chan []byte
-	register   chan *WebSocketClient
-	unregister chan *WebSocketClient
-	logger     *logger.Logger
+	clients     map[*WebSocketClient]bool
+	subscribers map[string]map[*WebSocketClient]bool
+	broadcast   chan []byte
+	events      chan topicMessage
+	direct      chan directMessage
+	register    chan *WebSocketClient
+	unregister  chan *WebSocketClient
+	subscribe   chan subscription
+	onMessage   func(client *WebSocketClient, message []byte)
+	logger      *logger.Logger
+}
+
+type directMessage struct {
+	client *WebSocketClient
+	data   []byte
+}
+
+type topicMessage struct {
+	topic string
+	data  []byte
+}
+
+type subscription struct {
+	client *WebSocketClient
+	topic  string
+}
+
+type ClientMessage struct {
+	Action  string       `json:"action"`
+	OrderID string       `json:"order_id"`
+	Price   engine.Price `json:"price"`
+	Qty     int          `json:"qty"`
+	Symbol  string       `json:"symbol"`
+	Channel string       `json:"channel"`
+	User    string       `json:"user"`
+	From    uint64       `json:"from"`
+	To      uint64       `json:"to"`
 }
 
 func NewWebSocketHub(log *logger.Logger) *WebSocketHub {
 	return &WebSocketHub{
-		clients:    make(map[*WebSocketClient]bool),
-		broadcast:  make(chan []byte, 256),
-		register:   make(chan *WebSocketClient),
-		unregister: make(chan *WebSocketClient),
-		logger:     log,
+		clients:     make(map[*WebSocketClient]bool),
+		subscribers: make(map[string]map[*WebSocketClient]bool),
+		broadcast:   make(chan []byte, 256),
+		events:      make(chan topicMessage, 1024),
+		direct:      make(chan directMessage, 256),
+		register:    make(chan *WebSocketClient),
+		unregister:  make(chan *WebSocketClient),
+		subscribe:   make(chan subscription),
+		logger:      log,
 	}
 }
 
@@ -49,6 +93,7 @@ func (h *WebSocketHub) Run() {
 		case client := <-h.unregister:
 			if _, ok := h.clients[client]; ok {
 				delete(h.clients, client)
+				h.unsubscribeAll(client)
 				close(client.send)
 				h.logger.Info("WebSocket client unregistered", "total_clients", len(h.clients))
 			}
@@ -60,6 +105,35 @@ func (h *WebSocketHub) Run() {
 				default:
 					close(client.send)
 					delete(h.clients, client)
+					h.unsubscribeAll(client)
+				}
+			}
+
+		case sub := <-h.subscribe:
+			if _, ok := h.clients[sub.client]; ok {
+				if h.subscribers[sub.topic] == nil {
+					h.subscribers[sub.topic] = make(map[*WebSocketClient]bool)
+				}
+				h.subscribers[sub.topic][sub.client] = true
+			}
+
+		// A slow subscriber misses messages rather than being dropped; on
+		// the event feed the gap shows in the sequence numbers and it can
+		// recover.
+		case message := <-h.events:
+			for client := range h.subscribers[message.topic] {
+				select {
+				case client.send <- message.data:
+				default:
+				}
+			}
+
+		case msg := <-h.direct:
+			if _, ok := h.clients[msg.client]; ok {
+				select {
+				case msg.client.send <- msg.data:
+				default:
+					h.logger.Warn("WebSocket client send buffer full, dropping reply")
 				}
 			}
 		}
@@ -75,12 +149,45 @@ func (h *WebSocketHub) Broadcast(message interface{}) {
 	h.broadcast <- data
 }
 
+func (h *WebSocketHub) unsubscribeAll(client *WebSocketClient) {
+	for topic, clients := range h.subscribers {
+		delete(clients, client)
+		if len(clients) == 0 {
+			delete(h.subscribers, topic)
+		}
+	}
+}
+
+func (h *WebSocketHub) Subscribe(client *WebSocketClient, topic string) {
+	h.subscribe <- subscription{client: client, topic: topic}
+}
+
+// Publish sends message to clients subscribed to topic.
+func (h *WebSocketHub) Publish(topic string, message interface{}) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		h.logger.Error("Failed to marshal event message", "topic", topic, "error", err)
+		return
+	}
+	h.events <- topicMessage{topic: topic, data: data}
+}
+
+func (h *WebSocketHub) Send(client *WebSocketClient, message interface{}) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		h.logger.Error("Failed to marshal client message", "error", err)
+		return
+	}
+	h.direct <- directMessage{client: client, data: data}
+}
+
 func (c *WebSocketClient) readPump() {
 	defer func() {
 		c.hub.unregister <- c
 		c.conn.Close()
 	}()
 
+	c.conn.SetReadLimit(maxMessageSize)
 	c.conn.SetReadDeadline(time.Now().Add(pongWait))
 	c.conn.SetPongHandler(func(string) error {
 		c.conn.SetReadDeadline(time.Now().Add(pongWait))
@@ -88,13 +195,16 @@ func (c *WebSocketClient) readPump() {
 	})
 
 	for {
-		_, _, err := c.conn.ReadMessage()
+		_, message, err := c.conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				c.hub.logger.Error("WebSocket error", "error", err)
 			}
 			break
 		}
+		if c.hub.onMessage != nil {
+			c.hub.onMessage(c, message)
+		}
 	}
 }
 
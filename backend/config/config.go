@@ -0,0 +1,375 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/AkshatMadhani/nanopulse/engine"
+	"github.com/AkshatMadhani/nanopulse/journal"
+	"github.com/AkshatMadhani/nanopulse/risk"
+	"gopkg.in/yaml.v3"
+)
+
+type Config struct {
+	Server         ServerConfig         `yaml:"server"`
+	MatchingEngine MatchingEngineConfig `yaml:"matching_engine"`
+	Journal        JournalConfig        `yaml:"journal"`
+	Snapshot       SnapshotConfig       `yaml:"snapshot"`
+	Instruments    []InstrumentConfig   `yaml:"instruments"`
+	// SelfTradePrevention maps a user ID to its default self-trade mode.
+	SelfTradePrevention map[string]string `yaml:"self_trade_prevention"`
+	Accounts            AccountsConfig    `yaml:"accounts"`
+	Fees                FeeConfig         `yaml:"fees"`
+	Risk                RiskConfig        `yaml:"risk"`
+	CircuitBreakers     BreakersConfig    `yaml:"circuit_breakers"`
+	Session             SessionConfig     `yaml:"session"`
+}
+
+type ServerConfig struct {
+	AdminToken string `yaml:"admin_token"`
+}
+
+type MatchingEngineConfig struct {
+	OrderBufferSize int `yaml:"order_buffer_size"`
+	Shards          int `yaml:"shards"`
+	// RetainedOrders is how many finished orders each shard keeps for
+	// status queries.
+	RetainedOrders int `yaml:"retained_orders"`
+}
+
+type InstrumentConfig struct {
+	Symbol         string         `yaml:"symbol"`
+	ReferencePrice float64        `yaml:"reference_price"`
+	TickSize       float64        `yaml:"tick_size"`
+	LotSize        int            `yaml:"lot_size"`
+	PricePrecision int            `yaml:"price_precision"`
+	Currency       string         `yaml:"currency"`
+	Status         string         `yaml:"status"`
+	Matching       MatchingConfig `yaml:"matching"`
+}
+
+// MatchingConfig picks how orders resting at a price share an incoming
+// order: FIFO, PRO_RATA, PRO_RATA_TOP or SPLIT.
+type MatchingConfig struct {
+	Algorithm string  `yaml:"algorithm"`
+	MinQty    int     `yaml:"min_qty"`
+	TopQty    int     `yaml:"top_qty"`
+	FIFOPct   float64 `yaml:"fifo_pct"`
+}
+
+type JournalConfig struct {
+	Enabled        bool   `yaml:"enabled"`
+	Dir            string `yaml:"dir"`
+	Sync           string `yaml:"sync"`
+	SyncIntervalMs int    `yaml:"sync_interval_ms"`
+	SegmentSizeMB  int    `yaml:"segment_size_mb"`
+}
+
+// AccountsConfig controls buying-power checks. Exempt accounts are tracked
+// but may trade without funds. OpeningCash funds an account the first time
+// the engine starts without it.
+type AccountsConfig struct {
+	Enforce     bool               `yaml:"enforce"`
+	Exempt      []string           `yaml:"exempt"`
+	OpeningCash map[string]float64 `yaml:"opening_cash"`
+}
+
+type FeeRatesConfig struct {
+	MakerBps float64 `yaml:"maker_bps"`
+	TakerBps float64 `yaml:"taker_bps"`
+}
+
+// FeeConfig sets default rates, overrides per instrument and per tier, and
+// which tier each account is on. A tier's rates take precedence over the
+// instrument's.
+type FeeConfig struct {
+	FeeRatesConfig `yaml:",inline"`
+	Instruments    map[string]FeeRatesConfig `yaml:"instruments"`
+	Tiers          map[string]FeeRatesConfig `yaml:"tiers"`
+	Accounts       map[string]string         `yaml:"accounts"`
+}
+
+func (c FeeRatesConfig) rates() engine.FeeRates {
+	return engine.FeeRates{MakerBps: c.MakerBps, TakerBps: c.TakerBps}
+}
+
+func (c FeeConfig) Schedule() engine.FeeSchedule {
+	s := engine.FeeSchedule{
+		Default:     c.rates(),
+		Instruments: make(map[string]engine.FeeRates, len(c.Instruments)),
+		Tiers:       make(map[string]engine.FeeRates, len(c.Tiers)),
+		Accounts:    c.Accounts,
+	}
+	for symbol, rates := range c.Instruments {
+		s.Instruments[strings.ToUpper(symbol)] = rates.rates()
+	}
+	for tier, rates := range c.Tiers {
+		s.Tiers[tier] = rates.rates()
+	}
+	return s
+}
+
+type InstrumentRiskConfig struct {
+	MaxOrderQty    int     `yaml:"max_order_qty"`
+	MaxNotional    float64 `yaml:"max_notional"`
+	PriceCollarPct float64 `yaml:"price_collar_pct"`
+}
+
+type AccountRiskConfig struct {
+	MaxOrderQty     int     `yaml:"max_order_qty"`
+	MaxNotional     float64 `yaml:"max_notional"`
+	MaxOpenOrders   int     `yaml:"max_open_orders"`
+	MaxOrdersPerSec int     `yaml:"max_orders_per_sec"`
+}
+
+// RiskConfig sets the pre-trade limits every instrument and account gets by
+// default, and overrides that replace them for particular ones.
+type RiskConfig struct {
+	Instrument  InstrumentRiskConfig            `yaml:"instrument"`
+	Account     AccountRiskConfig               `yaml:"account"`
+	Instruments map[string]InstrumentRiskConfig `yaml:"instruments"`
+	Accounts    map[string]AccountRiskConfig    `yaml:"accounts"`
+}
+
+func (c InstrumentRiskConfig) limits() risk.InstrumentLimits {
+	return risk.InstrumentLimits{
+		MaxOrderQty:    c.MaxOrderQty,
+		MaxNotional:    engine.PriceFromFloat(c.MaxNotional),
+		PriceCollarPct: c.PriceCollarPct,
+	}
+}
+
+func (c AccountRiskConfig) limits() risk.AccountLimits {
+	return risk.AccountLimits{
+		MaxOrderQty:     c.MaxOrderQty,
+		MaxNotional:     engine.PriceFromFloat(c.MaxNotional),
+		MaxOpenOrders:   c.MaxOpenOrders,
+		MaxOrdersPerSec: c.MaxOrdersPerSec,
+	}
+}
+
+func (c RiskConfig) Limits() risk.Limits {
+	l := risk.Limits{
+		Instrument:  c.Instrument.limits(),
+		Account:     c.Account.limits(),
+		Instruments: make(map[string]risk.InstrumentLimits, len(c.Instruments)),
+		Accounts:    make(map[string]risk.AccountLimits, len(c.Accounts)),
+	}
+	for symbol, limits := range c.Instruments {
+		l.Instruments[strings.ToUpper(symbol)] = limits.limits()
+	}
+	for id, limits := range c.Accounts {
+		l.Accounts[id] = limits.limits()
+	}
+	return l
+}
+
+type CircuitBreakerConfig struct {
+	MovePct   float64 `yaml:"move_pct"`
+	WindowSec int     `yaml:"window_sec"`
+	HaltSec   int     `yaml:"halt_sec"`
+}
+
+// BreakersConfig sets the circuit breaker every instrument gets by default
+// and overrides that replace it for particular ones.
+type BreakersConfig struct {
+	CircuitBreakerConfig `yaml:",inline"`
+	Instruments          map[string]CircuitBreakerConfig `yaml:"instruments"`
+}
+
+func (c CircuitBreakerConfig) breaker() engine.CircuitBreaker {
+	return engine.CircuitBreaker{
+		MovePct: c.MovePct,
+		Window:  time.Duration(c.WindowSec) * time.Second,
+		Halt:    time.Duration(c.HaltSec) * time.Second,
+	}
+}
+
+func (c BreakersConfig) Breakers() engine.CircuitBreakers {
+	b := engine.CircuitBreakers{
+		Default:     c.breaker(),
+		Instruments: make(map[string]engine.CircuitBreaker, len(c.Instruments)),
+	}
+	for symbol, cb := range c.Instruments {
+		b.Instruments[strings.ToUpper(symbol)] = cb.breaker()
+	}
+	return b
+}
+
+// ScheduleConfig is a trading day as "HH:MM" times in Timezone, and the
+// dates, YYYY-MM-DD, it does not trade besides weekends.
+type ScheduleConfig struct {
+	Timezone       string   `yaml:"timezone"`
+	PreOpen        string   `yaml:"pre_open"`
+	Open           string   `yaml:"open"`
+	ClosingAuction string   `yaml:"closing_auction"`
+	Close          string   `yaml:"close"`
+	Holidays       []string `yaml:"holidays"`
+}
+
+// SessionConfig sets the trading day every instrument gets by default and
+// overrides that replace it for particular ones.
+type SessionConfig struct {
+	Enabled        bool `yaml:"enabled"`
+	ScheduleConfig `yaml:",inline"`
+	Instruments    map[string]ScheduleConfig `yaml:"instruments"`
+}
+
+func (c ScheduleConfig) schedule() (engine.Schedule, error) {
+	loc, err := time.LoadLocation(c.Timezone)
+	if err != nil {
+		return engine.Schedule{}, fmt.Errorf("timezone: %w", err)
+	}
+
+	s := engine.Schedule{Location: loc, Holidays: c.Holidays}
+	for _, field := range []struct {
+		name  string
+		value string
+		dst   *time.Duration
+	}{
+		{"pre_open", c.PreOpen, &s.PreOpen},
+		{"open", c.Open, &s.Open},
+		{"closing_auction", c.ClosingAuction, &s.ClosingAuction},
+		{"close", c.Close, &s.Close},
+	} {
+		t, err := time.Parse("15:04", field.value)
+		if err != nil {
+			return engine.Schedule{}, fmt.Errorf("%s: %q is not HH:MM", field.name, field.value)
+		}
+		*field.dst = time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	}
+	return s, nil
+}
+
+func (c SessionConfig) Calendar() (*engine.Calendar, error) {
+	def, err := c.schedule()
+	if err != nil {
+		return nil, fmt.Errorf("session %w", err)
+	}
+	cal := &engine.Calendar{
+		Default:     def,
+		Instruments: make(map[string]engine.Schedule, len(c.Instruments)),
+	}
+	for symbol, sc := range c.Instruments {
+		s, err := sc.schedule()
+		if err != nil {
+			return nil, fmt.Errorf("session instrument %s: %w", symbol, err)
+		}
+		cal.Instruments[strings.ToUpper(symbol)] = s
+	}
+	if err := cal.Validate(); err != nil {
+		return nil, fmt.Errorf("session: %w", err)
+	}
+	return cal, nil
+}
+
+type SnapshotConfig struct {
+	Enabled     bool   `yaml:"enabled"`
+	Dir         string `yaml:"dir"`
+	IntervalSec int    `yaml:"interval_sec"`
+	Keep        int    `yaml:"keep"`
+}
+
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config: %w", err)
+	}
+
+	cfg := Config{
+		MatchingEngine: MatchingEngineConfig{
+			OrderBufferSize: 10000,
+			Shards:          engine.DefaultShardCount,
+			RetainedOrders:  engine.DefaultRetainedOrders,
+		},
+		Journal: JournalConfig{
+			Dir:            "data/journal",
+			Sync:           "interval",
+			SyncIntervalMs: 100,
+			SegmentSizeMB:  64,
+		},
+		Snapshot: SnapshotConfig{
+			Dir:         "data/snapshots",
+			IntervalSec: 300,
+			Keep:        3,
+		},
+	}
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parse config: %w", err)
+	}
+	return &cfg, nil
+}
+
+func (c JournalConfig) Options() (journal.Options, error) {
+	policy, err := journal.ParseSyncPolicy(c.Sync)
+	if err != nil {
+		return journal.Options{}, err
+	}
+
+	return journal.Options{
+		Dir:          c.Dir,
+		Sync:         policy,
+		SyncInterval: time.Duration(c.SyncIntervalMs) * time.Millisecond,
+		SegmentSize:  int64(c.SegmentSizeMB) << 20,
+	}, nil
+}
+
+func (c InstrumentConfig) Instrument() (engine.Instrument, error) {
+	status, err := engine.ParseInstrumentStatus(c.Status)
+	if err != nil {
+		return engine.Instrument{}, err
+	}
+	algorithm, err := engine.ParseMatchingAlgorithm(c.Matching.Algorithm)
+	if err != nil {
+		return engine.Instrument{}, fmt.Errorf("instrument %s: %w", c.Symbol, err)
+	}
+
+	inst := engine.Instrument{
+		Symbol:         c.Symbol,
+		ReferencePrice: engine.PriceFromFloat(c.ReferencePrice),
+		TickSize:       engine.PriceFromFloat(c.TickSize),
+		LotSize:        c.LotSize,
+		PricePrecision: c.PricePrecision,
+		Currency:       c.Currency,
+		Status:         status,
+		Matching: engine.MatchingRule{
+			Algorithm: algorithm,
+			MinQty:    c.Matching.MinQty,
+			TopQty:    c.Matching.TopQty,
+			FIFOPct:   c.Matching.FIFOPct,
+		},
+	}
+	if err := inst.Validate(); err != nil {
+		return engine.Instrument{}, fmt.Errorf("instrument %s: %w", c.Symbol, err)
+	}
+	return inst, nil
+}
+
+// ApplySelfTradeModes registers every configured self-trade mode with eng.
+func (c *Config) ApplySelfTradeModes(eng *engine.MatchingEngine) error {
+	for userID, name := range c.SelfTradePrevention {
+		mode, err := engine.ParseSelfTradeMode(name)
+		if err != nil {
+			return fmt.Errorf("self_trade_prevention %s: %w", userID, err)
+		}
+		eng.SetSelfTradeMode(userID, mode)
+	}
+	return nil
+}
+
+// OpenAccounts deposits the opening cash of every configured account that
+// does not exist yet. It must run after recovery so a restart does not fund
+// an account twice.
+func (c *Config) OpenAccounts(eng *engine.MatchingEngine) error {
+	for id, cash := range c.Accounts.OpeningCash {
+		if _, exists := eng.GetAccount(id); exists {
+			continue
+		}
+		if err := eng.Deposit(id, engine.PriceFromFloat(cash), "", 0); err != nil {
+			return fmt.Errorf("opening_cash %s: %w", id, err)
+		}
+	}
+	return nil
+}
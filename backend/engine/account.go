@@ -0,0 +1,486 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrInsufficientFunds = errors.New("insufficient funds")
+	ErrUnknownAccount    = errors.New("unknown account")
+)
+
+// Account is a copy of one account's balances. Cash and position quantities
+// are totals; Reserved is what open orders hold and Available what is left
+// for new ones.
+type Account struct {
+	ID         string     `json:"id"`
+	Cash       Price      `json:"cash"`
+	Reserved   Price      `json:"reserved"`
+	Available  Price      `json:"available"`
+	OpenOrders int        `json:"open_orders"`
+	Positions  []Position `json:"positions"`
+}
+
+type Position struct {
+	Symbol    string `json:"symbol"`
+	Qty       int    `json:"qty"`
+	Reserved  int    `json:"reserved"`
+	Available int    `json:"available"`
+}
+
+type account struct {
+	cash      Price
+	reserved  Price
+	positions map[string]*position
+}
+
+type position struct {
+	qty      int
+	reserved int
+}
+
+// hold is what one open order has reserved: cash for a buy, shares for a
+// sell.
+type hold struct {
+	account string
+	symbol  string
+	cash    Price
+	qty     int
+}
+
+// ledger tracks every account that has traded or been funded. Only
+// enforced accounts are checked before an order is accepted; exempt ones
+// (house accounts such as the market maker) may go negative. It also counts
+// every user's open orders, funded or not.
+type ledger struct {
+	mu       sync.Mutex
+	accounts map[string]*account
+	holds    map[uuid.UUID]hold
+	live     map[uuid.UUID]string
+	open     map[string]int
+	enforce  bool
+	exempt   map[string]bool
+}
+
+func newLedger() *ledger {
+	return &ledger{
+		accounts: make(map[string]*account),
+		holds:    make(map[uuid.UUID]hold),
+		live:     make(map[uuid.UUID]string),
+		open:     make(map[string]int),
+		exempt:   make(map[string]bool),
+	}
+}
+
+func (l *ledger) get(id string) *account {
+	acct, exists := l.accounts[id]
+	if !exists {
+		acct = &account{positions: make(map[string]*position)}
+		l.accounts[id] = acct
+	}
+	return acct
+}
+
+func (a *account) position(symbol string) *position {
+	pos, exists := a.positions[symbol]
+	if !exists {
+		pos = &position{}
+		a.positions[symbol] = pos
+	}
+	return pos
+}
+
+func (l *ledger) checked(id string) bool {
+	return l.enforce && !l.exempt[id]
+}
+
+func notional(price Price, qty int) Price {
+	return price * Price(qty)
+}
+
+// required is what an open order should have on hold, including the most
+// it could pay in fees. Market buys keep whatever was reserved on entry
+// until they are done.
+func required(o *Order) (cash Price, qty int, keep bool) {
+	switch {
+	case o.Status.Done():
+		return 0, 0, false
+	case o.Side == SELL:
+		return 0, o.Qty, false
+	case o.Type == MARKET || o.Type == STOP:
+		return 0, 0, true
+	default:
+		cash := notional(o.Price, o.Qty)
+		return cash + o.Fees.maxFee(cash), 0, false
+	}
+}
+
+// setHold must be called with l.mu held.
+func (l *ledger) setHold(id uuid.UUID, userID, symbol string, cash Price, qty int) {
+	old := l.holds[id]
+	if old == (hold{}) && cash == 0 && qty == 0 {
+		return
+	}
+
+	acct := l.get(userID)
+	acct.reserved += cash - old.cash
+	if qty != old.qty {
+		acct.position(symbol).reserved += qty - old.qty
+	}
+
+	if cash == 0 && qty == 0 {
+		delete(l.holds, id)
+		return
+	}
+	l.holds[id] = hold{account: userID, symbol: symbol, cash: cash, qty: qty}
+}
+
+// check reports why userID cannot put up cash and qty on top of what the
+// order already holds, or "" if it can.
+func (l *ledger) check(id uuid.UUID, userID, symbol string, cash Price, qty int) string {
+	if !l.checked(userID) {
+		return ""
+	}
+	acct, exists := l.accounts[userID]
+	if !exists {
+		return ErrUnknownAccount.Error()
+	}
+
+	old := l.holds[id]
+	if cash-old.cash > acct.cash-acct.reserved {
+		return "insufficient buying power"
+	}
+	if qty > old.qty {
+		pos := acct.positions[symbol]
+		if pos == nil || qty-old.qty > pos.qty-pos.reserved {
+			return "insufficient position"
+		}
+	}
+	return ""
+}
+
+// need returns the hold an order needs on entry, or why its account cannot
+// cover it. cost is the estimated spend of a market buy. It must be called
+// with l.mu held.
+func (l *ledger) need(o *Order, cost Price) (Price, int, string) {
+	if o.Side == BUY && o.Type == STOP && l.checked(o.UserID) {
+		return 0, 0, "stop buys need a limit price"
+	}
+	cash, qty, keep := required(o)
+	if keep {
+		cash = cost + o.Fees.maxFee(cost)
+	}
+	return cash, qty, l.check(o.ID, o.UserID, o.Symbol, cash, qty)
+}
+
+// reserve checks and places the hold an order needs on entry.
+func (l *ledger) reserve(o *Order, cost Price) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	cash, qty, reason := l.need(o, cost)
+	if reason != "" {
+		return reason
+	}
+	l.setHold(o.ID, o.UserID, o.Symbol, cash, qty)
+	return ""
+}
+
+// settle brings an order's hold in line with its current state.
+func (l *ledger) settle(o *Order) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.count(o)
+	cash, qty, keep := required(o)
+	if keep {
+		return
+	}
+	l.setHold(o.ID, o.UserID, o.Symbol, cash, qty)
+}
+
+// count keeps the open order counts in line with o. It must be called with
+// l.mu held.
+func (l *ledger) count(o *Order) {
+	_, live := l.live[o.ID]
+	switch {
+	case o.Status.Done() && live:
+		delete(l.live, o.ID)
+		if l.open[o.UserID]--; l.open[o.UserID] == 0 {
+			delete(l.open, o.UserID)
+		}
+	case !o.Status.Done() && !live:
+		l.live[o.ID] = o.UserID
+		l.open[o.UserID]++
+	}
+}
+
+// fill moves cash and shares for one side of a trade and charges its fee.
+func (l *ledger) fill(o *Order, price Price, qty int, fee Price) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	acct := l.get(o.UserID)
+	acct.cash -= fee
+	pos := acct.position(o.Symbol)
+	if o.Side == BUY {
+		acct.cash -= notional(price, qty)
+		pos.qty += qty
+	} else {
+		acct.cash += notional(price, qty)
+		pos.qty -= qty
+	}
+}
+
+func (l *ledger) view(id string) (Account, bool) {
+	acct, exists := l.accounts[id]
+	if !exists {
+		return Account{}, false
+	}
+
+	view := Account{
+		ID:         id,
+		Cash:       acct.cash,
+		Reserved:   acct.reserved,
+		Available:  acct.cash - acct.reserved,
+		OpenOrders: l.open[id],
+		Positions:  make([]Position, 0, len(acct.positions)),
+	}
+	for symbol, pos := range acct.positions {
+		if pos.qty == 0 && pos.reserved == 0 {
+			continue
+		}
+		view.Positions = append(view.Positions, Position{
+			Symbol:    symbol,
+			Qty:       pos.qty,
+			Reserved:  pos.reserved,
+			Available: pos.qty - pos.reserved,
+		})
+	}
+	sort.Slice(view.Positions, func(i, j int) bool {
+		return view.Positions[i].Symbol < view.Positions[j].Symbol
+	})
+	return view, true
+}
+
+// SetAccountPolicy turns buying-power checks on or off. Exempt accounts are
+// tracked but never checked.
+func (me *MatchingEngine) SetAccountPolicy(enforce bool, exempt []string) {
+	me.accounts.mu.Lock()
+	defer me.accounts.mu.Unlock()
+
+	me.accounts.enforce = enforce
+	clear(me.accounts.exempt)
+	for _, id := range exempt {
+		me.accounts.exempt[id] = true
+	}
+}
+
+func (me *MatchingEngine) GetAccount(id string) (Account, bool) {
+	me.accounts.mu.Lock()
+	defer me.accounts.mu.Unlock()
+	return me.accounts.view(id)
+}
+
+// OpenOrders is how many of userID's orders are resting or waiting to
+// trigger.
+func (me *MatchingEngine) OpenOrders(userID string) int {
+	me.accounts.mu.Lock()
+	defer me.accounts.mu.Unlock()
+	return me.accounts.open[userID]
+}
+
+// Deposit credits cash and/or shares of symbol to an account, creating it
+// if needed. The change is journaled first.
+func (me *MatchingEngine) Deposit(id string, cash Price, symbol string, qty int) error {
+	return me.transfer(RecordDeposit, id, cash, symbol, qty)
+}
+
+// Withdraw debits cash and/or shares that are not held by open orders.
+func (me *MatchingEngine) Withdraw(id string, cash Price, symbol string, qty int) error {
+	return me.transfer(RecordWithdraw, id, cash, symbol, qty)
+}
+
+func (me *MatchingEngine) transfer(kind RecordType, id string, cash Price, symbol string, qty int) error {
+	if cash < 0 || qty < 0 || (cash == 0 && qty == 0) || (qty > 0 && symbol == "") {
+		return fmt.Errorf("invalid %s", kind)
+	}
+
+	l := me.accounts
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if kind == RecordWithdraw {
+		acct, exists := l.accounts[id]
+		if !exists {
+			return ErrUnknownAccount
+		}
+		if cash > acct.cash-acct.reserved {
+			return ErrInsufficientFunds
+		}
+		if pos := acct.positions[symbol]; qty > 0 && (pos == nil || qty > pos.qty-pos.reserved) {
+			return ErrInsufficientFunds
+		}
+	}
+
+	rec := &JournalRecord{Time: me.now(), Type: kind, Account: id, Symbol: symbol, Price: cash, Qty: qty}
+	if me.journal != nil && !me.replaying {
+		if err := me.journal.Append(rec); err != nil {
+			return fmt.Errorf("journal: %w", err)
+		}
+	}
+	l.apply(rec)
+
+	me.logger.Info("Account balance changed",
+		"account", id,
+		"type", kind,
+		"cash", cash,
+		"symbol", symbol,
+		"qty", qty,
+	)
+	return nil
+}
+
+// apply must be called with l.mu held.
+func (l *ledger) apply(rec *JournalRecord) {
+	acct := l.get(rec.Account)
+	sign := 1
+	if rec.Type == RecordWithdraw {
+		sign = -1
+	}
+	acct.cash += rec.Price * Price(sign)
+	if rec.Qty != 0 {
+		acct.position(rec.Symbol).qty += rec.Qty * sign
+	}
+}
+
+// reserveFunds places the hold for a new order, or checks that an amend can
+// be covered and holds the larger of the old and new requirement until it
+// is applied. It runs before the command is journaled, so a command refused
+// here never reaches the book or the journal.
+func (me *MatchingEngine) reserveFunds(s *shard, cmd command) bool {
+	switch cmd.kind {
+	case newOrderCommand:
+		order := cmd.order
+		if reason := me.accounts.reserve(order, me.marketCost(order)); reason != "" {
+			me.rejectOrder(order, reason)
+			return false
+		}
+	case amendCommand:
+		order, exists := s.orders[cmd.orderID]
+		if !exists || order.Status.Done() || cmd.price < 0 || cmd.qty < 0 {
+			return true
+		}
+		amended := *order
+		if cmd.price != 0 {
+			amended.Price = cmd.price
+		}
+		if cmd.qty != 0 {
+			amended.Qty = cmd.qty
+		}
+		cash, qty, keep := required(&amended)
+		if keep {
+			return true
+		}
+
+		l := me.accounts
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if reason := l.check(order.ID, order.UserID, order.Symbol, cash, qty); reason != "" {
+			me.logger.Info("Amend refused", "order_id", order.ID, "reason", reason)
+			cmd.amendReply <- AMEND_INSUFFICIENT_FUNDS
+			return false
+		}
+		old := l.holds[order.ID]
+		l.setHold(order.ID, order.UserID, order.Symbol, max(cash, old.cash), max(qty, old.qty))
+	}
+	return true
+}
+
+// CheckFunds reports why order's account could not cover it right now, or ""
+// if it could. Nothing is reserved; the shard checks again when the order
+// arrives.
+func (me *MatchingEngine) CheckFunds(order *Order) string {
+	cost := me.marketCost(order)
+
+	me.accounts.mu.Lock()
+	defer me.accounts.mu.Unlock()
+	_, _, reason := me.accounts.need(order, cost)
+	return reason
+}
+
+func (me *MatchingEngine) marketCost(order *Order) Price {
+	if order.Side != BUY || order.Type != MARKET {
+		return 0
+	}
+	if book := me.GetBook(order.Symbol); book != nil {
+		return book.sweepCost(order)
+	}
+	return 0
+}
+
+// sweepCost estimates what a market buy would spend against the asks.
+func (ob *OrderBook) sweepCost(order *Order) Price {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+
+	var cost Price
+	remaining := order.Qty
+	ob.asks.each(func(o *Order) bool {
+		if order.selfTrades(o) {
+			return true
+		}
+		qty := min(remaining, o.Qty)
+		cost += notional(o.Price, qty)
+		remaining -= qty
+		return remaining > 0
+	})
+	return cost
+}
+
+// AccountState is the persisted part of an account. Holds are not stored;
+// they are rebuilt from the restored orders.
+type AccountState struct {
+	ID        string
+	Cash      Price
+	Positions map[string]int
+}
+
+func (l *ledger) states() []AccountState {
+	ids := make([]string, 0, len(l.accounts))
+	for id := range l.accounts {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	states := make([]AccountState, 0, len(ids))
+	for _, id := range ids {
+		acct := l.accounts[id]
+		st := AccountState{ID: id, Cash: acct.cash, Positions: make(map[string]int)}
+		for symbol, pos := range acct.positions {
+			if pos.qty != 0 {
+				st.Positions[symbol] = pos.qty
+			}
+		}
+		states = append(states, st)
+	}
+	return states
+}
+
+func (l *ledger) restore(states []AccountState) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, st := range states {
+		acct := l.get(st.ID)
+		acct.cash = st.Cash
+		for symbol, qty := range st.Positions {
+			acct.position(symbol).qty = qty
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package engine
+
+import "github.com/google/uuid"
+
+type AmendResult int
+
+const (
+	AMEND_OK AmendResult = iota
+	AMEND_FILLED
+	AMEND_ALREADY_CANCELLED
+	AMEND_UNKNOWN
+	AMEND_INVALID
+	AMEND_REJECTED
+	AMEND_INSUFFICIENT_FUNDS
+	AMEND_HALTED
+	AMEND_ALREADY_EXPIRED
+	AMEND_ALREADY_REJECTED
+)
+
+func (r AmendResult) String() string {
+	switch r {
+	case AMEND_OK:
+		return "amended"
+	case AMEND_FILLED:
+		return "already_filled"
+	case AMEND_ALREADY_CANCELLED:
+		return "already_cancelled"
+	case AMEND_INVALID:
+		return "invalid_amend"
+	case AMEND_REJECTED:
+		return "rejected"
+	case AMEND_INSUFFICIENT_FUNDS:
+		return "insufficient_funds"
+	case AMEND_HALTED:
+		return "trading_halted"
+	case AMEND_ALREADY_EXPIRED:
+		return "already_expired"
+	case AMEND_ALREADY_REJECTED:
+		return "already_rejected"
+	default:
+		return "unknown_order"
+	}
+}
+
+// AmendOrder changes the price and/or open quantity of a resting order.
+// A zero price or qty leaves that field unchanged.
+func (me *MatchingEngine) AmendOrder(id uuid.UUID, price Price, qty int) AmendResult {
+	s := me.shardOfOrder(id)
+	if s == nil {
+		return AMEND_UNKNOWN
+	}
+
+	reply := make(chan AmendResult, 1)
+	cmd := command{
+		kind:       amendCommand,
+		orderID:    id,
+		price:      price,
+		qty:        qty,
+		amendReply: reply,
+	}
+	if !me.send(s, cmd) {
+		return AMEND_REJECTED
+	}
+	if result, ok := await(me, reply); ok {
+		return result
+	}
+	return AMEND_REJECTED
+}
+
+func (me *MatchingEngine) amendOrder(s *shard, id uuid.UUID, price Price, qty int, at int64) AmendResult {
+	if price < 0 || qty < 0 || (price == 0 && qty == 0) {
+		return AMEND_INVALID
+	}
+
+	order, exists := s.orders[id]
+	if !exists {
+		return AMEND_UNKNOWN
+	}
+
+	book := me.GetBook(order.Symbol)
+	if book == nil || book.GetOrder(id) == nil {
+		switch order.Status {
+		case FILLED:
+			return AMEND_FILLED
+		case EXPIRED:
+			return AMEND_ALREADY_EXPIRED
+		case REJECTED:
+			return AMEND_ALREADY_REJECTED
+		}
+		return AMEND_ALREADY_CANCELLED
+	}
+	if state := book.TradingStatus().State; state != CONTINUOUS && state != AUCTION {
+		return AMEND_HALTED
+	}
+	book.at = at
+
+	if price == 0 {
+		price = order.Price
+	}
+	if qty == 0 {
+		qty = order.Qty
+	}
+	inst := book.GetInstrument()
+	if inst.Status != ACTIVE || inst.ValidateQty(qty) != nil {
+		return AMEND_INVALID
+	}
+	if (order.Type == LIMIT || order.Type == STOP_LIMIT) && inst.ValidatePrice(price) != nil {
+		return AMEND_INVALID
+	}
+
+	// Reducing quantity at the same price keeps time priority.
+	if price == order.Price && qty <= order.Qty {
+		book.mu.Lock()
+		order.Qty = qty
+		if order.isIceberg() {
+			order.VisibleQty = min(order.VisibleQty, qty)
+		}
+		if !order.isStop() {
+			book.side(order.Side).touch(order.Price)
+		}
+		book.mu.Unlock()
+
+		me.logger.Info("Order amended in place",
+			"order_id", id,
+			"symbol", order.Symbol,
+			"qty", qty,
+		)
+		me.emitOrder(book, EVENT_AMENDED, order, "")
+		return AMEND_OK
+	}
+
+	// Anything else loses priority and is re-matched as if newly arrived.
+	book.RemoveOrder(id)
+	order.Price = price
+	order.Qty = qty
+	order.Timestamp = me.now()
+
+	me.logger.Info("Order amended with priority reset",
+		"order_id", id,
+		"symbol", order.Symbol,
+		"price", price,
+		"qty", qty,
+	)
+
+	me.emitOrder(book, EVENT_AMENDED, order, "")
+	me.runOrder(book, order)
+	return AMEND_OK
+}
@@ -0,0 +1,231 @@
+package engine
+
+import "sort"
+
+// Uncross is where a call auction matches: the single price that executes
+// the most volume, that volume, and what is left over at the price.
+// Imbalance is buy minus sell quantity at Price, so a positive value means
+// buyers go unfilled. A zero Volume means the book does not cross.
+type Uncross struct {
+	Price     Price `json:"price"`
+	Volume    int   `json:"volume"`
+	Imbalance int   `json:"imbalance"`
+}
+
+// IndicativeUncross returns where symbol's book would uncross if its call
+// ended now.
+func (me *MatchingEngine) IndicativeUncross(symbol string) Uncross {
+	if book := me.GetBook(symbol); book != nil {
+		return book.IndicativeUncross()
+	}
+	return Uncross{}
+}
+
+func (ob *OrderBook) IndicativeUncross() Uncross {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+	return ob.findUncross()
+}
+
+// findUncross picks, from the prices orders rest at, the one that executes
+// the most volume; then the one that leaves the smallest imbalance; then the
+// one closest to the reference price; then the lowest. Hidden iceberg
+// quantity counts. It must be called with ob.mu held.
+func (ob *OrderBook) findUncross() Uncross {
+	bids := ob.bids.totals()
+	asks := ob.asks.totals()
+	if len(bids) == 0 || len(asks) == 0 || bids[0].Price < asks[0].Price {
+		return Uncross{}
+	}
+
+	// Nothing executes above the best bid or below the best ask.
+	prices := make([]Price, 0, len(bids)+len(asks))
+	for _, level := range bids {
+		if level.Price >= asks[0].Price {
+			prices = append(prices, level.Price)
+		}
+	}
+	for _, level := range asks {
+		if level.Price <= bids[0].Price {
+			prices = append(prices, level.Price)
+		}
+	}
+	sort.Slice(prices, func(i, j int) bool { return prices[i] < prices[j] })
+
+	sells := make([]int, len(prices))
+	for i, j, cum := 0, 0, 0; i < len(prices); i++ {
+		for ; j < len(asks) && asks[j].Price <= prices[i]; j++ {
+			cum += asks[j].Qty
+		}
+		sells[i] = cum
+	}
+	buys := make([]int, len(prices))
+	for i, j, cum := len(prices)-1, 0, 0; i >= 0; i-- {
+		for ; j < len(bids) && bids[j].Price >= prices[i]; j++ {
+			cum += bids[j].Qty
+		}
+		buys[i] = cum
+	}
+
+	ref := ob.referencePrice()
+	var best Uncross
+	for i, price := range prices {
+		u := Uncross{Price: price, Volume: min(buys[i], sells[i]), Imbalance: buys[i] - sells[i]}
+		if i == 0 || u.beats(best, ref) {
+			best = u
+		}
+	}
+	return best
+}
+
+// beats reports whether u is a strictly better uncross than other. Prices
+// are tried lowest first, so a full tie goes to the lower price.
+func (u Uncross) beats(other Uncross, ref Price) bool {
+	if u.Volume != other.Volume {
+		return u.Volume > other.Volume
+	}
+	if a, b := abs(u.Imbalance), abs(other.Imbalance); a != b {
+		return a < b
+	}
+	return abs(u.Price-ref) < abs(other.Price-ref)
+}
+
+func abs[T ~int | ~int64](x T) T {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// referencePrice is the last trade, or the instrument's reference price
+// before the first. It must be called with ob.mu held.
+func (ob *OrderBook) referencePrice() Price {
+	if ob.lastPrice != nil {
+		return *ob.lastPrice
+	}
+	return ob.Instrument.ReferencePrice
+}
+
+// totals returns each level's full quantity, hidden included, best first.
+func (bs *bookSide) totals() []PriceLevel {
+	totals := make([]PriceLevel, 0, len(bs.levels))
+	for i := len(bs.levels) - 1; i >= 0; i-- {
+		qty := 0
+		for e := bs.levels[i].orders.Front(); e != nil; e = e.Next() {
+			qty += e.Value.(*Order).Qty
+		}
+		totals = append(totals, PriceLevel{Price: bs.levels[i].price, Qty: qty})
+	}
+	return totals
+}
+
+// callOrder rests an order during an auction's call period, when nothing
+// matches until the book uncrosses.
+func (me *MatchingEngine) callOrder(book *OrderBook, order *Order) {
+	if !order.canRest() {
+		me.logger.Info("Order cancelled during auction",
+			"order_id", order.ID,
+			"symbol", order.Symbol,
+			"type", order.Type,
+			"time_in_force", order.TimeInForce,
+		)
+		order.Status = CANCELLED
+		me.emitOrder(book, EVENT_CANCELLED, order, "not accepted during auction")
+		return
+	}
+
+	book.mu.Lock()
+	defer book.mu.Unlock()
+	if order.isIceberg() {
+		order.reloadPeak(me.now())
+	}
+	book.side(order.Side).Push(order)
+	me.logger.Debug("Order added to auction",
+		"order_id", order.ID,
+		"side", order.Side,
+		"price", order.Price,
+		"qty", order.Qty,
+	)
+}
+
+// uncross ends a call by executing everything that crosses at the uncross
+// price, best-priced orders first and in time order within a price. Of each
+// matched pair the later order is the aggressor, for fees and self-trade
+// prevention. It must be called with book.mu held.
+func (me *MatchingEngine) uncross(book *OrderBook) {
+	u := book.findUncross()
+	if u.Volume == 0 {
+		me.logger.Info("Auction ended without a cross", "symbol", book.Symbol)
+		return
+	}
+
+	me.logger.Info("Auction uncrossing",
+		"symbol", book.Symbol,
+		"price", u.Price,
+		"volume", u.Volume,
+		"imbalance", u.Imbalance,
+	)
+	me.emit(book, Event{Type: EVENT_UNCROSS, Symbol: book.Symbol, Auction: &u})
+
+	for book.bids.Len() > 0 && book.asks.Len() > 0 {
+		bid, ask := book.bids.Peek(), book.asks.Peek()
+		if bid.Price < u.Price || ask.Price > u.Price {
+			break
+		}
+
+		aggressor, resting := bid, ask
+		if ask.Timestamp > bid.Timestamp {
+			aggressor, resting = ask, bid
+		}
+		if aggressor.selfTrades(resting) {
+			aggressorSide := book.side(aggressor.Side)
+			if me.preventSelfTrade(book, book.side(resting.Side), aggressor, resting) {
+				aggressorSide.Pop()
+			} else {
+				aggressorSide.touch(aggressor.Price)
+				me.accounts.settle(aggressor)
+				if aggressor.isIceberg() {
+					aggressor.VisibleQty = min(aggressor.VisibleQty, aggressor.Qty)
+				}
+			}
+			continue
+		}
+
+		qty := min(bid.Qty, ask.Qty)
+		trade := me.newTrade(book.Symbol, bid, ask, u.Price, qty, aggressor.Side)
+		me.publishTrade(book, trade)
+		book.recordTrade(u.Price)
+
+		for _, order := range []*Order{bid, ask} {
+			order.Qty -= qty
+			if order.isIceberg() {
+				order.VisibleQty = min(order.VisibleQty, order.Qty)
+			}
+			book.side(order.Side).touch(order.Price)
+			order.fill(qty, u.Price)
+		}
+		me.emitFill(book, aggressor, trade)
+		me.emitFill(book, resting, trade)
+
+		if bid.Qty == 0 {
+			book.bids.Pop()
+		}
+		if ask.Qty == 0 {
+			book.asks.Pop()
+		}
+	}
+
+	// The uncross price starts the circuit breaker's window.
+	book.window = append(book.window[:0], PricePoint{Time: book.at, Price: u.Price})
+}
+
+// publishIndicative emits the book's indicative uncross if it changed. It
+// must be called with book.mu held.
+func (me *MatchingEngine) publishIndicative(book *OrderBook) {
+	u := book.findUncross()
+	if u == book.indicative {
+		return
+	}
+	book.indicative = u
+	me.emit(book, Event{Type: EVENT_INDICATIVE, Symbol: book.Symbol, Auction: &u})
+}
@@ -1,62 +1,153 @@
 package engine
 
 import (
-	"container/heap"
 	"sync"
+	"sync/atomic"
+
+	"github.com/google/uuid"
 )
 
 type OrderBook struct {
-	Symbol   string
-	BuyHeap  *BuyHeap
-	SellHeap *SellHeap
-	mu       sync.RWMutex
+	Symbol     string
+	Instrument Instrument
+	bids       *bookSide
+	asks       *bookSide
+	stops      []*Order
+	triggered  []*Order
+	lastPrice  *Price
+	trading    TradingStatus
+	window     []PricePoint
+	indicative Uncross
+	policy     MatchingPolicy
+	at         int64 // time of the command being applied
+	seq        atomic.Uint64
+	mu         sync.RWMutex
 }
 
 func NewOrderBook(symbol string) *OrderBook {
+	return NewOrderBookFor(DefaultInstrument(symbol))
+}
+
+func NewOrderBookFor(inst Instrument) *OrderBook {
 	return &OrderBook{
-		Symbol:   symbol,
-		BuyHeap:  NewBuyHeap(),
-		SellHeap: NewSellHeap(),
+		Symbol:     inst.Symbol,
+		Instrument: inst,
+		policy:     inst.Matching.Policy(),
+		bids:       newBidSide(),
+		asks:       newAskSide(),
 	}
 }
 
+func (ob *OrderBook) GetInstrument() Instrument {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+	return ob.Instrument
+}
+
 func (ob *OrderBook) AddOrder(order *Order) {
 	ob.mu.Lock()
 	defer ob.mu.Unlock()
 
 	if order.Side == BUY {
-		heap.Push(ob.BuyHeap, order)
+		ob.bids.Push(order)
 	} else {
-		heap.Push(ob.SellHeap, order)
+		ob.asks.Push(order)
 	}
 }
 
-func (ob *OrderBook) GetBestBid() *float64 {
+func (ob *OrderBook) GetOrder(id uuid.UUID) *Order {
 	ob.mu.RLock()
 	defer ob.mu.RUnlock()
 
-	if ob.BuyHeap.Len() == 0 {
-		return nil
+	if order := ob.bids.Get(id); order != nil {
+		return order
+	}
+	if order := ob.asks.Get(id); order != nil {
+		return order
+	}
+	return ob.getStop(id)
+}
+
+func (ob *OrderBook) RemoveOrder(id uuid.UUID) *Order {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	if order := ob.bids.Remove(id); order != nil {
+		return order
+	}
+	if order := ob.asks.Remove(id); order != nil {
+		return order
 	}
-	price := ob.BuyHeap.Peek().Price
-	return &price
+	return ob.removeStop(id)
 }
 
-func (ob *OrderBook) GetBestAsk() *float64 {
+// fillsInFull reports whether the book could fill all of order now without
+// self-trade prevention stopping it part way, and the prices of the levels
+// it would trade at, best first. A self-match that cancels the
+// order ends the count, one that cancels the resting order is skipped, and a
+// decrement uses up quantity like a fill. Policies other than FIFO share a
+// level among all its orders, so a self-match anywhere on the level that
+// completes the order also ends the count.
+func (ob *OrderBook) fillsInFull(order *Order) (bool, []Price) {
 	ob.mu.RLock()
 	defer ob.mu.RUnlock()
 
-	if ob.SellHeap.Len() == 0 {
-		return nil
+	resting := ob.asks
+	if order.Side == SELL {
+		resting = ob.bids
 	}
-	price := ob.SellHeap.Peek().Price
-	return &price
+	_, fifo := ob.policy.(FIFO)
+
+	total, last := 0, Price(0)
+	blocked := false
+	var levels []Price
+	resting.each(func(o *Order) bool {
+		if !order.crosses(o.Price) || total >= order.Qty && (fifo || o.Price != last) {
+			return false
+		}
+		last = o.Price
+		if order.selfTrades(o) {
+			switch order.SelfTrade {
+			case STP_CANCEL_NEWEST, STP_CANCEL_BOTH:
+				blocked = true
+				return false
+			case STP_CANCEL_OLDEST:
+				return true
+			}
+		}
+		if total < order.Qty {
+			total += o.Qty
+			if len(levels) == 0 || levels[len(levels)-1] != o.Price {
+				levels = append(levels, o.Price)
+			}
+		}
+		return true
+	})
+	return !blocked && total >= order.Qty, levels
 }
 
-func (ob *OrderBook) GetSpread() *float64 {
-	bid := ob.GetBestBid()
-	ask := ob.GetBestAsk()
+func (ob *OrderBook) GetBestBid() *Price {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
 
+	return ob.bids.bestPrice()
+}
+
+func (ob *OrderBook) GetBestAsk() *Price {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+
+	return ob.asks.bestPrice()
+}
+
+func (ob *OrderBook) GetSpread() *Price {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+
+	return spreadOf(ob.bids.bestPrice(), ob.asks.bestPrice())
+}
+
+func spreadOf(bid, ask *Price) *Price {
 	if bid == nil || ask == nil {
 		return nil
 	}
@@ -66,53 +157,44 @@ func (ob *OrderBook) GetSpread() *float64 {
 }
 
 type BookSnapshot struct {
-	Symbol   string       `json:"symbol"`
-	BuyBook  []PriceLevel `json:"buy_book"`
-	SellBook []PriceLevel `json:"sell_book"`
-	BestBid  *float64     `json:"best_bid"`
-	BestAsk  *float64     `json:"best_ask"`
-	Spread   *float64     `json:"spread"`
+	Symbol   string        `json:"symbol"`
+	BuyBook  []PriceLevel  `json:"buy_book"`
+	SellBook []PriceLevel  `json:"sell_book"`
+	BestBid  *Price        `json:"best_bid"`
+	BestAsk  *Price        `json:"best_ask"`
+	Spread   *Price        `json:"spread"`
+	Trading  TradingStatus `json:"trading"`
+	Auction  *Uncross      `json:"auction,omitempty"`
+	Seq      uint64        `json:"seq"`
 }
 
 type PriceLevel struct {
-	Price float64 `json:"price"`
-	Qty   int     `json:"qty"`
+	Price Price `json:"price"`
+	Qty   int   `json:"qty"`
 }
 
+// GetSnapshot returns the top depth price levels on each side, best first,
+// and during an auction the indicative uncross.
 func (ob *OrderBook) GetSnapshot(depth int) BookSnapshot {
 	ob.mu.RLock()
 	defer ob.mu.RUnlock()
 
+	bid := ob.bids.bestPrice()
+	ask := ob.asks.bestPrice()
+
 	snapshot := BookSnapshot{
 		Symbol:   ob.Symbol,
-		BuyBook:  make([]PriceLevel, 0),
-		SellBook: make([]PriceLevel, 0),
-		BestBid:  ob.GetBestBid(),
-		BestAsk:  ob.GetBestAsk(),
-		Spread:   ob.GetSpread(),
-	}
-
-	priceMap := make(map[float64]int)
-	for _, order := range *ob.BuyHeap {
-		priceMap[order.Price] += order.Qty
-	}
-	for price, qty := range priceMap {
-		snapshot.BuyBook = append(snapshot.BuyBook, PriceLevel{Price: price, Qty: qty})
-		if len(snapshot.BuyBook) >= depth {
-			break
-		}
+		BuyBook:  ob.bids.depth(depth),
+		SellBook: ob.asks.depth(depth),
+		BestBid:  bid,
+		BestAsk:  ask,
+		Spread:   spreadOf(bid, ask),
+		Trading:  ob.trading,
+		Seq:      ob.seq.Load(),
 	}
-
-	priceMap = make(map[float64]int)
-	for _, order := range *ob.SellHeap {
-		priceMap[order.Price] += order.Qty
+	if ob.trading.State == AUCTION {
+		u := ob.findUncross()
+		snapshot.Auction = &u
 	}
-	for price, qty := range priceMap {
-		snapshot.SellBook = append(snapshot.SellBook, PriceLevel{Price: price, Qty: qty})
-		if len(snapshot.SellBook) >= depth {
-			break
-		}
-	}
-
 	return snapshot
 }
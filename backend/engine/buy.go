@@ -1,44 +1,51 @@
 package engine
 
-import "container/heap"
+import (
+	"container/heap"
 
-type BuyHeap []*Order
+	"github.com/google/uuid"
+)
 
-func (h BuyHeap) Len() int { return len(h) }
+type BuyHeap struct {
+	h *orderHeap
+}
 
-func (h BuyHeap) Less(i, j int) bool {
-	if h[i].Price != h[j].Price {
-		return h[i].Price > h[j].Price
+func NewBuyHeap() *BuyHeap {
+	return &BuyHeap{
+		h: newOrderHeap(func(a, b *Order) bool {
+			if a.Price != b.Price {
+				return a.Price > b.Price
+			}
+			return a.Timestamp < b.Timestamp
+		}),
 	}
-	return h[i].Timestamp < h[j].Timestamp
 }
 
-func (h BuyHeap) Swap(i, j int) {
-	h[i], h[j] = h[j], h[i]
+func (b *BuyHeap) Len() int { return b.h.Len() }
+
+func (b *BuyHeap) Push(order *Order) {
+	heap.Push(b.h, order)
+}
+
+func (b *BuyHeap) Pop() *Order {
+	if b.h.Len() == 0 {
+		return nil
+	}
+	return heap.Pop(b.h).(*Order)
 }
 
-func (h *BuyHeap) Push(x interface{}) {
-	*h = append(*h, x.(*Order))
+func (b *BuyHeap) Peek() *Order {
+	return b.h.peek()
 }
 
-func (h *BuyHeap) Pop() interface{} {
-	old := *h
-	n := len(old)
-	order := old[n-1]
-	old[n-1] = nil
-	*h = old[0 : n-1]
-	return order
+func (b *BuyHeap) Get(id uuid.UUID) *Order {
+	return b.h.get(id)
 }
 
-func (h *BuyHeap) Peek() *Order {
-	if h.Len() == 0 {
-		return nil
-	}
-	return (*h)[0]
+func (b *BuyHeap) Remove(id uuid.UUID) *Order {
+	return b.h.remove(id)
 }
 
-func NewBuyHeap() *BuyHeap {
-	h := &BuyHeap{}
-	heap.Init(h)
-	return h
+func (b *BuyHeap) Orders() []*Order {
+	return b.h.orders
 }
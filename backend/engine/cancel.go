@@ -0,0 +1,84 @@
+package engine
+
+import "github.com/google/uuid"
+
+type CancelResult int
+
+const (
+	CANCEL_OK CancelResult = iota
+	CANCEL_FILLED
+	CANCEL_ALREADY_CANCELLED
+	CANCEL_UNKNOWN
+	CANCEL_REJECTED
+	CANCEL_ALREADY_EXPIRED
+	CANCEL_ALREADY_REJECTED
+)
+
+func (r CancelResult) String() string {
+	switch r {
+	case CANCEL_OK:
+		return "cancelled"
+	case CANCEL_FILLED:
+		return "already_filled"
+	case CANCEL_ALREADY_CANCELLED:
+		return "already_cancelled"
+	case CANCEL_REJECTED:
+		return "rejected"
+	case CANCEL_ALREADY_EXPIRED:
+		return "already_expired"
+	case CANCEL_ALREADY_REJECTED:
+		return "already_rejected"
+	default:
+		return "unknown_order"
+	}
+}
+
+func (me *MatchingEngine) CancelOrder(id uuid.UUID) CancelResult {
+	s := me.shardOfOrder(id)
+	if s == nil {
+		return CANCEL_UNKNOWN
+	}
+
+	reply := make(chan CancelResult, 1)
+	cmd := command{
+		kind:        cancelCommand,
+		orderID:     id,
+		cancelReply: reply,
+	}
+	if !me.send(s, cmd) {
+		return CANCEL_REJECTED
+	}
+	if result, ok := await(me, reply); ok {
+		return result
+	}
+	return CANCEL_REJECTED
+}
+
+func (me *MatchingEngine) cancelOrder(s *shard, id uuid.UUID) CancelResult {
+	order, exists := s.orders[id]
+	if !exists {
+		return CANCEL_UNKNOWN
+	}
+
+	book := me.GetBook(order.Symbol)
+	if book != nil && book.RemoveOrder(id) != nil {
+		me.logger.Info("Order cancelled",
+			"order_id", id,
+			"symbol", order.Symbol,
+			"remaining_qty", order.Qty,
+		)
+		order.Status = CANCELLED
+		me.emitOrder(book, EVENT_CANCELLED, order, "")
+		return CANCEL_OK
+	}
+
+	switch order.Status {
+	case FILLED:
+		return CANCEL_FILLED
+	case EXPIRED:
+		return CANCEL_ALREADY_EXPIRED
+	case REJECTED:
+		return CANCEL_ALREADY_REJECTED
+	}
+	return CANCEL_ALREADY_CANCELLED
+}
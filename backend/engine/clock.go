@@ -0,0 +1,137 @@
+package engine
+
+import (
+	"encoding/binary"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Clock is the engine's only source of time. Tests and replays swap in a
+// SimClock so that timestamps, and therefore output, are reproducible.
+type Clock interface {
+	Now() time.Time
+}
+
+// IDGenerator is the engine's only source of order and trade IDs.
+type IDGenerator interface {
+	NewID() uuid.UUID
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+type randomIDs struct{}
+
+func (randomIDs) NewID() uuid.UUID { return uuid.New() }
+
+var (
+	SystemClock Clock       = systemClock{}
+	RandomIDs   IDGenerator = randomIDs{}
+)
+
+// SimClock only moves when told to.
+type SimClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func NewSimClock(start time.Time) *SimClock {
+	return &SimClock{now: start}
+}
+
+func (c *SimClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *SimClock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = t
+}
+
+func (c *SimClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// SequentialIDs derives IDs from a counter, so the nth ID is always the
+// same for a given namespace. Its position is saved in snapshots.
+type SequentialIDs struct {
+	namespace uuid.UUID
+	next      atomic.Uint64
+}
+
+func NewSequentialIDs(namespace uuid.UUID) *SequentialIDs {
+	return &SequentialIDs{namespace: namespace}
+}
+
+func (g *SequentialIDs) NewID() uuid.UUID {
+	n := g.next.Add(1)
+	return uuid.NewSHA1(g.namespace, binary.BigEndian.AppendUint64(nil, n))
+}
+
+func (g *SequentialIDs) Position() uint64 {
+	return g.next.Load()
+}
+
+func (g *SequentialIDs) SetPosition(n uint64) {
+	g.next.Store(n)
+}
+
+type positionedIDs interface {
+	Position() uint64
+	SetPosition(n uint64)
+}
+
+// SetClock and SetIDGenerator must be called before Start.
+func (me *MatchingEngine) SetClock(c Clock) {
+	me.clock = c
+}
+
+func (me *MatchingEngine) SetIDGenerator(g IDGenerator) {
+	me.ids = g
+}
+
+func (me *MatchingEngine) now() int64 {
+	return me.clock.Now().UnixNano()
+}
+
+func (me *MatchingEngine) NewOrder(symbol string, side Side, price Price, qty int, userID string) *Order {
+	return newOrder(me.clock, me.ids, symbol, side, price, qty, userID)
+}
+
+func (me *MatchingEngine) NewMarketOrder(symbol string, side Side, qty int, tif TimeInForce, userID string) *Order {
+	return newMarketOrder(me.clock, me.ids, symbol, side, qty, tif, userID)
+}
+
+func (me *MatchingEngine) NewStopOrder(symbol string, side Side, stopPrice, limitPrice Price, qty int, userID string) *Order {
+	return newStopOrder(me.clock, me.ids, symbol, side, stopPrice, limitPrice, qty, userID)
+}
+
+func (me *MatchingEngine) newTrade(symbol string, buyOrder, sellOrder *Order, price Price, qty int, side Side) *Trade {
+	trade := &Trade{
+		ID:        me.ids.NewID(),
+		Symbol:    symbol,
+		BuyOrder:  buyOrder.ID,
+		SellOrder: sellOrder.ID,
+		Buyer:     buyOrder.UserID,
+		Seller:    sellOrder.UserID,
+		Price:     price,
+		Qty:       qty,
+		Timestamp: me.now(),
+		Side:      side,
+	}
+	if side == BUY {
+		trade.chargeFees(sellOrder, buyOrder)
+	} else {
+		trade.chargeFees(buyOrder, sellOrder)
+	}
+	return trade
+}
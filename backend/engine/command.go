@@ -0,0 +1,41 @@
+package engine
+
+import (
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+type commandType int
+
+const (
+	newOrderCommand commandType = iota
+	cancelCommand
+	amendCommand
+	pauseCommand
+	queryCommand
+	stateCommand
+)
+
+// command is one unit of work for a shard. at is when it was journaled;
+// matching uses it instead of the clock so a replay behaves the same.
+type command struct {
+	kind        commandType
+	at          int64
+	order       *Order
+	orderID     uuid.UUID
+	price       Price
+	qty         int
+	symbol      string
+	state       TradingState
+	until       int64
+	scheduled   bool
+	session     string
+	reason      string
+	cancelReply chan CancelResult
+	amendReply  chan AmendResult
+	stateReply  chan error
+	queryReply  chan *Order
+	paused      *sync.WaitGroup
+	resume      chan struct{}
+}
@@ -1,15 +1,38 @@
 package engine_test
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"sort"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/AkshatMadhani/nanopulse/engine"
 	"github.com/AkshatMadhani/nanopulse/logger"
+	"github.com/google/uuid"
 )
 
+func px(f float64) engine.Price {
+	return engine.PriceFromFloat(f)
+}
+
+func newTestEngine() *engine.MatchingEngine {
+	log := logger.New(logger.ERROR)
+	me := engine.NewMatchingEngine(100, log)
+	me.SetInstrument(engine.DefaultInstrument("TEST"))
+	me.Start()
+	return me
+}
+
 func TestOrderCreation(t *testing.T) {
-	order := engine.NewOrder("RELIANCE", engine.BUY, 2500.0, 10, "test-user")
+	order := engine.NewOrder("RELIANCE", engine.BUY, px(2500.0), 10, "test-user")
 
 	if order.Symbol != "RELIANCE" {
 		t.Errorf("Expected symbol RELIANCE, got %s", order.Symbol)
@@ -19,8 +42,8 @@ func TestOrderCreation(t *testing.T) {
 		t.Errorf("Expected BUY side, got %v", order.Side)
 	}
 
-	if order.Price != 2500.0 {
-		t.Errorf("Expected price 2500.0, got %f", order.Price)
+	if order.Price != px(2500.0) {
+		t.Errorf("Expected price 2500.0, got %v", order.Price)
 	}
 
 	if order.Qty != 10 {
@@ -31,34 +54,34 @@ func TestOrderCreation(t *testing.T) {
 func TestBuyHeapOrdering(t *testing.T) {
 	heap := engine.NewBuyHeap()
 
-	o1 := engine.NewOrder("TEST", engine.BUY, 2500.0, 10, "user1")
-	o2 := engine.NewOrder("TEST", engine.BUY, 2505.0, 5, "user2")
-	o3 := engine.NewOrder("TEST", engine.BUY, 2495.0, 15, "user3")
+	o1 := engine.NewOrder("TEST", engine.BUY, px(2500.0), 10, "user1")
+	o2 := engine.NewOrder("TEST", engine.BUY, px(2505.0), 5, "user2")
+	o3 := engine.NewOrder("TEST", engine.BUY, px(2495.0), 15, "user3")
 
 	heap.Push(o1)
 	heap.Push(o2)
 	heap.Push(o3)
 
 	top := heap.Peek()
-	if top.Price != 2505.0 {
-		t.Errorf("Expected top price 2505.0, got %f", top.Price)
+	if top.Price != px(2505.0) {
+		t.Errorf("Expected top price 2505.0, got %v", top.Price)
 	}
 }
 
 func TestSellHeapOrdering(t *testing.T) {
 	heap := engine.NewSellHeap()
 
-	o1 := engine.NewOrder("TEST", engine.SELL, 2500.0, 10, "user1")
-	o2 := engine.NewOrder("TEST", engine.SELL, 2505.0, 5, "user2")
-	o3 := engine.NewOrder("TEST", engine.SELL, 2495.0, 15, "user3")
+	o1 := engine.NewOrder("TEST", engine.SELL, px(2500.0), 10, "user1")
+	o2 := engine.NewOrder("TEST", engine.SELL, px(2505.0), 5, "user2")
+	o3 := engine.NewOrder("TEST", engine.SELL, px(2495.0), 15, "user3")
 
 	heap.Push(o1)
 	heap.Push(o2)
 	heap.Push(o3)
 
 	top := heap.Peek()
-	if top.Price != 2495.0 {
-		t.Errorf("Expected top price 2495.0, got %f", top.Price)
+	if top.Price != px(2495.0) {
+		t.Errorf("Expected top price 2495.0, got %v", top.Price)
 	}
 }
 
@@ -81,67 +104,119 @@ func TestOrderBookCreation(t *testing.T) {
 func TestOrderBookBestPrices(t *testing.T) {
 	book := engine.NewOrderBook("TEST")
 
-	buyOrder := engine.NewOrder("TEST", engine.BUY, 2500.0, 10, "user1")
+	buyOrder := engine.NewOrder("TEST", engine.BUY, px(2500.0), 10, "user1")
 	book.AddOrder(buyOrder)
 
 	bestBid := book.GetBestBid()
-	if bestBid == nil || *bestBid != 2500.0 {
+	if bestBid == nil || *bestBid != px(2500.0) {
 		t.Errorf("Expected best bid 2500.0, got %v", bestBid)
 	}
 
-	sellOrder := engine.NewOrder("TEST", engine.SELL, 2505.0, 5, "user2")
+	sellOrder := engine.NewOrder("TEST", engine.SELL, px(2505.0), 5, "user2")
 	book.AddOrder(sellOrder)
 
 	bestAsk := book.GetBestAsk()
-	if bestAsk == nil || *bestAsk != 2505.0 {
+	if bestAsk == nil || *bestAsk != px(2505.0) {
 		t.Errorf("Expected best ask 2505.0, got %v", bestAsk)
 	}
 
 	spread := book.GetSpread()
-	if spread == nil || *spread != 5.0 {
+	if spread == nil || *spread != px(5.0) {
 		t.Errorf("Expected spread 5.0, got %v", spread)
 	}
 }
 
+func TestOrderBookSnapshotLevels(t *testing.T) {
+	book := engine.NewOrderBook("TEST")
+
+	for _, p := range []float64{2499, 2501, 2500, 2498, 2500} {
+		book.AddOrder(engine.NewOrder("TEST", engine.BUY, px(p), 10, "user1"))
+	}
+	for _, p := range []float64{2503, 2502, 2504} {
+		book.AddOrder(engine.NewOrder("TEST", engine.SELL, px(p), 5, "user2"))
+	}
+
+	snapshot := book.GetSnapshot(3)
+
+	wantBids := []engine.PriceLevel{{Price: px(2501), Qty: 10}, {Price: px(2500), Qty: 20}, {Price: px(2499), Qty: 10}}
+	if len(snapshot.BuyBook) != len(wantBids) {
+		t.Fatalf("Expected %d bid levels, got %d", len(wantBids), len(snapshot.BuyBook))
+	}
+	for i, level := range wantBids {
+		if snapshot.BuyBook[i] != level {
+			t.Errorf("Bid level %d: expected %+v, got %+v", i, level, snapshot.BuyBook[i])
+		}
+	}
+
+	wantAsks := []engine.PriceLevel{{Price: px(2502), Qty: 5}, {Price: px(2503), Qty: 5}, {Price: px(2504), Qty: 5}}
+	for i, level := range wantAsks {
+		if snapshot.SellBook[i] != level {
+			t.Errorf("Ask level %d: expected %+v, got %+v", i, level, snapshot.SellBook[i])
+		}
+	}
+
+	if snapshot.Spread == nil || *snapshot.Spread != px(1) {
+		t.Errorf("Expected spread 1, got %v", snapshot.Spread)
+	}
+}
+
+func TestOrderBookRemoveEmptiesLevel(t *testing.T) {
+	book := engine.NewOrderBook("TEST")
+
+	first := engine.NewOrder("TEST", engine.BUY, px(2501), 10, "user1")
+	second := engine.NewOrder("TEST", engine.BUY, px(2500), 10, "user1")
+	book.AddOrder(first)
+	book.AddOrder(second)
+
+	if book.RemoveOrder(first.ID) != first {
+		t.Fatal("Expected first order to be removed")
+	}
+	if bid := book.GetBestBid(); bid == nil || *bid != px(2500) {
+		t.Errorf("Expected best bid 2500 after removing top level, got %v", bid)
+	}
+	if book.RemoveOrder(first.ID) != nil {
+		t.Error("Expected second removal of the same order to return nil")
+	}
+}
+
 func TestMatchingEngineBasicMatch(t *testing.T) {
-	log := logger.New(logger.ERROR)
-	me := engine.NewMatchingEngine(100, log)
-	me.Start()
+	me := newTestEngine()
 
-	buyOrder := engine.NewOrder("TEST", engine.BUY, 2500.0, 10, "buyer")
+	buyOrder := engine.NewOrder("TEST", engine.BUY, px(2500.0), 10, "buyer")
 	me.GetOrderChan() <- buyOrder
 
 	time.Sleep(time.Millisecond * 10)
 
-	sellOrder := engine.NewOrder("TEST", engine.SELL, 2500.0, 10, "seller")
+	sellOrder := engine.NewOrder("TEST", engine.SELL, px(2500.0), 10, "seller")
 	me.GetOrderChan() <- sellOrder
 
 	time.Sleep(time.Millisecond * 10)
 
 	select {
 	case trade := <-me.GetTradeChan():
-		if trade.Price != 2500.0 {
-			t.Errorf("Expected trade price 2500.0, got %f", trade.Price)
+		if trade.Price != px(2500.0) {
+			t.Errorf("Expected trade price 2500.0, got %v", trade.Price)
 		}
 		if trade.Qty != 10 {
 			t.Errorf("Expected trade qty 10, got %d", trade.Qty)
 		}
+		if trade.Buyer != "buyer" || trade.Seller != "seller" {
+			t.Errorf("Expected buyer and seller user IDs, got %q and %q", trade.Buyer, trade.Seller)
+		}
 	case <-time.After(time.Second):
 		t.Error("Expected trade, but none received")
 	}
 }
 
 func TestMatchingEnginePartialFill(t *testing.T) {
-	log := logger.New(logger.ERROR)
-	me := engine.NewMatchingEngine(100, log)
-	me.Start()
+	me := newTestEngine()
 
-	buyOrder := engine.NewOrder("TEST", engine.BUY, 2500.0, 20, "buyer")
+	buyOrder := engine.NewOrder("TEST", engine.BUY, px(2500.0), 20, "buyer")
 	me.GetOrderChan() <- buyOrder
 
 	time.Sleep(time.Millisecond * 10)
 
-	sellOrder := engine.NewOrder("TEST", engine.SELL, 2500.0, 10, "seller")
+	sellOrder := engine.NewOrder("TEST", engine.SELL, px(2500.0), 10, "seller")
 	me.GetOrderChan() <- sellOrder
 
 	time.Sleep(time.Millisecond * 10)
@@ -161,22 +236,20 @@ func TestMatchingEnginePartialFill(t *testing.T) {
 	}
 
 	bestBid := book.GetBestBid()
-	if bestBid == nil || *bestBid != 2500.0 {
+	if bestBid == nil || *bestBid != px(2500.0) {
 		t.Error("Expected remaining buy order in book")
 	}
 }
 
 func TestMatchingEngineNoMatch(t *testing.T) {
-	log := logger.New(logger.ERROR)
-	me := engine.NewMatchingEngine(100, log)
-	me.Start()
+	me := newTestEngine()
 
-	buyOrder := engine.NewOrder("TEST", engine.BUY, 2500.0, 10, "buyer")
+	buyOrder := engine.NewOrder("TEST", engine.BUY, px(2500.0), 10, "buyer")
 	me.GetOrderChan() <- buyOrder
 
 	time.Sleep(time.Millisecond * 10)
 
-	sellOrder := engine.NewOrder("TEST", engine.SELL, 2510.0, 10, "seller")
+	sellOrder := engine.NewOrder("TEST", engine.SELL, px(2510.0), 10, "seller")
 	me.GetOrderChan() <- sellOrder
 
 	time.Sleep(time.Millisecond * 10)
@@ -193,20 +266,2206 @@ func TestMatchingEngineNoMatch(t *testing.T) {
 	}
 }
 
-func BenchmarkOrderCreation(b *testing.B) {
-	for i := 0; i < b.N; i++ {
-		_ = engine.NewOrder("TEST", engine.BUY, 2500.0, 10, "user")
+func TestMatchingEngineCancel(t *testing.T) {
+	me := newTestEngine()
+
+	buyOrder := engine.NewOrder("TEST", engine.BUY, px(2500.0), 10, "buyer")
+	me.GetOrderChan() <- buyOrder
+
+	time.Sleep(time.Millisecond * 10)
+
+	if result := me.CancelOrder(buyOrder.ID); result != engine.CANCEL_OK {
+		t.Errorf("Expected cancelled, got %s", result)
+	}
+
+	if me.GetBook("TEST").GetBestBid() != nil {
+		t.Error("Expected cancelled order to leave the book")
+	}
+
+	if result := me.CancelOrder(buyOrder.ID); result != engine.CANCEL_ALREADY_CANCELLED {
+		t.Errorf("Expected already_cancelled, got %s", result)
 	}
 }
 
-func BenchmarkMatchingEngine(b *testing.B) {
-	log := logger.New(logger.ERROR)
-	me := engine.NewMatchingEngine(10000, log)
+func TestMatchingEngineCancelFilled(t *testing.T) {
+	me := newTestEngine()
+
+	buyOrder := engine.NewOrder("TEST", engine.BUY, px(2500.0), 10, "buyer")
+	me.GetOrderChan() <- buyOrder
+
+	time.Sleep(time.Millisecond * 10)
+
+	sellOrder := engine.NewOrder("TEST", engine.SELL, px(2500.0), 10, "seller")
+	me.GetOrderChan() <- sellOrder
+
+	time.Sleep(time.Millisecond * 10)
+
+	if result := me.CancelOrder(buyOrder.ID); result != engine.CANCEL_FILLED {
+		t.Errorf("Expected already_filled, got %s", result)
+	}
+}
+
+func TestMatchingEngineCancelFinished(t *testing.T) {
+	me := newTestEngine()
+	defer me.Stop()
+	ctx := context.Background()
+
+	ioc := engine.NewOrder("TEST", engine.BUY, px(2500.0), 10, "buyer")
+	ioc.TimeInForce = engine.IOC
+	bad := engine.NewOrder("TEST", engine.BUY, px(2500.01), 10, "buyer")
+	for _, order := range []*engine.Order{ioc, bad} {
+		if _, err := me.Submit(ctx, order); err != nil {
+			t.Fatalf("Submit failed: %v", err)
+		}
+	}
+
+	tests := []struct {
+		order  *engine.Order
+		cancel engine.CancelResult
+		amend  engine.AmendResult
+	}{
+		{ioc, engine.CANCEL_ALREADY_EXPIRED, engine.AMEND_ALREADY_EXPIRED},
+		{bad, engine.CANCEL_ALREADY_REJECTED, engine.AMEND_ALREADY_REJECTED},
+	}
+	for _, tt := range tests {
+		if result := me.CancelOrder(tt.order.ID); result != tt.cancel {
+			t.Errorf("Expected cancel of %s order to return %s, got %s", tt.order.Status, tt.cancel, result)
+		}
+		if result := me.AmendOrder(tt.order.ID, 0, 5); result != tt.amend {
+			t.Errorf("Expected amend of %s order to return %s, got %s", tt.order.Status, tt.amend, result)
+		}
+	}
+}
+
+func TestMatchingEngineCancelUnknown(t *testing.T) {
+	me := newTestEngine()
+
+	order := engine.NewOrder("TEST", engine.BUY, px(2500.0), 10, "buyer")
+	if result := me.CancelOrder(order.ID); result != engine.CANCEL_UNKNOWN {
+		t.Errorf("Expected unknown_order, got %s", result)
+	}
+}
+
+func TestMatchingEngineOrderRetention(t *testing.T) {
+	me := engine.NewMatchingEngine(100, logger.New(logger.ERROR))
+	me.SetInstrument(engine.DefaultInstrument("TEST"))
+	me.SetOrderRetention(2)
 	me.Start()
+	defer me.Stop()
+	ctx := context.Background()
 
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		order := engine.NewOrder("TEST", engine.BUY, 2500.0, 10, "user")
-		me.GetOrderChan() <- order
+	resting, _ := me.Submit(ctx, engine.NewOrder("TEST", engine.BUY, px(99.0), 10, "buyer"))
+	var finished []uuid.UUID
+	for range 3 {
+		result, _ := me.Submit(ctx, engine.NewOrder("TEST", engine.BUY, px(100.01), 10, "buyer"))
+		finished = append(finished, result.Order.ID)
+	}
+
+	if _, found := me.GetOrder(finished[0]); found {
+		t.Error("Expected the oldest finished order to be forgotten")
+	}
+	if result := me.CancelOrder(finished[0]); result != engine.CANCEL_UNKNOWN {
+		t.Errorf("Expected unknown_order for a forgotten order, got %s", result)
+	}
+	for _, id := range finished[1:] {
+		if order, found := me.GetOrder(id); !found || order.Status != engine.REJECTED {
+			t.Errorf("Expected the last two finished orders kept as rejected, got %v %s", found, order.Status)
+		}
+	}
+	if order, found := me.GetOrder(resting.Order.ID); !found || order.Status != engine.NEW {
+		t.Errorf("Expected the resting order kept however old, got %v %s", found, order.Status)
+	}
+}
+
+func TestMatchingEngineAmendKeepsPriority(t *testing.T) {
+	me := newTestEngine()
+
+	first := engine.NewOrder("TEST", engine.BUY, px(2500.0), 10, "first")
+	me.GetOrderChan() <- first
+	time.Sleep(time.Millisecond * 10)
+
+	second := engine.NewOrder("TEST", engine.BUY, px(2500.0), 10, "second")
+	me.GetOrderChan() <- second
+	time.Sleep(time.Millisecond * 10)
+
+	if result := me.AmendOrder(first.ID, 0, 5); result != engine.AMEND_OK {
+		t.Fatalf("Expected amended, got %s", result)
+	}
+
+	sellOrder := engine.NewOrder("TEST", engine.SELL, px(2500.0), 5, "seller")
+	me.GetOrderChan() <- sellOrder
+
+	select {
+	case trade := <-me.GetTradeChan():
+		if trade.BuyOrder != first.ID {
+			t.Error("Expected reduced order to keep time priority")
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected trade, but none received")
+	}
+}
+
+func TestMatchingEngineAmendLosesPriority(t *testing.T) {
+	me := newTestEngine()
+
+	first := engine.NewOrder("TEST", engine.BUY, px(2500.0), 10, "first")
+	me.GetOrderChan() <- first
+	time.Sleep(time.Millisecond * 10)
+
+	second := engine.NewOrder("TEST", engine.BUY, px(2500.0), 10, "second")
+	me.GetOrderChan() <- second
+	time.Sleep(time.Millisecond * 10)
+
+	if result := me.AmendOrder(first.ID, 0, 20); result != engine.AMEND_OK {
+		t.Fatalf("Expected amended, got %s", result)
+	}
+
+	sellOrder := engine.NewOrder("TEST", engine.SELL, px(2500.0), 5, "seller")
+	me.GetOrderChan() <- sellOrder
+
+	select {
+	case trade := <-me.GetTradeChan():
+		if trade.BuyOrder != second.ID {
+			t.Error("Expected increased order to lose time priority")
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected trade, but none received")
+	}
+}
+
+func TestMatchingEngineAmendCrosses(t *testing.T) {
+	me := newTestEngine()
+
+	sellOrder := engine.NewOrder("TEST", engine.SELL, px(2510.0), 10, "seller")
+	me.GetOrderChan() <- sellOrder
+	time.Sleep(time.Millisecond * 10)
+
+	buyOrder := engine.NewOrder("TEST", engine.BUY, px(2500.0), 10, "buyer")
+	me.GetOrderChan() <- buyOrder
+	time.Sleep(time.Millisecond * 10)
+
+	if result := me.AmendOrder(buyOrder.ID, px(2510.0), 0); result != engine.AMEND_OK {
+		t.Fatalf("Expected amended, got %s", result)
+	}
+
+	select {
+	case trade := <-me.GetTradeChan():
+		if trade.Price != px(2510.0) || trade.Qty != 10 {
+			t.Errorf("Expected 10 @ 2510.0, got %d @ %v", trade.Qty, trade.Price)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected amended order to cross")
+	}
+
+	if result := me.AmendOrder(buyOrder.ID, px(2520.0), 0); result != engine.AMEND_FILLED {
+		t.Errorf("Expected already_filled, got %s", result)
+	}
+}
+
+func TestMatchingEngineMarketOrderSweeps(t *testing.T) {
+	me := newTestEngine()
+
+	me.GetOrderChan() <- engine.NewOrder("TEST", engine.SELL, px(2500.0), 5, "seller1")
+	me.GetOrderChan() <- engine.NewOrder("TEST", engine.SELL, px(2510.0), 5, "seller2")
+	time.Sleep(time.Millisecond * 10)
+
+	me.GetOrderChan() <- engine.NewMarketOrder("TEST", engine.BUY, 15, engine.IOC, "buyer")
+	time.Sleep(time.Millisecond * 10)
+
+	filled := 0
+	for i := 0; i < 2; i++ {
+		select {
+		case trade := <-me.GetTradeChan():
+			filled += trade.Qty
+		case <-time.After(time.Second):
+			t.Fatal("Expected market order to sweep both levels")
+		}
+	}
+	if filled != 10 {
+		t.Errorf("Expected 10 filled, got %d", filled)
+	}
+
+	book := me.GetBook("TEST")
+	if book.GetBestAsk() != nil {
+		t.Error("Expected sell side to be empty")
+	}
+	if book.GetBestBid() != nil {
+		t.Error("Expected market order remainder not to rest")
+	}
+}
+
+func TestMatchingEngineIOCDropsRemainder(t *testing.T) {
+	me := newTestEngine()
+
+	me.GetOrderChan() <- engine.NewOrder("TEST", engine.SELL, px(2500.0), 5, "seller")
+	time.Sleep(time.Millisecond * 10)
+
+	buyOrder := engine.NewOrder("TEST", engine.BUY, px(2500.0), 10, "buyer")
+	buyOrder.TimeInForce = engine.IOC
+	me.GetOrderChan() <- buyOrder
+	time.Sleep(time.Millisecond * 10)
+
+	select {
+	case trade := <-me.GetTradeChan():
+		if trade.Qty != 5 {
+			t.Errorf("Expected partial fill of 5, got %d", trade.Qty)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected trade, but none received")
+	}
+
+	if me.GetBook("TEST").GetBestBid() != nil {
+		t.Error("Expected IOC remainder to be dropped")
+	}
+}
+
+func TestMatchingEngineFOK(t *testing.T) {
+	me := newTestEngine()
+
+	me.GetOrderChan() <- engine.NewOrder("TEST", engine.SELL, px(2500.0), 5, "seller1")
+	me.GetOrderChan() <- engine.NewOrder("TEST", engine.SELL, px(2505.0), 5, "seller2")
+	time.Sleep(time.Millisecond * 10)
+
+	killed := engine.NewOrder("TEST", engine.BUY, px(2500.0), 10, "buyer")
+	killed.TimeInForce = engine.FOK
+	me.GetOrderChan() <- killed
+	time.Sleep(time.Millisecond * 10)
+
+	select {
+	case <-me.GetTradeChan():
+		t.Fatal("Did not expect a partial FOK trade")
+	case <-time.After(time.Millisecond * 50):
+	}
+
+	filled := engine.NewOrder("TEST", engine.BUY, px(2505.0), 10, "buyer")
+	filled.TimeInForce = engine.FOK
+	me.GetOrderChan() <- filled
+	time.Sleep(time.Millisecond * 10)
+
+	total := 0
+	for i := 0; i < 2; i++ {
+		select {
+		case trade := <-me.GetTradeChan():
+			total += trade.Qty
+		case <-time.After(time.Second):
+			t.Fatal("Expected FOK order to fill in full")
+		}
+	}
+	if total != 10 {
+		t.Errorf("Expected 10 filled, got %d", total)
+	}
+}
+
+func TestMatchingEnginePostOnlyReject(t *testing.T) {
+	me := newTestEngine()
+
+	me.GetOrderChan() <- engine.NewOrder("TEST", engine.SELL, px(2500.0), 10, "seller")
+	time.Sleep(time.Millisecond * 10)
+
+	buyOrder := engine.NewOrder("TEST", engine.BUY, px(2500.0), 10, "maker")
+	buyOrder.PostOnly = engine.POST_ONLY_REJECT
+	me.GetOrderChan() <- buyOrder
+	time.Sleep(time.Millisecond * 10)
+
+	select {
+	case <-me.GetTradeChan():
+		t.Error("Did not expect post-only order to trade")
+	case <-time.After(time.Millisecond * 50):
+	}
+
+	if me.GetBook("TEST").GetBestBid() != nil {
+		t.Error("Expected crossing post-only order to be rejected")
+	}
+}
+
+func TestMatchingEnginePostOnlySlide(t *testing.T) {
+	me := newTestEngine()
+
+	me.GetOrderChan() <- engine.NewOrder("TEST", engine.SELL, px(2500.0), 10, "seller")
+	time.Sleep(time.Millisecond * 10)
+
+	buyOrder := engine.NewOrder("TEST", engine.BUY, px(2505.0), 10, "maker")
+	buyOrder.PostOnly = engine.POST_ONLY_SLIDE
+	me.GetOrderChan() <- buyOrder
+	time.Sleep(time.Millisecond * 10)
+
+	select {
+	case <-me.GetTradeChan():
+		t.Error("Did not expect post-only order to trade")
+	case <-time.After(time.Millisecond * 50):
+	}
+
+	bestBid := me.GetBook("TEST").GetBestBid()
+	if bestBid == nil || *bestBid != px(2500.0)-engine.DefaultInstrument("TEST").TickSize {
+		t.Errorf("Expected bid one tick behind the ask, got %v", bestBid)
+	}
+}
+
+func TestMatchingEnginePostOnlySlideFloor(t *testing.T) {
+	me := newTestEngine()
+	ctx := context.Background()
+	tick := engine.DefaultInstrument("TEST").TickSize
+
+	me.Submit(ctx, engine.NewOrder("TEST", engine.SELL, tick, 10, "seller"))
+
+	buyOrder := engine.NewOrder("TEST", engine.BUY, tick*2, 10, "maker")
+	buyOrder.PostOnly = engine.POST_ONLY_SLIDE
+	result, err := me.Submit(ctx, buyOrder)
+	if err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+	if result.Order.Status != engine.REJECTED || len(result.Trades) != 0 {
+		t.Errorf("Expected a buy that cannot slide below a one-tick ask to be rejected, got %s", result.Order.Status)
+	}
+	if bid := me.GetBook("TEST").GetBestBid(); bid != nil {
+		t.Errorf("Expected no bid, got %v", bid)
+	}
+}
+
+func TestMatchingEngineStopCascade(t *testing.T) {
+	me := newTestEngine()
+
+	me.GetOrderChan() <- engine.NewOrder("TEST", engine.BUY, px(2500.0), 5, "bidder1")
+	me.GetOrderChan() <- engine.NewOrder("TEST", engine.BUY, px(2490.0), 5, "bidder2")
+	me.GetOrderChan() <- engine.NewOrder("TEST", engine.BUY, px(2480.0), 5, "bidder3")
+	me.GetOrderChan() <- engine.NewStopOrder("TEST", engine.SELL, px(2495.0), 0, 5, "stop1")
+	me.GetOrderChan() <- engine.NewStopOrder("TEST", engine.SELL, px(2485.0), 0, 5, "stop2")
+	time.Sleep(time.Millisecond * 10)
+
+	if me.GetBook("TEST").GetBestAsk() != nil {
+		t.Error("Expected stop orders to stay hidden")
+	}
+
+	me.GetOrderChan() <- engine.NewOrder("TEST", engine.SELL, px(2500.0), 5, "seller")
+	me.GetOrderChan() <- engine.NewOrder("TEST", engine.SELL, px(2490.0), 5, "seller")
+	time.Sleep(time.Millisecond * 10)
+
+	expected := []engine.Price{px(2500.0), px(2490.0), px(2480.0)}
+	for _, price := range expected {
+		select {
+		case trade := <-me.GetTradeChan():
+			if trade.Price != price {
+				t.Errorf("Expected trade at %v, got %v", price, trade.Price)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected trade at %v, but none received", price)
+		}
+	}
+
+	select {
+	case trade := <-me.GetTradeChan():
+		t.Errorf("Did not expect another trade, got %d @ %v", trade.Qty, trade.Price)
+	case <-time.After(time.Millisecond * 50):
+	}
+
+	if me.GetBook("TEST").GetBestBid() != nil {
+		t.Error("Expected the cascade to clear the bid side")
+	}
+}
+
+func TestMatchingEngineStopLimitRests(t *testing.T) {
+	me := newTestEngine()
+
+	me.GetOrderChan() <- engine.NewOrder("TEST", engine.SELL, px(2500.0), 5, "seller")
+	me.GetOrderChan() <- engine.NewStopOrder("TEST", engine.BUY, px(2500.0), px(2495.0), 5, "stop")
+	me.GetOrderChan() <- engine.NewOrder("TEST", engine.BUY, px(2500.0), 5, "buyer")
+	time.Sleep(time.Millisecond * 10)
+
+	select {
+	case <-me.GetTradeChan():
+	case <-time.After(time.Second):
+		t.Fatal("Expected trade, but none received")
+	}
+
+	bestBid := me.GetBook("TEST").GetBestBid()
+	if bestBid == nil || *bestBid != px(2495.0) {
+		t.Errorf("Expected triggered stop-limit to rest at 2495.0, got %v", bestBid)
+	}
+}
+
+func TestMatchingEngineIceberg(t *testing.T) {
+	me := newTestEngine()
+
+	iceberg := engine.NewIcebergOrder("TEST", engine.SELL, px(2500.0), 30, 10, "iceberg")
+	me.GetOrderChan() <- iceberg
+	time.Sleep(time.Millisecond * 10)
+
+	plain := engine.NewOrder("TEST", engine.SELL, px(2500.0), 10, "plain")
+	me.GetOrderChan() <- plain
+	time.Sleep(time.Millisecond * 10)
+
+	snapshot := me.GetBook("TEST").GetSnapshot(10)
+	if len(snapshot.SellBook) != 1 || snapshot.SellBook[0].Qty != 20 {
+		t.Errorf("Expected only the displayed peak in the snapshot, got %v", snapshot.SellBook)
+	}
+
+	me.GetOrderChan() <- engine.NewOrder("TEST", engine.BUY, px(2500.0), 10, "buyer")
+	me.GetOrderChan() <- engine.NewOrder("TEST", engine.BUY, px(2500.0), 10, "buyer")
+	me.GetOrderChan() <- engine.NewOrder("TEST", engine.BUY, px(2500.0), 15, "buyer")
+	time.Sleep(time.Millisecond * 10)
+
+	expected := []struct {
+		sellOrder uuid.UUID
+		qty       int
+	}{
+		{iceberg.ID, 10},
+		{plain.ID, 10},
+		{iceberg.ID, 10},
+		{iceberg.ID, 5},
+	}
+	for _, exp := range expected {
+		select {
+		case trade := <-me.GetTradeChan():
+			if trade.SellOrder != exp.sellOrder || trade.Qty != exp.qty {
+				t.Errorf("Expected %d from %v, got %d from %v", exp.qty, exp.sellOrder, trade.Qty, trade.SellOrder)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Expected trade, but none received")
+		}
+	}
+}
+
+func TestPriceParsing(t *testing.T) {
+	cases := map[string]engine.Price{
+		"2500":      px(2500.0),
+		"2500.05":   px(2500.05),
+		"0.0001":    1,
+		"-12.5":     px(-12.5),
+		"2512.3871": 25123871,
+	}
+	for input, expected := range cases {
+		price, err := engine.ParsePrice(input)
+		if err != nil {
+			t.Errorf("Unexpected error parsing %s: %v", input, err)
+			continue
+		}
+		if price != expected {
+			t.Errorf("Expected %s to parse as %d, got %d", input, expected, price)
+		}
+		if price.String() != input {
+			t.Errorf("Expected %s to round-trip, got %s", input, price.String())
+		}
+	}
+
+	invalid := map[string]string{
+		"too many decimals": "2500.00001",
+		"signed fraction":   "5.-3",
+		"plus fraction":     "5.+3",
+		"signed whole":      "+5",
+		"double sign":       "--5",
+		"fraction spaces":   "5. 3",
+		"whole overflow":    "922337203685478",
+		"fraction overflow": "922337203685477.5808",
+		"int64 overflow":    "9223372036854775808",
+		"no digits":         ".",
+	}
+	for name, input := range invalid {
+		if price, err := engine.ParsePrice(input); err == nil {
+			t.Errorf("%s: expected error for %q, got %d", name, input, price)
+		}
+	}
+	if price, err := engine.ParsePrice("922337203685477.5807"); err != nil || price != math.MaxInt64 {
+		t.Errorf("Expected the largest price to parse, got %d (%v)", price, err)
+	}
+}
+
+func TestInstrumentGrid(t *testing.T) {
+	inst := engine.Instrument{Symbol: "TEST", TickSize: px(0.05), LotSize: 5, PricePrecision: 2}
+
+	if err := inst.ValidatePrice(px(2500.05)); err != nil {
+		t.Errorf("Expected on-tick price to pass, got %v", err)
+	}
+	if err := inst.ValidatePrice(px(2500.03)); err == nil {
+		t.Error("Expected off-tick price to be rejected")
+	}
+	if err := inst.ValidateQty(7); err == nil {
+		t.Error("Expected off-lot qty to be rejected")
+	}
+	if rounded := inst.RoundToTick(px(2512.3871)); rounded != px(2512.40) {
+		t.Errorf("Expected 2512.40, got %s", rounded)
+	}
+	if formatted := inst.FormatPrice(px(2500.5)); formatted != "2500.50" {
+		t.Errorf("Expected 2500.50, got %s", formatted)
+	}
+}
+
+func TestMatchingEngineRejectsOffTick(t *testing.T) {
+	me := newTestEngine()
+
+	me.GetOrderChan() <- engine.NewOrder("TEST", engine.BUY, px(2500.03), 10, "buyer")
+	time.Sleep(time.Millisecond * 10)
+
+	if book := me.GetBook("TEST"); book != nil && book.GetBestBid() != nil {
+		t.Error("Expected off-tick order to be rejected")
+	}
+}
+
+func TestMatchingEngineRejectsUnknownSymbol(t *testing.T) {
+	me := newTestEngine()
+
+	me.GetOrderChan() <- engine.NewOrder("UNKNOWN", engine.BUY, px(2500.0), 10, "buyer")
+	time.Sleep(time.Millisecond * 10)
+
+	if me.GetBook("UNKNOWN") != nil {
+		t.Error("Expected no book to be created for an unknown symbol")
+	}
+}
+
+func TestMatchingEngineRejectsSuspended(t *testing.T) {
+	me := newTestEngine()
+
+	if err := me.SetInstrumentStatus("TEST", engine.SUSPENDED); err != nil {
+		t.Fatal(err)
+	}
+
+	me.GetOrderChan() <- engine.NewOrder("TEST", engine.BUY, px(2500.0), 10, "buyer")
+	time.Sleep(time.Millisecond * 10)
+
+	if book := me.GetBook("TEST"); book != nil && book.GetBestBid() != nil {
+		t.Error("Expected orders for a suspended instrument to be rejected")
+	}
+}
+
+func TestRegistryOrder(t *testing.T) {
+	registry := engine.NewRegistry()
+	for _, symbol := range []string{"RELIANCE", "TCS", "INFY"} {
+		if err := registry.Set(engine.DefaultInstrument(symbol)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	registry.Set(engine.DefaultInstrument("RELIANCE"))
+
+	symbols := registry.Symbols()
+	if len(symbols) != 3 || symbols[0] != "RELIANCE" || symbols[2] != "INFY" {
+		t.Errorf("Expected registration order, got %v", symbols)
+	}
+
+	bad := engine.DefaultInstrument("BAD")
+	bad.TickSize = 0
+	if err := registry.Set(bad); err == nil {
+		t.Error("Expected invalid instrument to be rejected")
+	}
+}
+
+func TestMatchingEngineShardedOrdering(t *testing.T) {
+	log := logger.New(logger.ERROR)
+	me := engine.NewShardedMatchingEngine(100, 3, log)
+	symbols := []string{"RELIANCE", "TCS", "INFY", "HDFC", "ICICI"}
+	for _, symbol := range symbols {
+		me.SetInstrument(engine.DefaultInstrument(symbol))
+	}
+	me.Start()
+
+	buys := make(map[string][]*engine.Order)
+	for i := 0; i < 20; i++ {
+		for _, symbol := range symbols {
+			order := engine.NewOrder(symbol, engine.BUY, px(1000.0), 1, "buyer")
+			buys[symbol] = append(buys[symbol], order)
+			me.GetOrderChan() <- order
+		}
+	}
+	for _, symbol := range symbols {
+		me.GetOrderChan() <- engine.NewOrder(symbol, engine.SELL, px(1000.0), 20, "seller")
+	}
+
+	next := make(map[string]int)
+	for i := 0; i < 20*len(symbols); i++ {
+		select {
+		case trade := <-me.GetTradeChan():
+			want := buys[trade.Symbol][next[trade.Symbol]]
+			if trade.BuyOrder != want.ID {
+				t.Fatalf("%s: fill %d went to %s, expected %s", trade.Symbol, next[trade.Symbol], trade.BuyOrder, want.ID)
+			}
+			next[trade.Symbol]++
+		case <-time.After(time.Second):
+			t.Fatalf("Expected %d trades, got %d", 20*len(symbols), i)
+		}
+	}
+
+	if shard := me.ShardOf("TCS"); shard < 0 || shard >= me.ShardCount() {
+		t.Errorf("Shard %d out of range", shard)
+	}
+}
+
+func TestMatchingEngineEventSequence(t *testing.T) {
+	me := newTestEngine()
+	me.SetInstrument(engine.DefaultInstrument("OTHER"))
+
+	sell := engine.NewOrder("TEST", engine.SELL, px(2500.0), 10, "seller")
+	me.GetOrderChan() <- sell
+	me.GetOrderChan() <- engine.NewOrder("OTHER", engine.BUY, px(100.0), 1, "buyer")
+	me.GetOrderChan() <- engine.NewOrder("TEST", engine.BUY, px(2500.0), 4, "buyer")
+	time.Sleep(time.Millisecond * 10)
+	me.CancelOrder(sell.ID)
+	me.Stop()
+
+	var events []engine.Event
+	for len(me.GetEventChan()) > 0 {
+		events = append(events, <-me.GetEventChan())
+	}
+	if len(events) == 0 || uint64(len(events)) != me.LastSeq() {
+		t.Fatalf("Expected %d events on the feed, got %d", me.LastSeq(), len(events))
+	}
+
+	symbolSeq := map[string]uint64{}
+	var types []engine.EventType
+	for i, ev := range events {
+		if ev.Seq != uint64(i+1) {
+			t.Errorf("Event %d has seq %d", i, ev.Seq)
+		}
+		if ev.SymbolSeq != symbolSeq[ev.Symbol]+1 {
+			t.Errorf("Event %d on %s has symbol seq %d, expected %d", ev.Seq, ev.Symbol, ev.SymbolSeq, symbolSeq[ev.Symbol]+1)
+		}
+		symbolSeq[ev.Symbol] = ev.SymbolSeq
+		if ev.Type == engine.EVENT_TRADE && ev.Trade.Seq != ev.Seq {
+			t.Errorf("Trade carries seq %d, event has %d", ev.Trade.Seq, ev.Seq)
+		}
+		if ev.Symbol == "TEST" {
+			types = append(types, ev.Type)
+		}
+	}
+
+	want := []engine.EventType{
+		engine.EVENT_ACCEPTED, engine.EVENT_BOOK,
+		engine.EVENT_ACCEPTED, engine.EVENT_TRADE, engine.EVENT_FILL, engine.EVENT_FILL, engine.EVENT_BOOK,
+		engine.EVENT_CANCELLED, engine.EVENT_BOOK,
+	}
+	if !reflect.DeepEqual(types, want) {
+		t.Errorf("Expected TEST events %v, got %v", want, types)
+	}
+	if seq := me.GetBook("TEST").GetSnapshot(10).Seq; seq != symbolSeq["TEST"] {
+		t.Errorf("Expected book snapshot at seq %d, got %d", symbolSeq["TEST"], seq)
+	}
+
+	recovered, first, last := me.Events(2, 0, "TEST", 0)
+	if first != 1 || last != me.LastSeq() {
+		t.Errorf("Expected retained range 1-%d, got %d-%d", me.LastSeq(), first, last)
+	}
+	if len(recovered) != len(want)-1 || recovered[0].Type != engine.EVENT_BOOK {
+		t.Errorf("Expected TEST events after seq 1, got %+v", recovered)
+	}
+	final := events[len(events)-1]
+	if final.Level == nil || final.Level.Qty != 0 {
+		t.Errorf("Expected cancel to empty the ask level, got %+v", final.Level)
+	}
+}
+
+func TestMatchingEngineOrderLifecycle(t *testing.T) {
+	me := newTestEngine()
+
+	sell := engine.NewOrder("TEST", engine.SELL, px(2500.0), 10, "seller")
+	me.GetOrderChan() <- sell
+	me.GetOrderChan() <- engine.NewOrder("TEST", engine.BUY, px(2500.0), 4, "buyer")
+	me.GetOrderChan() <- engine.NewOrder("TEST", engine.BUY, px(2501.0), 4, "buyer")
+	ioc := engine.NewOrder("TEST", engine.BUY, px(2500.0), 5, "buyer")
+	ioc.TimeInForce = engine.IOC
+	me.GetOrderChan() <- ioc
+	time.Sleep(time.Millisecond * 10)
+	me.CancelOrder(ioc.ID)
+	me.Stop()
+
+	var statuses []engine.OrderStatus
+	var report engine.Order
+	for len(me.GetEventChan()) > 0 {
+		ev := <-me.GetEventChan()
+		if ev.OrderID == sell.ID && ev.Order != nil {
+			statuses = append(statuses, ev.Order.Status)
+			report = *ev.Order
+		}
+		if ev.OrderID == ioc.ID && ev.Order != nil {
+			ioc = ev.Order
+		}
+	}
+
+	want := []engine.OrderStatus{engine.NEW, engine.PARTIALLY_FILLED, engine.PARTIALLY_FILLED, engine.FILLED}
+	if !reflect.DeepEqual(statuses, want) {
+		t.Errorf("Expected sell transitions %v, got %v", want, statuses)
+	}
+	if report.FilledQty != 10 || report.AvgPrice != px(2500.0) || report.LeavesQty() != 0 {
+		t.Errorf("Expected 10 filled at 2500 with nothing left, got %d at %v leaving %d",
+			report.FilledQty, report.AvgPrice, report.LeavesQty())
+	}
+	if ioc.Status != engine.EXPIRED || ioc.FilledQty != 2 || ioc.OrigQty() != 5 {
+		t.Errorf("Expected IOC expired after filling 2 of 5, got %s with %d of %d",
+			ioc.Status, ioc.FilledQty, ioc.OrigQty())
+	}
+}
+
+func TestMatchingEngineSubmit(t *testing.T) {
+	me := newTestEngine()
+	ctx := context.Background()
+
+	rest, err := me.Submit(ctx, engine.NewOrder("TEST", engine.SELL, px(2500.0), 10, "seller"))
+	if err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+	if rest.Order.Status != engine.NEW || len(rest.Trades) != 0 {
+		t.Errorf("Expected resting order with no trades, got %s with %d trades", rest.Order.Status, len(rest.Trades))
+	}
+
+	hit, err := me.Submit(ctx, engine.NewOrder("TEST", engine.BUY, px(2501.0), 4, "buyer"))
+	if err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+	if hit.Order.Status != engine.FILLED || len(hit.Trades) != 1 || hit.Trades[0].Price != px(2500.0) {
+		t.Errorf("Expected one fill at 2500, got %s with trades %+v", hit.Order.Status, hit.Trades)
+	}
+
+	fok := engine.NewOrder("TEST", engine.BUY, px(2500.0), 20, "buyer")
+	fok.TimeInForce = engine.FOK
+	killed, err := me.Submit(ctx, fok)
+	if err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+	if killed.Order.Status != engine.EXPIRED || killed.Reason != "fok not fillable" {
+		t.Errorf("Expected FOK to expire unfilled, got %s (%q)", killed.Order.Status, killed.Reason)
+	}
+
+	bad, err := me.Submit(ctx, engine.NewOrder("NOPE", engine.BUY, px(1.0), 1, "buyer"))
+	if err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+	if bad.Order.Status != engine.REJECTED || bad.Reason != "unknown symbol" {
+		t.Errorf("Expected unknown symbol reject, got %s (%q)", bad.Order.Status, bad.Reason)
+	}
+}
+
+func TestMatchingEngineSubmitCancelled(t *testing.T) {
+	log := logger.New(logger.ERROR)
+	me := engine.NewMatchingEngine(100, log)
+	me.SetInstrument(engine.DefaultInstrument("TEST"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	order := engine.NewOrder("TEST", engine.BUY, px(2500.0), 10, "buyer")
+	if _, err := me.Submit(ctx, order); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected deadline exceeded, got %v", err)
+	}
+
+	me.Start()
+	me.Stop()
+	if bid := me.GetBook("TEST"); bid != nil && bid.GetBestBid() != nil {
+		t.Errorf("Abandoned order should not rest, best bid %v", *bid.GetBestBid())
+	}
+	ev := <-me.GetEventChan()
+	if ev.Type != engine.EVENT_REJECTED || ev.OrderID != order.ID || ev.Reason != "submission cancelled" {
+		t.Errorf("Expected abandoned order to be rejected, got %+v", ev)
+	}
+}
+
+func TestMatchingEngineAfterStop(t *testing.T) {
+	me := newTestEngine()
+	resting := engine.NewOrder("TEST", engine.SELL, px(2500.0), 10, "seller")
+	if _, err := me.Submit(context.Background(), resting); err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+	me.Stop()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if result := me.CancelOrder(resting.ID); result != engine.CANCEL_REJECTED {
+			t.Errorf("Expected cancel after Stop to be rejected, got %s", result)
+		}
+		if result := me.AmendOrder(resting.ID, px(2501.0), 10); result != engine.AMEND_REJECTED {
+			t.Errorf("Expected amend after Stop to be rejected, got %s", result)
+		}
+		if _, exists := me.GetOrder(resting.ID); exists {
+			t.Error("Expected no order lookup after Stop")
+		}
+		if err := me.SetTradingState("TEST", engine.HALTED, "test"); !errors.Is(err, engine.ErrEngineStopped) {
+			t.Errorf("Expected halt after Stop to fail with ErrEngineStopped, got %v", err)
+		}
+		order := engine.NewOrder("TEST", engine.BUY, px(2500.0), 1, "buyer")
+		if _, err := me.Submit(context.Background(), order); !errors.Is(err, engine.ErrEngineStopped) {
+			t.Errorf("Expected Submit after Stop to fail with ErrEngineStopped, got %v", err)
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Engine calls blocked after Stop")
+	}
+	if !me.Stopped() {
+		t.Error("Expected Stopped to report true")
+	}
+}
+
+func TestMatchingEngineSelfTradePrevention(t *testing.T) {
+	tests := []struct {
+		mode      engine.SelfTradeMode
+		status    engine.OrderStatus
+		trades    int
+		askQty    int
+		ownStatus engine.OrderStatus
+	}{
+		{engine.STP_CANCEL_NEWEST, engine.CANCELLED, 0, 15, engine.NEW},
+		{engine.STP_CANCEL_OLDEST, engine.FILLED, 1, 1, engine.CANCELLED},
+		{engine.STP_CANCEL_BOTH, engine.CANCELLED, 0, 5, engine.CANCELLED},
+		{engine.STP_DECREMENT, engine.CANCELLED, 0, 11, engine.NEW},
+		{engine.STP_OFF, engine.FILLED, 1, 11, engine.PARTIALLY_FILLED},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode.String(), func(t *testing.T) {
+			me := newTestEngine()
+			me.SetSelfTradeMode("mm", tt.mode)
+			ctx := context.Background()
+
+			own, _ := me.Submit(ctx, engine.NewOrder("TEST", engine.SELL, px(2500.0), 10, "mm"))
+			me.Submit(ctx, engine.NewOrder("TEST", engine.SELL, px(2500.0), 5, "other"))
+
+			result, err := me.Submit(ctx, engine.NewOrder("TEST", engine.BUY, px(2500.0), 4, "mm"))
+			if err != nil {
+				t.Fatalf("Submit failed: %v", err)
+			}
+			if result.Order.Status != tt.status || len(result.Trades) != tt.trades {
+				t.Errorf("Expected aggressor %s with %d trades, got %s with %d",
+					tt.status, tt.trades, result.Order.Status, len(result.Trades))
+			}
+			for _, trade := range result.Trades {
+				if tt.mode != engine.STP_OFF && trade.SellOrder == own.Order.ID {
+					t.Errorf("Traded against own order: %+v", trade)
+				}
+			}
+
+			snap := me.GetBook("TEST").GetSnapshot(1)
+			if len(snap.SellBook) != 1 || snap.SellBook[0].Qty != tt.askQty {
+				t.Errorf("Expected %d left at the ask, got %+v", tt.askQty, snap.SellBook)
+			}
+			if order, _ := me.GetOrder(own.Order.ID); order.Status != tt.ownStatus {
+				t.Errorf("Expected resting order %s, got %s", tt.ownStatus, order.Status)
+			}
+		})
+	}
+}
+
+func TestMatchingEngineSelfTradeFOK(t *testing.T) {
+	tests := []struct {
+		mode   engine.SelfTradeMode
+		status engine.OrderStatus
+		filled int
+		asks   int
+	}{
+		// Killed before the ask ahead of the own order trades.
+		{engine.STP_CANCEL_NEWEST, engine.EXPIRED, 0, 15},
+		{engine.STP_CANCEL_BOTH, engine.EXPIRED, 0, 15},
+		{engine.STP_CANCEL_OLDEST, engine.FILLED, 10, 0},
+		// The own order takes up 5 of the 10 without trading.
+		{engine.STP_DECREMENT, engine.CANCELLED, 5, 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode.String(), func(t *testing.T) {
+			me := newTestEngine()
+			me.SetSelfTradeMode("mm", tt.mode)
+			ctx := context.Background()
+
+			me.Submit(ctx, engine.NewOrder("TEST", engine.SELL, px(100.0), 5, "other"))
+			me.Submit(ctx, engine.NewOrder("TEST", engine.SELL, px(101.0), 5, "mm"))
+			me.Submit(ctx, engine.NewOrder("TEST", engine.SELL, px(102.0), 5, "other"))
+
+			order := engine.NewOrder("TEST", engine.BUY, px(102.0), 10, "mm")
+			order.TimeInForce = engine.FOK
+			result, _ := me.Submit(ctx, order)
+			if result.Order.Status != tt.status || result.Order.FilledQty != tt.filled {
+				t.Errorf("Expected %s with %d filled, got %s with %d (%q)",
+					tt.status, tt.filled, result.Order.Status, result.Order.FilledQty, result.Reason)
+			}
+
+			asks := 0
+			for _, level := range me.GetBook("TEST").GetSnapshot(10).SellBook {
+				asks += level.Qty
+			}
+			if asks != tt.asks {
+				t.Errorf("Expected %d left on the asks, got %d", tt.asks, asks)
+			}
+		})
+	}
+
+	// Pro-rata would share the level with the own order behind enough
+	// liquidity, so that level counts as blocked too.
+	me := engine.NewMatchingEngine(100, logger.New(logger.ERROR))
+	inst := engine.DefaultInstrument("TEST")
+	inst.Matching = engine.MatchingRule{Algorithm: engine.MATCH_PRO_RATA}
+	me.SetInstrument(inst)
+	me.SetSelfTradeMode("mm", engine.STP_CANCEL_NEWEST)
+	me.Start()
+	defer me.Stop()
+	ctx := context.Background()
+	me.Submit(ctx, engine.NewOrder("TEST", engine.SELL, px(100.0), 10, "other"))
+	me.Submit(ctx, engine.NewOrder("TEST", engine.SELL, px(100.0), 10, "mm"))
+	order := engine.NewOrder("TEST", engine.BUY, px(100.0), 10, "mm")
+	order.TimeInForce = engine.FOK
+	if result, _ := me.Submit(ctx, order); result.Order.Status != engine.EXPIRED || len(result.Trades) != 0 {
+		t.Errorf("Expected pro-rata FOK killed with no trades, got %s with %d", result.Order.Status, len(result.Trades))
+	}
+}
+
+func TestMatchingEngineSelfTradeOrderOverride(t *testing.T) {
+	me := newTestEngine()
+	me.SetSelfTradeMode("mm", engine.STP_CANCEL_NEWEST)
+	ctx := context.Background()
+
+	me.Submit(ctx, engine.NewOrder("TEST", engine.SELL, px(2500.0), 10, "mm"))
+	order := engine.NewOrder("TEST", engine.BUY, px(2500.0), 4, "mm")
+	order.SelfTrade = engine.STP_OFF
+	result, _ := me.Submit(ctx, order)
+	if result.Order.Status != engine.FILLED {
+		t.Errorf("Expected per-order OFF to allow the match, got %s", result.Order.Status)
+	}
+}
+
+func TestMatchingEngineBuyingPower(t *testing.T) {
+	me := newTestEngine()
+	me.SetAccountPolicy(true, []string{"mm"})
+	ctx := context.Background()
+	if err := me.Deposit("alice", px(10000.0), "", 0); err != nil {
+		t.Fatalf("Deposit failed: %v", err)
+	}
+
+	rejects := []struct {
+		order  *engine.Order
+		reason string
+	}{
+		{engine.NewOrder("TEST", engine.BUY, px(2500.0), 5, "alice"), "insufficient buying power"},
+		{engine.NewOrder("TEST", engine.SELL, px(2500.0), 1, "alice"), "insufficient position"},
+		{engine.NewOrder("TEST", engine.BUY, px(1.0), 1, "bob"), "unknown account"},
+	}
+	for _, tt := range rejects {
+		if reason := me.CheckFunds(tt.order); reason != tt.reason {
+			t.Errorf("Expected CheckFunds %q, got %q", tt.reason, reason)
+		}
+		result, _ := me.Submit(ctx, tt.order)
+		if result.Order.Status != engine.REJECTED || result.Reason != tt.reason {
+			t.Errorf("Expected reject %q, got %s (%q)", tt.reason, result.Order.Status, result.Reason)
+		}
+	}
+
+	bid, _ := me.Submit(ctx, engine.NewOrder("TEST", engine.BUY, px(2500.0), 4, "alice"))
+	if bid.Order.Status != engine.NEW {
+		t.Fatalf("Expected covered bid to rest, got %s (%q)", bid.Order.Status, bid.Reason)
+	}
+	if acct, _ := me.GetAccount("alice"); acct.Reserved != px(10000.0) || acct.Available != 0 {
+		t.Errorf("Expected all cash reserved, got %+v", acct)
+	}
+	if result := me.AmendOrder(bid.Order.ID, 0, 5); result != engine.AMEND_INSUFFICIENT_FUNDS {
+		t.Errorf("Expected amend up to be refused, got %s", result)
+	}
+	if err := me.Withdraw("alice", px(1.0), "", 0); !errors.Is(err, engine.ErrInsufficientFunds) {
+		t.Errorf("Expected reserved cash to be withdrawn-proof, got %v", err)
+	}
+
+	me.Submit(ctx, engine.NewOrder("TEST", engine.SELL, px(2500.0), 3, "mm"))
+	acct, _ := me.GetAccount("alice")
+	want := []engine.Position{{Symbol: "TEST", Qty: 3, Available: 3}}
+	if acct.Cash != px(2500.0) || acct.Reserved != px(2500.0) || !reflect.DeepEqual(acct.Positions, want) {
+		t.Errorf("Expected fill to move cash and shares, got %+v", acct)
+	}
+
+	ask, _ := me.Submit(ctx, engine.NewOrder("TEST", engine.SELL, px(2600.0), 3, "alice"))
+	if acct, _ := me.GetAccount("alice"); ask.Order.Status != engine.NEW || acct.Positions[0].Reserved != 3 {
+		t.Errorf("Expected ask to hold its shares, got %s and %+v", ask.Order.Status, acct.Positions)
+	}
+	me.CancelOrder(bid.Order.ID)
+	me.CancelOrder(ask.Order.ID)
+	if acct, _ := me.GetAccount("alice"); acct.Reserved != 0 || acct.Positions[0].Reserved != 0 {
+		t.Errorf("Expected cancels to release holds, got %+v", acct)
+	}
+}
+
+func TestMatchingEngineAccountsRecover(t *testing.T) {
+	wal := &memJournal{}
+	me := newTestEngine()
+	me.SetJournal(wal)
+	me.SetAccountPolicy(true, []string{"mm"})
+	ctx := context.Background()
+
+	me.Deposit("alice", px(10000.0), "TEST", 2)
+	me.Submit(ctx, engine.NewOrder("TEST", engine.SELL, px(2500.0), 10, "mm"))
+	me.Submit(ctx, engine.NewOrder("TEST", engine.BUY, px(2500.0), 3, "alice"))
+	me.Submit(ctx, engine.NewOrder("TEST", engine.SELL, px(2600.0), 4, "alice"))
+	me.Submit(ctx, engine.NewOrder("TEST", engine.BUY, px(2400.0), 1, "alice"))
+	me.Withdraw("alice", px(100.0), "", 0)
+	state := me.Capture()
+	me.Stop()
+	want, _ := me.GetAccount("alice")
+	if want.Cash != px(2400.0) || want.Reserved != px(2400.0) || want.Positions[0].Reserved != 4 {
+		t.Fatalf("Unexpected account before recovery: %+v", want)
+	}
+
+	log := logger.New(logger.ERROR)
+	recovered := engine.NewMatchingEngine(100, log)
+	recovered.SetInstrument(engine.DefaultInstrument("TEST"))
+	if _, err := recovered.Recover(wal); err != nil {
+		t.Fatalf("Recover failed: %v", err)
+	}
+	if got, _ := recovered.GetAccount("alice"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Recovered account %+v, expected %+v", got, want)
+	}
+
+	restored := engine.NewMatchingEngine(100, log)
+	restored.SetInstrument(engine.DefaultInstrument("TEST"))
+	restored.Restore(state)
+	if got, _ := restored.GetAccount("alice"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Restored account %+v, expected %+v", got, want)
+	}
+}
+
+func TestMatchingEngineFees(t *testing.T) {
+	me := newTestEngine()
+	err := me.SetFeeSchedule(engine.FeeSchedule{
+		Default:  engine.FeeRates{MakerBps: 1, TakerBps: 2},
+		Tiers:    map[string]engine.FeeRates{"lp": {MakerBps: -0.5, TakerBps: 1}},
+		Accounts: map[string]string{"mm": "lp"},
+	})
+	if err != nil {
+		t.Fatalf("SetFeeSchedule failed: %v", err)
+	}
+	if err := me.SetFeeSchedule(engine.FeeSchedule{Accounts: map[string]string{"mm": "gold"}}); err == nil {
+		t.Error("Expected unknown tier to be refused")
+	}
+	me.SetAccountPolicy(true, nil)
+	me.Deposit("mm", 0, "TEST", 10)
+	me.Deposit("alice", px(10000.0), "", 0)
+	ctx := context.Background()
+
+	// 2 bps on top of 4 x 2500 does not fit in 10000.
+	tooBig, _ := me.Submit(ctx, engine.NewOrder("TEST", engine.BUY, px(2500.0), 4, "alice"))
+	if tooBig.Reason != "insufficient buying power" {
+		t.Errorf("Expected the fee to count against buying power, got %s (%q)", tooBig.Order.Status, tooBig.Reason)
+	}
+
+	ask, _ := me.Submit(ctx, engine.NewOrder("TEST", engine.SELL, px(2000.0), 10, "mm"))
+	hit, _ := me.Submit(ctx, engine.NewOrder("TEST", engine.BUY, px(2000.0), 4, "alice"))
+	if len(hit.Trades) != 1 {
+		t.Fatalf("Expected one trade, got %+v", hit.Trades)
+	}
+	trade := hit.Trades[0]
+	if trade.Aggressor != hit.Order.ID || trade.TakerFee != px(1.6) || trade.MakerFee != px(-0.4) {
+		t.Errorf("Expected taker fee 1.6 and maker rebate 0.4, got %+v", trade)
+	}
+	if trade.BuyerFee() != px(1.6) || trade.SellerFee() != px(-0.4) || trade.FeeFor(ask.Order.ID) != px(-0.4) {
+		t.Errorf("Fees attributed to the wrong side: %+v", trade)
+	}
+
+	if acct, _ := me.GetAccount("alice"); acct.Cash != px(10000.0-8000.0-1.6) {
+		t.Errorf("Expected taker fee debited, got cash %v", acct.Cash)
+	}
+	if acct, _ := me.GetAccount("mm"); acct.Cash != px(8000.0+0.4) {
+		t.Errorf("Expected maker rebate credited, got cash %v", acct.Cash)
+	}
+}
+
+func TestMatchingEngineCircuitBreakerFOK(t *testing.T) {
+	me := engine.NewMatchingEngine(100, logger.New(logger.ERROR))
+	me.SetInstrument(engine.DefaultInstrument("TEST"))
+	me.SetCircuitBreakers(engine.CircuitBreakers{
+		Default: engine.CircuitBreaker{MovePct: 5, Window: time.Minute, Halt: time.Minute},
+	})
+	me.Start()
+	defer me.Stop()
+	ctx := context.Background()
+
+	me.Submit(ctx, engine.NewOrder("TEST", engine.SELL, px(100.0), 5, "mm"))
+	me.Submit(ctx, engine.NewOrder("TEST", engine.SELL, px(120.0), 5, "mm"))
+
+	// The sweep would trip the breaker at 120 after trading at 100, so the
+	// order is killed before either trade and the book keeps trading.
+	order := engine.NewOrder("TEST", engine.BUY, px(120.0), 10, "alice")
+	order.TimeInForce = engine.FOK
+	result, _ := me.Submit(ctx, order)
+	if result.Order.Status != engine.EXPIRED || len(result.Trades) != 0 || result.Reason != "fok would trip circuit breaker" {
+		t.Errorf("Expected FOK killed with no trades, got %s with %d (%q)", result.Order.Status, len(result.Trades), result.Reason)
+	}
+	if state := me.TradingStatus("TEST").State; state != engine.CONTINUOUS {
+		t.Errorf("Expected the book still trading, got %s", state)
+	}
+
+	// Within the band it fills in full.
+	order = engine.NewOrder("TEST", engine.BUY, px(100.0), 5, "alice")
+	order.TimeInForce = engine.FOK
+	if result, _ := me.Submit(ctx, order); result.Order.Status != engine.FILLED {
+		t.Errorf("Expected FOK within the band filled, got %s (%q)", result.Order.Status, result.Reason)
+	}
+}
+
+func TestMatchingEngineCircuitBreaker(t *testing.T) {
+	log := logger.New(logger.ERROR)
+	breakers := engine.CircuitBreakers{
+		Default: engine.CircuitBreaker{MovePct: 10, Window: time.Minute, Halt: 20 * time.Millisecond},
+	}
+	newEngine := func() *engine.MatchingEngine {
+		me := engine.NewMatchingEngine(100, log)
+		me.SetClock(engine.NewSimClock(time.Unix(1700000000, 0)))
+		me.SetInstrument(engine.DefaultInstrument("TEST"))
+		if err := me.SetCircuitBreakers(breakers); err != nil {
+			t.Fatalf("SetCircuitBreakers failed: %v", err)
+		}
+		return me
+	}
+	wal := &memJournal{}
+	me := newEngine()
+	me.SetJournal(wal)
+	me.Start()
+	ctx := context.Background()
+
+	me.Submit(ctx, engine.NewOrder("TEST", engine.SELL, px(1000.0), 1, "mm"))
+	me.Submit(ctx, engine.NewOrder("TEST", engine.SELL, px(1050.0), 5, "mm"))
+	me.Submit(ctx, engine.NewOrder("TEST", engine.SELL, px(1150.0), 5, "mm"))
+	me.Submit(ctx, engine.NewOrder("TEST", engine.BUY, px(1000.0), 1, "alice"))
+	bid, _ := me.Submit(ctx, engine.NewOrder("TEST", engine.BUY, px(900.0), 1, "bob"))
+
+	// 1050 is within 10% of 1000; 1150 is not, so the book halts before it
+	// trades and the rest of the sweep is cancelled.
+	sweep, _ := me.Submit(ctx, engine.NewMarketOrder("TEST", engine.BUY, 10, engine.IOC, "alice"))
+	if len(sweep.Trades) != 1 || sweep.Trades[0].Price != px(1050.0) {
+		t.Fatalf("Expected one trade at 1050 before the halt, got %+v", sweep.Trades)
+	}
+	if sweep.Order.Status != engine.CANCELLED || sweep.Reason != "trading halted" {
+		t.Errorf("Expected remainder cancelled by the halt, got %s (%q)", sweep.Order.Status, sweep.Reason)
+	}
+	halted := me.TradingStatus("TEST")
+	if halted.State != engine.HALTED || halted.Until == 0 {
+		t.Fatalf("Expected a timed halt, got %+v", halted)
+	}
+
+	blocked, _ := me.Submit(ctx, engine.NewOrder("TEST", engine.BUY, px(1100.0), 1, "bob"))
+	if blocked.Order.Status != engine.REJECTED || blocked.Reason != "trading halted" {
+		t.Errorf("Expected new order rejected while halted, got %s (%q)", blocked.Order.Status, blocked.Reason)
+	}
+	if result := me.CancelOrder(bid.Order.ID); result != engine.CANCEL_OK {
+		t.Errorf("Expected cancel to work while halted, got %s", result)
+	}
+	state := me.Capture()
+
+	// The halt lifts by itself once its time comes.
+	deadline := time.Now().Add(time.Second)
+	for me.TradingStatus("TEST").State != engine.CONTINUOUS {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed halt did not lift")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := me.SetTradingState("TEST", engine.HALTED, "news pending"); err != nil {
+		t.Fatalf("SetTradingState failed: %v", err)
+	}
+	rest, _ := me.Submit(ctx, engine.NewOrder("TEST", engine.BUY, px(1000.0), 1, "bob"))
+	if rest.Order.Status != engine.REJECTED {
+		t.Errorf("Expected order rejected during manual halt, got %s", rest.Order.Status)
+	}
+	if err := me.SetTradingState("TEST", engine.CONTINUOUS, ""); err != nil {
+		t.Fatalf("SetTradingState failed: %v", err)
+	}
+	rest, _ = me.Submit(ctx, engine.NewOrder("TEST", engine.BUY, px(1000.0), 1, "bob"))
+	if rest.Order.Status != engine.NEW {
+		t.Errorf("Expected order to rest after resume, got %s (%q)", rest.Order.Status, rest.Reason)
+	}
+	me.Stop()
+
+	var changes []engine.TradingState
+	events, _, _ := me.Events(1, 0, "TEST", 0)
+	for _, ev := range events {
+		if ev.Type == engine.EVENT_TRADING_STATE {
+			changes = append(changes, ev.Trading.State)
+		}
+	}
+	want := []engine.TradingState{engine.HALTED, engine.CONTINUOUS, engine.HALTED, engine.CONTINUOUS}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("Expected state events %v, got %v", want, changes)
+	}
+
+	recovered := newEngine()
+	if _, err := recovered.Recover(wal); err != nil {
+		t.Fatalf("Recover failed: %v", err)
+	}
+	if status := recovered.TradingStatus("TEST"); status.State != engine.CONTINUOUS {
+		t.Errorf("Expected recovered book to be trading, got %+v", status)
+	}
+	if ask := recovered.GetBook("TEST").GetBestAsk(); ask == nil || *ask != px(1150.0) {
+		t.Errorf("Expected recovered best ask 1150, got %v", ask)
+	}
+
+	restored := newEngine()
+	restored.Restore(state)
+	if status := restored.TradingStatus("TEST"); status != halted {
+		t.Errorf("Expected restored halt %+v, got %+v", halted, status)
+	}
+}
+
+func TestMatchingEngineAuctionUncross(t *testing.T) {
+	type quote struct {
+		side  engine.Side
+		price float64
+		qty   int
+	}
+	tests := []struct {
+		name   string
+		ref    float64
+		quotes []quote
+		want   engine.Uncross
+	}{
+		{"most volume", 1000, []quote{
+			{engine.BUY, 101, 10}, {engine.BUY, 100, 10},
+			{engine.SELL, 99, 5}, {engine.SELL, 100, 10}, {engine.SELL, 102, 5},
+		}, engine.Uncross{Price: px(100.0), Volume: 15, Imbalance: 5}},
+		{"least imbalance", 1000, []quote{
+			{engine.BUY, 101, 10}, {engine.BUY, 100, 5},
+			{engine.SELL, 99, 10},
+		}, engine.Uncross{Price: px(101.0), Volume: 10}},
+		{"closest to reference", 99, []quote{
+			{engine.BUY, 101, 10},
+			{engine.SELL, 99, 10},
+		}, engine.Uncross{Price: px(99.0), Volume: 10}},
+		{"no cross", 100, []quote{
+			{engine.BUY, 99, 10},
+			{engine.SELL, 101, 10},
+		}, engine.Uncross{}},
+	}
+
+	ctx := context.Background()
+	for _, tt := range tests {
+		me := engine.NewMatchingEngine(100, logger.New(logger.ERROR))
+		inst := engine.DefaultInstrument("TEST")
+		inst.ReferencePrice = px(tt.ref)
+		me.SetInstrument(inst)
+		me.Start()
+		if err := me.SetTradingState("TEST", engine.AUCTION, "test"); err != nil {
+			t.Fatalf("%s: SetTradingState failed: %v", tt.name, err)
+		}
+		for i, q := range tt.quotes {
+			result, _ := me.Submit(ctx, engine.NewOrder("TEST", q.side, px(q.price), q.qty, fmt.Sprintf("user%d", i)))
+			if result.Order.Status != engine.NEW {
+				t.Fatalf("%s: expected order to rest during the call, got %s (%q)", tt.name, result.Order.Status, result.Reason)
+			}
+		}
+		if got := me.IndicativeUncross("TEST"); got != tt.want {
+			t.Errorf("%s: expected indicative %+v, got %+v", tt.name, tt.want, got)
+		}
+		me.Stop()
+	}
+
+	me := engine.NewMatchingEngine(100, logger.New(logger.ERROR))
+	me.SetInstrument(engine.DefaultInstrument("TEST"))
+	me.Start()
+	defer me.Stop()
+	me.SetTradingState("TEST", engine.AUCTION, "test")
+	for _, q := range tests[0].quotes {
+		me.Submit(ctx, engine.NewOrder("TEST", q.side, px(q.price), q.qty, "desk-"+q.side.String()))
+	}
+	market, _ := me.Submit(ctx, engine.NewMarketOrder("TEST", engine.BUY, 5, engine.IOC, "alice"))
+	if market.Order.Status != engine.REJECTED || market.Reason != "not accepted during auction" {
+		t.Errorf("Expected market order rejected during the call, got %s (%q)", market.Order.Status, market.Reason)
+	}
+
+	if err := me.SetTradingState("TEST", engine.CONTINUOUS, "open"); err != nil {
+		t.Fatalf("SetTradingState failed: %v", err)
+	}
+	volume := 0
+	events, _, _ := me.Events(1, 0, "TEST", 0)
+	var indicative, uncross []engine.Uncross
+	for _, ev := range events {
+		switch ev.Type {
+		case engine.EVENT_INDICATIVE:
+			indicative = append(indicative, *ev.Auction)
+		case engine.EVENT_UNCROSS:
+			uncross = append(uncross, *ev.Auction)
+		case engine.EVENT_TRADE:
+			if ev.Trade.Price != px(100.0) {
+				t.Errorf("Expected every auction trade at 100, got %s", ev.Trade.Price)
+			}
+			volume += ev.Trade.Qty
+		}
+	}
+	if len(indicative) == 0 || indicative[len(indicative)-1] != tests[0].want {
+		t.Errorf("Expected indicative feed to end at %+v, got %+v", tests[0].want, indicative)
+	}
+	if len(uncross) != 1 || uncross[0] != tests[0].want || volume != 15 {
+		t.Errorf("Expected one uncross of 15 at 100, got %+v with volume %d", uncross, volume)
+	}
+
+	book := me.GetBook("TEST").GetSnapshot(10)
+	wantBids := []engine.PriceLevel{{Price: px(100.0), Qty: 5}}
+	wantAsks := []engine.PriceLevel{{Price: px(102.0), Qty: 5}}
+	if !reflect.DeepEqual(book.BuyBook, wantBids) || !reflect.DeepEqual(book.SellBook, wantAsks) {
+		t.Errorf("Expected 5@100 / 5@102 left after the uncross, got %v / %v", book.BuyBook, book.SellBook)
+	}
+	if last := me.GetBook("TEST").GetLastPrice(); last == nil || *last != px(100.0) {
+		t.Errorf("Expected the uncross to set the last price, got %v", last)
+	}
+	cont, _ := me.Submit(ctx, engine.NewOrder("TEST", engine.SELL, px(100.0), 5, "bob"))
+	if cont.Order.Status != engine.FILLED {
+		t.Errorf("Expected continuous matching after the open, got %s", cont.Order.Status)
+	}
+}
+
+func TestMatchingEngineSessionSchedule(t *testing.T) {
+	ist := time.FixedZone("IST", 5*3600+1800)
+	day := func(hour, minute int) time.Time {
+		return time.Date(2024, 1, 2, hour, minute, 0, 0, ist)
+	}
+	clock := engine.NewSimClock(day(8, 0))
+
+	me := engine.NewMatchingEngine(100, logger.New(logger.ERROR))
+	me.SetClock(clock)
+	me.SetInstrument(engine.DefaultInstrument("TEST"))
+	me.SetInstrument(engine.DefaultInstrument("HALT"))
+	err := me.SetCalendar(&engine.Calendar{Default: engine.Schedule{
+		Location:       ist,
+		PreOpen:        9 * time.Hour,
+		Open:           9*time.Hour + 15*time.Minute,
+		ClosingAuction: 15*time.Hour + 30*time.Minute,
+		Close:          15*time.Hour + 40*time.Minute,
+	}})
+	if err != nil {
+		t.Fatalf("SetCalendar failed: %v", err)
+	}
+	wal := &memJournal{}
+	me.SetJournal(wal)
+	me.Start()
+	ctx := context.Background()
+
+	step := func(at time.Time, want engine.TradingState) {
+		t.Helper()
+		clock.Set(at)
+		me.CheckSchedule()
+		if got := me.TradingStatus("TEST").State; got != want {
+			t.Fatalf("At %s expected %s, got %s", at.Format("15:04"), want, got)
+		}
+	}
+
+	step(day(8, 0), engine.CLOSED)
+	early, _ := me.Submit(ctx, engine.NewOrder("TEST", engine.BUY, px(1000.0), 10, "alice"))
+	if early.Reason != "trading closed" {
+		t.Errorf("Expected order before the pre-open to be rejected, got %s (%q)", early.Order.Status, early.Reason)
+	}
+
+	step(day(9, 0), engine.AUCTION)
+	if phase, _ := me.SessionPhase("TEST"); phase != engine.PHASE_PRE_OPEN {
+		t.Errorf("Expected PRE_OPEN, got %s", phase)
+	}
+	me.SetTradingState("HALT", engine.HALTED, "pending news")
+	me.Submit(ctx, engine.NewOrder("TEST", engine.BUY, px(1010.0), 10, "alice"))
+	me.Submit(ctx, engine.NewOrder("TEST", engine.SELL, px(995.0), 4, "bob"))
+
+	step(day(9, 15), engine.CONTINUOUS)
+	if last := me.GetBook("TEST").GetLastPrice(); last == nil || *last != px(995.0) {
+		t.Errorf("Expected the open to uncross at 995, got %v", last)
+	}
+	if state := me.TradingStatus("HALT").State; state != engine.HALTED {
+		t.Errorf("Expected halted book to stay halted through the open, got %s", state)
+	}
+
+	step(day(15, 30), engine.AUCTION)
+	me.Submit(ctx, engine.NewOrder("TEST", engine.SELL, px(1005.0), 6, "bob"))
+	step(day(15, 40), engine.CLOSED)
+	if last := me.GetBook("TEST").GetLastPrice(); last == nil || *last != px(1005.0) {
+		t.Errorf("Expected the close to uncross at 1005, got %v", last)
+	}
+	if state := me.TradingStatus("HALT").State; state != engine.CLOSED {
+		t.Errorf("Expected the close to close halted books too, got %s", state)
+	}
+	me.Stop()
+
+	// Phase changes are journaled, so recovery needs no schedule.
+	recovered := engine.NewMatchingEngine(100, logger.New(logger.ERROR))
+	recovered.SetInstrument(engine.DefaultInstrument("TEST"))
+	recovered.SetInstrument(engine.DefaultInstrument("HALT"))
+	if _, err := recovered.Recover(wal); err != nil {
+		t.Fatalf("Recover failed: %v", err)
+	}
+	for _, symbol := range []string{"TEST", "HALT"} {
+		want, got := me.GetBook(symbol).GetSnapshot(10), recovered.GetBook(symbol).GetSnapshot(10)
+		if !reflect.DeepEqual(want, got) {
+			t.Errorf("%s: expected recovered book %+v, got %+v", symbol, want, got)
+		}
+	}
+}
+
+func TestMatchingEngineSessionExpiry(t *testing.T) {
+	at := func(day, hour int) time.Time {
+		return time.Date(2024, 1, day, hour, 0, 0, 0, time.UTC)
+	}
+	clock := engine.NewSimClock(at(5, 10)) // a Friday
+
+	schedule := engine.Schedule{
+		PreOpen:        9 * time.Hour,
+		Open:           9 * time.Hour,
+		ClosingAuction: 15 * time.Hour,
+		Close:          15 * time.Hour,
+		Holidays:       []string{"2024-01-08"},
+	}
+	other := schedule
+	other.Holidays = nil
+
+	me := engine.NewMatchingEngine(100, logger.New(logger.ERROR))
+	me.SetClock(clock)
+	me.SetInstrument(engine.DefaultInstrument("TEST"))
+	me.SetInstrument(engine.DefaultInstrument("OTHER"))
+	if err := me.SetCalendar(&engine.Calendar{Default: schedule, Instruments: map[string]engine.Schedule{"OTHER": other}}); err != nil {
+		t.Fatalf("SetCalendar failed: %v", err)
+	}
+	wal := &memJournal{}
+	me.SetJournal(wal)
+	me.Start()
+	ctx := context.Background()
+
+	order := func(side engine.Side, price float64, tif engine.TimeInForce, gtd string) *engine.Order {
+		o := engine.NewOrder("TEST", side, px(price), 5, "alice")
+		o.TimeInForce = tif
+		o.GoodTillDate = gtd
+		return o
+	}
+	day := order(engine.BUY, 990.0, engine.DAY, "")
+	pastHoliday := order(engine.BUY, 980.0, engine.GTD, "2024-01-08")
+	friday := order(engine.SELL, 1020.0, engine.GTD, "2024-01-05")
+	gtc := order(engine.SELL, 1030.0, engine.GTC, "")
+	stop := engine.NewStopOrder("TEST", engine.SELL, px(900.0), 0, 5, "alice")
+	stop.TimeInForce = engine.DAY
+	for _, o := range []*engine.Order{day, pastHoliday, friday, gtc, stop} {
+		me.Submit(ctx, o)
+	}
+	if bad, _ := me.Submit(ctx, order(engine.BUY, 970.0, engine.GTD, "friday")); bad.Order.Status != engine.REJECTED {
+		t.Errorf("Expected a GTD order without a date to be rejected, got %s", bad.Order.Status)
+	}
+	late, _ := me.Submit(ctx, order(engine.BUY, 970.0, engine.GTD, "2024-01-04"))
+	if late.Order.Status != engine.REJECTED || late.Reason != "good till date is before the current session 2024-01-05" {
+		t.Errorf("Expected a GTD order for a past session to be rejected, got %s (%q)", late.Order.Status, late.Reason)
+	}
+
+	expired := func() map[uuid.UUID]string {
+		t.Helper()
+		events, _, _ := me.Events(1, 0, "TEST", 0)
+		reasons := make(map[uuid.UUID]string)
+		for _, ev := range events {
+			if ev.Type == engine.EVENT_EXPIRED {
+				reasons[ev.OrderID] = ev.Reason
+			}
+		}
+		return reasons
+	}
+	check := func(now time.Time, want engine.TradingState, gone ...*engine.Order) {
+		t.Helper()
+		clock.Set(now)
+		me.CheckSchedule()
+		if got := me.TradingStatus("TEST").State; got != want {
+			t.Fatalf("At %s expected %s, got %s", now.Format(time.DateTime), want, got)
+		}
+		reasons := expired()
+		if len(reasons) != len(gone) {
+			t.Errorf("At %s expected %d orders expired, got %v", now.Format(time.DateTime), len(gone), reasons)
+		}
+		for _, o := range gone {
+			if _, exists := reasons[o.ID]; !exists {
+				t.Errorf("At %s expected order %s expired", now.Format(time.DateTime), o.ID)
+			}
+		}
+	}
+
+	check(at(5, 10), engine.CONTINUOUS)
+	if date, _ := me.SessionDate("TEST"); date != "2024-01-05" {
+		t.Errorf("Expected Friday's session, got %s", date)
+	}
+
+	// Friday's close ends the DAY orders, stops included, and GTD orders
+	// good until Friday.
+	check(at(5, 16), engine.CLOSED, day, friday, stop)
+	reasons := expired()
+	if reasons[day.ID] != "day order expired" || reasons[friday.ID] != "good till date reached" {
+		t.Errorf("Unexpected expiry reasons %v", reasons)
+	}
+
+	// Monday is a holiday for TEST only.
+	check(at(8, 10), engine.CLOSED, day, friday, stop)
+	if phase, _ := me.SessionPhase("OTHER"); phase != engine.PHASE_CONTINUOUS {
+		t.Errorf("Expected OTHER to trade on Monday, got %s", phase)
+	}
+	if date, _ := me.SessionDate("TEST"); date != "2024-01-09" {
+		t.Errorf("Expected the next session on Tuesday, got %s", date)
+	}
+
+	// Jumping from Tuesday into Wednesday still applies Tuesday's close,
+	// which ends the order good until the holiday.
+	check(at(9, 10), engine.CONTINUOUS, day, friday, stop)
+	check(at(10, 10), engine.CONTINUOUS, day, friday, stop, pastHoliday)
+	if o, _ := me.GetOrder(pastHoliday.ID); o.Status != engine.EXPIRED {
+		t.Errorf("Expected the GTD order expired, got %s", o.Status)
+	}
+	me.Stop()
+
+	book := me.GetBook("TEST").GetSnapshot(10)
+	if len(book.BuyBook) != 0 || !reflect.DeepEqual(book.SellBook, []engine.PriceLevel{{Price: px(1030.0), Qty: 5}}) {
+		t.Errorf("Expected only the GTC order left, got %v / %v", book.BuyBook, book.SellBook)
+	}
+
+	recovered := engine.NewMatchingEngine(100, logger.New(logger.ERROR))
+	recovered.SetInstrument(engine.DefaultInstrument("TEST"))
+	recovered.SetInstrument(engine.DefaultInstrument("OTHER"))
+	if _, err := recovered.Recover(wal); err != nil {
+		t.Fatalf("Recover failed: %v", err)
+	}
+	if got := recovered.GetBook("TEST").GetSnapshot(10); !reflect.DeepEqual(got, book) {
+		t.Errorf("Expected recovered book %+v, got %+v", book, got)
+	}
+}
+
+func TestMatchingEngineSessionOrdersNeedCalendar(t *testing.T) {
+	me := newTestEngine()
+	defer me.Stop()
+
+	for _, tif := range []engine.TimeInForce{engine.DAY, engine.GTD} {
+		order := engine.NewOrder("TEST", engine.BUY, px(2500.0), 10, "buyer")
+		order.TimeInForce = tif
+		order.GoodTillDate = "2030-01-01"
+		result, err := me.Submit(context.Background(), order)
+		if err != nil {
+			t.Fatalf("Submit failed: %v", err)
+		}
+		if result.Order.Status != engine.REJECTED || result.Reason != "DAY and GTD orders need a session calendar" {
+			t.Errorf("Expected %s order without a calendar to be rejected, got %s (%q)", tif, result.Order.Status, result.Reason)
+		}
+	}
+	if book := me.GetBook("TEST"); book != nil && book.GetBestBid() != nil {
+		t.Error("Expected nothing to rest without a calendar")
+	}
+}
+
+func TestMatchingEngineSessionCloseDoesNotBlock(t *testing.T) {
+	at := func(hour int) time.Time {
+		return time.Date(2024, 1, 3, hour, 0, 0, 0, time.UTC)
+	}
+	clock := engine.NewSimClock(at(10))
+	me := engine.NewMatchingEngine(100, logger.New(logger.ERROR))
+	me.SetClock(clock)
+	me.SetInstrument(engine.DefaultInstrument("TEST"))
+	err := me.SetCalendar(&engine.Calendar{Default: engine.Schedule{
+		PreOpen:        9 * time.Hour,
+		Open:           9 * time.Hour,
+		ClosingAuction: 15 * time.Hour,
+		Close:          15 * time.Hour,
+	}})
+	if err != nil {
+		t.Fatalf("SetCalendar failed: %v", err)
+	}
+	wal := &heldJournal{waiting: make(chan struct{})}
+	me.SetJournal(wal)
+	me.Start()
+	defer me.Stop()
+
+	// With the journal held the close waits on its shard; the session
+	// must still answer in the meantime.
+	wal.hold.Lock()
+	clock.Set(at(16))
+	checked := make(chan struct{})
+	go func() {
+		me.CheckSchedule()
+		close(checked)
+	}()
+	<-wal.waiting
+
+	answered := make(chan string)
+	go func() {
+		date, _ := me.SessionDate("TEST")
+		answered <- date
+	}()
+	select {
+	case date := <-answered:
+		if date != "2024-01-04" {
+			t.Errorf("Expected the next session after the close, got %q", date)
+		}
+	case <-time.After(time.Second):
+		wal.hold.Unlock()
+		t.Fatal("SessionDate blocked behind the close")
+	}
+
+	wal.hold.Unlock()
+	<-checked
+	if state := me.TradingStatus("TEST").State; state != engine.CLOSED {
+		t.Errorf("Expected the close to apply once the journal was released, got %s", state)
+	}
+}
+
+func TestMatchingPolicyAllocate(t *testing.T) {
+	level := func(sizes ...int) []*engine.Order {
+		orders := make([]*engine.Order, len(sizes))
+		for i, qty := range sizes {
+			orders[i] = engine.NewOrder("TEST", engine.SELL, px(100.0), qty, fmt.Sprintf("user%d", i))
+		}
+		return orders
+	}
+
+	tests := []struct {
+		name   string
+		policy engine.MatchingPolicy
+		qty    int
+		lot    int
+		want   []int
+	}{
+		{"fifo", engine.FIFO{}, 70, 1, []int{60, 10, 0}},
+		{"pro rata", engine.ProRata{}, 50, 1, []int{30, 15, 5}},
+		{"pro rata remainder by time", engine.ProRata{}, 7, 1, []int{5, 2, 0}},
+		{"pro rata minimum", engine.ProRata{MinQty: 3}, 7, 1, []int{7, 0, 0}},
+		{"pro rata lots", engine.ProRata{}, 25, 5, []int{20, 5, 0}},
+		{"pro rata whole level", engine.ProRata{}, 200, 1, []int{60, 30, 10}},
+		{"top order", engine.TopOrderProRata{}, 70, 1, []int{60, 8, 2}},
+		{"top order capped", engine.TopOrderProRata{MaxTopQty: 20}, 70, 1, []int{46, 18, 6}},
+		{"split", engine.Split{FIFOPct: 40}, 50, 1, []int{36, 11, 3}},
+		{"split all fifo", engine.Split{FIFOPct: 100}, 70, 1, []int{60, 10, 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orders := level(60, 30, 10)
+			got := make([]int, len(orders))
+			last := -1
+			for _, alloc := range tt.policy.Allocate(slices.Values(orders), tt.qty, tt.lot) {
+				i := slices.Index(orders, alloc.Order)
+				if i <= last || alloc.Qty <= 0 || alloc.Qty%tt.lot != 0 {
+					t.Fatalf("Allocation %d of %d out of order or not in lots", alloc.Qty, i)
+				}
+				got[i], last = alloc.Qty, i
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestMatchingEngineProRata(t *testing.T) {
+	me := engine.NewMatchingEngine(100, logger.New(logger.ERROR))
+	inst := engine.DefaultInstrument("TEST")
+	inst.Matching = engine.MatchingRule{Algorithm: engine.MATCH_PRO_RATA}
+	me.SetInstrument(inst)
+	me.SetSelfTradeMode("dave", engine.STP_CANCEL_OLDEST)
+	me.Start()
+	ctx := context.Background()
+
+	alice, _ := me.Submit(ctx, engine.NewOrder("TEST", engine.SELL, px(100.0), 60, "alice"))
+	own, _ := me.Submit(ctx, engine.NewOrder("TEST", engine.SELL, px(100.0), 20, "dave"))
+	bob, _ := me.Submit(ctx, engine.NewOrder("TEST", engine.SELL, px(100.0), 20, "bob"))
+
+	// The first pass gives alice 30 and stops at dave's own order; with that
+	// cancelled, the other 20 are shared between alice and bob.
+	result, _ := me.Submit(ctx, engine.NewOrder("TEST", engine.BUY, px(100.0), 50, "dave"))
+	var fills []int
+	filled := map[uuid.UUID]int{}
+	for _, trade := range result.Trades {
+		fills = append(fills, trade.Qty)
+		filled[trade.SellOrder] += trade.Qty
+	}
+	if !reflect.DeepEqual(fills, []int{30, 12, 8}) || filled[alice.Order.ID] != 42 || filled[bob.Order.ID] != 8 {
+		t.Errorf("Expected fills of 30, 12 and 8 to alice, alice and bob, got %v", filled)
+	}
+	if order, _ := me.GetOrder(own.Order.ID); order.Status != engine.CANCELLED {
+		t.Errorf("Expected dave's resting order cancelled, got %s", order.Status)
+	}
+
+	// Changing the instrument changes the policy of its book.
+	inst.Matching = engine.MatchingRule{}
+	me.SetInstrument(inst)
+	result, _ = me.Submit(ctx, engine.NewOrder("TEST", engine.BUY, px(100.0), 20, "carol"))
+	if len(result.Trades) != 2 || result.Trades[0].SellOrder != alice.Order.ID || result.Trades[0].Qty != 18 {
+		t.Errorf("Expected FIFO to fill alice's last 18 first, got %+v", result.Trades)
+	}
+
+	inst.Matching = engine.MatchingRule{Algorithm: engine.MATCH_SPLIT, FIFOPct: 120}
+	if err := me.SetInstrument(inst); err == nil {
+		t.Error("Expected fifo_pct over 100 to be rejected")
+	}
+}
+
+func TestMatchingEngineProRataCircuitBreaker(t *testing.T) {
+	me := engine.NewMatchingEngine(100, logger.New(logger.ERROR))
+	inst := engine.DefaultInstrument("TEST")
+	inst.Matching = engine.MatchingRule{Algorithm: engine.MATCH_PRO_RATA}
+	me.SetInstrument(inst)
+	me.SetCircuitBreakers(engine.CircuitBreakers{
+		Default: engine.CircuitBreaker{MovePct: 10, Window: time.Minute},
+	})
+	me.Start()
+	defer me.Stop()
+	ctx := context.Background()
+
+	me.Submit(ctx, engine.NewOrder("TEST", engine.SELL, px(100.0), 10, "mm"))
+	me.Submit(ctx, engine.NewOrder("TEST", engine.BUY, px(100.0), 10, "mm"))
+
+	// 105 is within the band, so the whole level is shared out.
+	var asks []engine.SubmitResult
+	for _, user := range []string{"alice", "bob", "carol"} {
+		ask, _ := me.Submit(ctx, engine.NewOrder("TEST", engine.SELL, px(105.0), 20, user))
+		asks = append(asks, ask)
+	}
+	result, _ := me.Submit(ctx, engine.NewOrder("TEST", engine.BUY, px(105.0), 30, "dave"))
+	if len(result.Trades) != 3 || result.Order.FilledQty != 30 {
+		t.Fatalf("Expected the level shared three ways, got %+v", result.Trades)
+	}
+
+	// 120 is not: none of its allocations trade.
+	for _, user := range []string{"alice", "bob"} {
+		me.Submit(ctx, engine.NewOrder("TEST", engine.SELL, px(120.0), 20, user))
+	}
+	for _, ask := range asks {
+		me.CancelOrder(ask.Order.ID)
+	}
+	result, _ = me.Submit(ctx, engine.NewMarketOrder("TEST", engine.BUY, 30, engine.IOC, "dave"))
+	if len(result.Trades) != 0 || me.TradingStatus("TEST").State != engine.HALTED {
+		t.Errorf("Expected the book halted before any trade at 120, got %+v", result.Trades)
+	}
+	if snap := me.GetBook("TEST").GetSnapshot(1); len(snap.SellBook) != 1 || snap.SellBook[0].Qty != 40 {
+		t.Errorf("Expected both asks at 120 untouched, got %+v", snap.SellBook)
+	}
+}
+
+func TestSetInstrumentGridWithRestingOrders(t *testing.T) {
+	me := newTestEngine()
+	ctx := context.Background()
+	inst := engine.DefaultInstrument("TEST")
+
+	resting, _ := me.Submit(ctx, engine.NewOrder("TEST", engine.BUY, px(100.05), 10, "alice"))
+
+	changed := inst
+	changed.TickSize = px(0.10)
+	if err := me.SetInstrument(changed); err == nil {
+		t.Error("Expected a tick change with an order resting to be refused")
+	}
+	changed = inst
+	changed.LotSize = 5
+	if err := me.SetInstrument(changed); err == nil {
+		t.Error("Expected a lot change with an order resting to be refused")
+	}
+	if got, _ := me.GetInstrument("TEST"); got.TickSize != inst.TickSize || got.LotSize != inst.LotSize {
+		t.Errorf("Expected the instrument unchanged, got tick %s lot %d", got.TickSize, got.LotSize)
+	}
+
+	// Other fields can still change, and the grid can once the book is empty.
+	changed = inst
+	changed.Currency = "USD"
+	if err := me.SetInstrument(changed); err != nil {
+		t.Errorf("Expected a change that keeps the grid to be accepted, got %v", err)
+	}
+	me.CancelOrder(resting.Order.ID)
+	changed.TickSize = px(0.10)
+	if err := me.SetInstrument(changed); err != nil {
+		t.Errorf("Expected a tick change on an empty book to be accepted, got %v", err)
+	}
+	if got := me.GetBook("TEST").GetInstrument().TickSize; got != px(0.10) {
+		t.Errorf("Expected the book to use the new tick, got %s", got)
+	}
+}
+
+type memJournal struct {
+	mu      sync.Mutex
+	records []engine.JournalRecord
+}
+
+func (j *memJournal) Append(rec *engine.JournalRecord) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	rec.Seq = uint64(len(j.records) + 1)
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	var copied engine.JournalRecord
+	if err := json.Unmarshal(data, &copied); err != nil {
+		return err
+	}
+	j.records = append(j.records, copied)
+	return nil
+}
+
+func (j *memJournal) LastSeq() uint64 {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return uint64(len(j.records))
+}
+
+func (j *memJournal) ReplayFrom(after uint64, fn func(rec engine.JournalRecord) error) error {
+	j.mu.Lock()
+	records := append([]engine.JournalRecord(nil), j.records...)
+	j.mu.Unlock()
+	for _, rec := range records {
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// heldJournal blocks every append while hold is locked, signalling waiting
+// as each one starts to wait.
+type heldJournal struct {
+	memJournal
+	hold    sync.Mutex
+	waiting chan struct{}
+}
+
+func (j *heldJournal) Append(rec *engine.JournalRecord) error {
+	select {
+	case j.waiting <- struct{}{}:
+	default:
+	}
+	j.hold.Lock()
+	defer j.hold.Unlock()
+	return j.memJournal.Append(rec)
+}
+
+func TestMatchingEngineSlowTradeConsumer(t *testing.T) {
+	me := newTestEngine()
+	defer me.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Nothing reads the trade channel, which holds 1000 trades.
+	const trades = 1100
+	me.Submit(ctx, engine.NewOrder("TEST", engine.SELL, px(100.0), trades, "seller"))
+	for i := range trades {
+		if _, err := me.Submit(ctx, engine.NewOrder("TEST", engine.BUY, px(100.0), 1, "buyer")); err != nil {
+			t.Fatalf("Submit %d stalled behind the trade channel: %v", i, err)
+		}
+	}
+
+	events, _, _ := me.Events(0, 0, "", 0)
+	count := 0
+	for _, ev := range events {
+		if ev.Type == engine.EVENT_TRADE {
+			count++
+		}
+	}
+	if count != trades {
+		t.Errorf("Expected every trade recoverable from the event log, got %d", count)
+	}
+}
+
+func TestMatchingEngineRecoverEventsOutOfOrder(t *testing.T) {
+	wal := &memJournal{}
+	me := newTestEngine()
+	me.SetJournal(wal)
+	ctx := context.Background()
+	me.Submit(ctx, engine.NewOrder("TEST", engine.SELL, px(100.0), 5, "seller"))
+	me.Submit(ctx, engine.NewOrder("TEST", engine.BUY, px(100.0), 5, "buyer"))
+	me.Stop()
+
+	// Shards journal their events after sequencing them, so records can be
+	// a little out of Seq order.
+	var swapped bool
+	for i := 1; i < len(wal.records) && !swapped; i++ {
+		a, b := wal.records[i-1], wal.records[i]
+		if a.Type == engine.RecordEvent && b.Type == engine.RecordEvent {
+			wal.records[i-1], wal.records[i] = b, a
+			swapped = true
+		}
+	}
+	if !swapped {
+		t.Fatal("Expected adjacent event records to swap")
+	}
+
+	recovered := engine.NewMatchingEngine(100, logger.New(logger.ERROR))
+	recovered.SetInstrument(engine.DefaultInstrument("TEST"))
+	if _, err := recovered.Recover(wal); err != nil {
+		t.Fatalf("Recover failed: %v", err)
+	}
+	events, first, last := recovered.Events(0, 0, "", 0)
+	if first != 1 || last != me.LastSeq() || uint64(len(events)) != last {
+		t.Fatalf("Expected events 1 to %d restored, got %d in [%d, %d]", me.LastSeq(), len(events), first, last)
+	}
+	for i, ev := range events {
+		if ev.Seq != uint64(i+1) {
+			t.Errorf("Event %d restored with seq %d", i, ev.Seq)
+		}
+	}
+}
+
+func TestMatchingEngineRecoverFromJournal(t *testing.T) {
+	wal := &memJournal{}
+	me := newTestEngine()
+	me.SetJournal(wal)
+
+	resting := engine.NewOrder("TEST", engine.BUY, px(2500.0), 10, "buyer")
+	cancelled := engine.NewOrder("TEST", engine.BUY, px(2499.0), 5, "buyer")
+	amended := engine.NewOrder("TEST", engine.SELL, px(2510.0), 8, "seller")
+	me.GetOrderChan() <- resting
+	me.GetOrderChan() <- cancelled
+	me.GetOrderChan() <- amended
+	me.GetOrderChan() <- engine.NewOrder("TEST", engine.SELL, px(2500.0), 4, "seller")
+	time.Sleep(time.Millisecond * 10)
+
+	me.CancelOrder(cancelled.ID)
+	me.AmendOrder(amended.ID, px(2505.0), 0)
+	me.Stop()
+
+	want := me.GetBook("TEST").GetSnapshot(10)
+
+	log := logger.New(logger.ERROR)
+	recovered := engine.NewMatchingEngine(100, log)
+	recovered.SetInstrument(engine.DefaultInstrument("TEST"))
+	applied, err := recovered.Recover(wal)
+	if err != nil {
+		t.Fatalf("Recover failed: %v", err)
+	}
+	if applied != 6 {
+		t.Errorf("Expected 6 commands replayed, got %d", applied)
+	}
+
+	got := recovered.GetBook("TEST").GetSnapshot(10)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Recovered book %+v, expected %+v", got, want)
+	}
+	if recovered.LastSeq() != me.LastSeq() {
+		t.Errorf("Expected event seq to resume at %d, got %d", me.LastSeq(), recovered.LastSeq())
+	}
+
+	recovered.Start()
+	if result := recovered.CancelOrder(resting.ID); result != engine.CANCEL_OK {
+		t.Errorf("Expected recovered order to be cancellable, got %s", result)
+	}
+}
+
+func TestMatchingEngineRestoreFromCapture(t *testing.T) {
+	wal := &memJournal{}
+	me := newTestEngine()
+	me.SetJournal(wal)
+
+	first := engine.NewOrder("TEST", engine.BUY, px(2500.0), 10, "buyer")
+	second := engine.NewOrder("TEST", engine.BUY, px(2500.0), 5, "buyer")
+	stop := engine.NewStopOrder("TEST", engine.SELL, px(2490.0), 0, 3, "seller")
+	me.GetOrderChan() <- first
+	me.GetOrderChan() <- second
+	me.GetOrderChan() <- stop
+	time.Sleep(time.Millisecond * 10)
+
+	state := me.Capture()
+	if state.Seq != wal.LastSeq() {
+		t.Errorf("Expected capture at seq %d, got %d", wal.LastSeq(), state.Seq)
+	}
+	if state.OrderCount() != 3 {
+		t.Errorf("Expected 3 orders captured, got %d", state.OrderCount())
+	}
+
+	// Journal tail after the capture.
+	me.CancelOrder(first.ID)
+	me.Stop()
+	want := me.GetBook("TEST").GetSnapshot(10)
+
+	log := logger.New(logger.ERROR)
+	restored := engine.NewMatchingEngine(100, log)
+	restored.SetInstrument(engine.DefaultInstrument("TEST"))
+	restored.Restore(state)
+	applied, err := restored.RecoverFrom(wal, state.Seq)
+	if err != nil {
+		t.Fatalf("RecoverFrom failed: %v", err)
+	}
+	if applied != 1 {
+		t.Errorf("Expected only the cancel to be replayed, got %d commands", applied)
+	}
+	if got := restored.GetBook("TEST").GetSnapshot(10); !reflect.DeepEqual(got, want) {
+		t.Errorf("Restored book %+v, expected %+v", got, want)
+	}
+
+	restored.Start()
+	restored.GetOrderChan() <- engine.NewOrder("TEST", engine.SELL, px(2490.0), 5, "seller")
+
+	select {
+	case trade := <-restored.GetTradeChan():
+		if trade.BuyOrder != second.ID {
+			t.Errorf("Expected restored bid to keep priority, got %s", trade.BuyOrder)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected trade against restored bid")
+	}
+	if result := restored.CancelOrder(stop.ID); result != engine.CANCEL_OK {
+		t.Errorf("Expected restored stop to be cancellable, got %s", result)
+	}
+}
+
+func TestMatchingEngineNewOrderUsesClockAndIDs(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	newEngine := func() *engine.MatchingEngine {
+		me := engine.NewMatchingEngine(100, logger.New(logger.ERROR))
+		me.SetClock(engine.NewSimClock(start))
+		me.SetIDGenerator(engine.NewSequentialIDs(uuid.NameSpaceOID))
+		return me
+	}
+
+	a, b := newEngine(), newEngine()
+	orders := []*engine.Order{
+		a.NewOrder("TEST", engine.BUY, px(100.0), 10, "alice"),
+		a.NewMarketOrder("TEST", engine.SELL, 10, engine.IOC, "alice"),
+		a.NewStopOrder("TEST", engine.BUY, px(101.0), 0, 10, "alice"),
+	}
+	again := []*engine.Order{
+		b.NewOrder("TEST", engine.BUY, px(100.0), 10, "alice"),
+		b.NewMarketOrder("TEST", engine.SELL, 10, engine.IOC, "alice"),
+		b.NewStopOrder("TEST", engine.BUY, px(101.0), 0, 10, "alice"),
+	}
+	for i, order := range orders {
+		if order.Timestamp != start.UnixNano() {
+			t.Errorf("Expected order %d stamped by the engine clock, got %d", i, order.Timestamp)
+		}
+		if order.ID != again[i].ID {
+			t.Errorf("Expected order %d to get the same ID from the same generator, got %s and %s", i, order.ID, again[i].ID)
+		}
+	}
+	if orders[0].ID == orders[1].ID {
+		t.Error("Expected each order to get its own ID")
+	}
+	if orders[1].Type != engine.MARKET || orders[2].Type != engine.STOP {
+		t.Errorf("Expected market and stop orders, got %s and %s", orders[1].Type, orders[2].Type)
+	}
+}
+
+func replayTrades(t *testing.T, records []engine.JournalRecord) []byte {
+	t.Helper()
+
+	clock := engine.NewSimClock(time.Unix(0, 0))
+	me := engine.NewShardedMatchingEngine(100, 3, logger.New(logger.ERROR))
+	me.SetClock(clock)
+	me.SetIDGenerator(engine.NewSequentialIDs(uuid.NameSpaceOID))
+	me.SetInstrument(engine.DefaultInstrument("TEST"))
+	me.SetInstrument(engine.DefaultInstrument("OTHER"))
+
+	var out []byte
+	for _, rec := range records {
+		clock.Set(time.Unix(0, rec.Time))
+		if rec.Order != nil {
+			order := *rec.Order
+			rec.Order = &order
+		}
+		if _, err := me.Apply(rec); err != nil {
+			t.Fatalf("Apply failed: %v", err)
+		}
+		for len(me.GetTradeChan()) > 0 {
+			data, _ := json.Marshal(<-me.GetTradeChan())
+			out = append(append(out, data...), '\n')
+		}
+	}
+	return out
+}
+
+func TestMatchingEngineDeterministicReplay(t *testing.T) {
+	var records []engine.JournalRecord
+	ts := int64(1_000_000)
+	add := func(rec engine.JournalRecord) {
+		ts += 1000
+		rec.Time = ts
+		records = append(records, rec)
+	}
+	order := func(symbol string, side engine.Side, price float64, qty int) *engine.Order {
+		o := engine.NewOrder(symbol, side, px(price), qty, "user")
+		o.Timestamp = ts
+		return o
+	}
+
+	iceberg := engine.NewIcebergOrder("TEST", engine.SELL, px(2501), 30, 10, "mm")
+	add(engine.JournalRecord{Type: engine.RecordNewOrder, Order: iceberg})
+	for i := 0; i < 5; i++ {
+		add(engine.JournalRecord{Type: engine.RecordNewOrder, Order: order("TEST", engine.BUY, 2501, 4)})
+		add(engine.JournalRecord{Type: engine.RecordNewOrder, Order: order("OTHER", engine.SELL, 999, 2)})
+		add(engine.JournalRecord{Type: engine.RecordNewOrder, Order: order("OTHER", engine.BUY, 1000, 3)})
+	}
+	add(engine.JournalRecord{Type: engine.RecordAmend, OrderID: iceberg.ID, Price: px(2500)})
+	add(engine.JournalRecord{Type: engine.RecordNewOrder, Order: order("TEST", engine.BUY, 2500, 8)})
+
+	first := replayTrades(t, records)
+	second := replayTrades(t, records)
+
+	if len(first) == 0 {
+		t.Fatal("Expected replay to produce trades")
+	}
+	if !bytes.Equal(first, second) {
+		t.Errorf("Replay output differs between runs:\n%s\n---\n%s", first, second)
+	}
+}
+
+func BenchmarkOrderCreation(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		_ = engine.NewOrder("TEST", engine.BUY, px(2500.0), 10, "user")
+	}
+}
+
+func BenchmarkMatchingEngine(b *testing.B) {
+	log := logger.New(logger.ERROR)
+	me := engine.NewMatchingEngine(10000, log)
+	me.SetInstrument(engine.DefaultInstrument("TEST"))
+	me.Start()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		order := engine.NewOrder("TEST", engine.BUY, px(2500.0), 10, "user")
+		me.GetOrderChan() <- order
+	}
+}
+
+func benchmarkOrders(n int) []*engine.Order {
+	orders := make([]*engine.Order, n)
+	for i := range orders {
+		price := px(2400.0 + float64(i%200)*0.5)
+		orders[i] = engine.NewOrder("TEST", engine.BUY, price, 10, "user")
+	}
+	return orders
+}
+
+func BenchmarkHeapInsertCancel(b *testing.B) {
+	orders := benchmarkOrders(10000)
+	heap := engine.NewBuyHeap()
+	for _, o := range orders[:5000] {
+		heap.Push(o)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		o := orders[5000+i%5000]
+		heap.Push(o)
+		heap.Remove(o.ID)
+	}
+}
+
+func BenchmarkBookInsertCancel(b *testing.B) {
+	orders := benchmarkOrders(10000)
+	book := engine.NewOrderBook("TEST")
+	for _, o := range orders[:5000] {
+		book.AddOrder(o)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		o := orders[5000+i%5000]
+		book.AddOrder(o)
+		book.RemoveOrder(o.ID)
+	}
+}
+
+func BenchmarkHeapTopLevels(b *testing.B) {
+	heap := engine.NewBuyHeap()
+	for _, o := range benchmarkOrders(5000) {
+		heap.Push(o)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		levels := make(map[engine.Price]int)
+		for _, o := range heap.Orders() {
+			levels[o.Price] += o.Qty
+		}
+		prices := make([]engine.Price, 0, len(levels))
+		for price := range levels {
+			prices = append(prices, price)
+		}
+		sort.Slice(prices, func(i, j int) bool { return prices[i] > prices[j] })
+		_ = prices[:min(10, len(prices))]
+	}
+}
+
+func BenchmarkBookTopLevels(b *testing.B) {
+	book := engine.NewOrderBook("TEST")
+	for _, o := range benchmarkOrders(5000) {
+		book.AddOrder(o)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = book.GetSnapshot(10)
 	}
 }
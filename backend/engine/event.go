@@ -0,0 +1,305 @@
+package engine
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+type EventType string
+
+const (
+	EVENT_ACCEPTED      EventType = "accepted"
+	EVENT_REJECTED      EventType = "rejected"
+	EVENT_TRADE         EventType = "trade"
+	EVENT_FILL          EventType = "fill"
+	EVENT_CANCELLED     EventType = "cancelled"
+	EVENT_EXPIRED       EventType = "expired"
+	EVENT_AMENDED       EventType = "amended"
+	EVENT_BOOK          EventType = "book"
+	EVENT_SELF_TRADE    EventType = "self_trade_prevented"
+	EVENT_TRADING_STATE EventType = "trading_state"
+	EVENT_INDICATIVE    EventType = "indicative"
+	EVENT_UNCROSS       EventType = "uncross"
+)
+
+// DefaultEventLogSize is how many recent events the engine keeps for gap
+// recovery.
+const DefaultEventLogSize = 65536
+
+// Event is one sequenced engine output. Seq goes up by one for every event
+// the engine emits and SymbolSeq by one for every event on that symbol, so a
+// consumer that sees a jump in either has missed something and can fetch it
+// with Events. Rejects for unknown symbols carry no SymbolSeq.
+//
+// Every order event (accepted, rejected, fill, cancelled, expired, amended)
+// is an execution report: Order is a copy taken right after the change, with
+// its status, filled quantity and average price. Fill events also carry the
+// trade that caused them.
+type Event struct {
+	Seq       uint64          `json:"seq"`
+	SymbolSeq uint64          `json:"symbol_seq,omitzero"`
+	Type      EventType       `json:"type"`
+	Symbol    string          `json:"symbol"`
+	Timestamp int64           `json:"timestamp"`
+	OrderID   uuid.UUID       `json:"order_id,omitzero"`
+	Order     *Order          `json:"order,omitempty"`
+	Trade     *Trade          `json:"trade,omitempty"`
+	Level     *LevelUpdate    `json:"level,omitempty"`
+	SelfTrade *SelfTradeMatch `json:"self_trade,omitempty"`
+	Trading   *TradingStatus  `json:"trading,omitempty"`
+	Auction   *Uncross        `json:"auction,omitempty"`
+	Reason    string          `json:"reason,omitempty"`
+}
+
+// LevelUpdate is the displayed quantity now resting at a price. Zero means
+// the level is gone.
+type LevelUpdate struct {
+	Side  Side  `json:"side"`
+	Price Price `json:"price"`
+	Qty   int   `json:"qty"`
+}
+
+// eventLog is a ring of the most recent events indexed by Seq. Events can
+// be added out of order, as the journal may hold them; a Seq never added
+// leaves a hole that get skips.
+type eventLog struct {
+	mu     sync.RWMutex
+	events []Event
+	first  uint64
+	last   uint64
+}
+
+func newEventLog(size int) *eventLog {
+	if size <= 0 {
+		size = DefaultEventLogSize
+	}
+	return &eventLog{events: make([]Event, size)}
+}
+
+func (l *eventLog) add(ev Event) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	size := uint64(len(l.events))
+	if l.first != 0 && ev.Seq+size <= l.last {
+		return
+	}
+	l.events[ev.Seq%size] = ev
+	if l.first == 0 || ev.Seq < l.first {
+		l.first = ev.Seq
+	}
+	l.last = max(l.last, ev.Seq)
+	if l.last-l.first >= size {
+		l.first = l.last - size + 1
+	}
+}
+
+// get returns events with from <= Seq <= to, optionally for one symbol, up to
+// limit. A to of zero means the latest.
+func (l *eventLog) get(from, to uint64, symbol string, limit int) []Event {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if l.first == 0 {
+		return nil
+	}
+	from = max(from, l.first)
+	if to == 0 || to > l.last {
+		to = l.last
+	}
+
+	size := uint64(len(l.events))
+	events := make([]Event, 0)
+	for seq := from; seq <= to && (limit <= 0 || len(events) < limit); seq++ {
+		ev := l.events[seq%size]
+		if ev.Seq != seq || symbol != "" && ev.Symbol != symbol {
+			continue
+		}
+		events = append(events, ev)
+	}
+	return events
+}
+
+func (l *eventLog) bounds() (first, last uint64) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.first, l.last
+}
+
+func (me *MatchingEngine) GetEventChan() <-chan Event {
+	return me.eventChan
+}
+
+// LastSeq is the Seq of the most recent event.
+func (me *MatchingEngine) LastSeq() uint64 {
+	me.eventMu.Lock()
+	defer me.eventMu.Unlock()
+	return me.eventSeq
+}
+
+// Events returns retained events in [from, to] and the range still held.
+// Events older than first have been overwritten and cannot be recovered.
+func (me *MatchingEngine) Events(from, to uint64, symbol string, limit int) (events []Event, first, last uint64) {
+	first, last = me.events.bounds()
+	return me.events.get(from, to, symbol, limit), first, last
+}
+
+// emit sequences an event, keeps it for recovery, journals it and publishes
+// it. Only sequencing holds the lock shared by every shard; the journal
+// write and the sends come after, and a full channel drops the event rather
+// than stall matching. Consumers fetch what they missed with Events. Events
+// from different shards can therefore be journaled and delivered a little
+// out of Seq order, but never out of SymbolSeq order. During recovery the
+// journaled copies of the original events are loaded instead, so nothing is
+// emitted.
+func (me *MatchingEngine) emit(book *OrderBook, ev Event) {
+	if me.replaying {
+		return
+	}
+
+	me.eventMu.Lock()
+	me.eventSeq++
+	ev.Seq = me.eventSeq
+	if book != nil {
+		ev.SymbolSeq = book.seq.Add(1)
+	}
+	ev.Timestamp = me.now()
+	if ev.Type == EVENT_TRADE {
+		ev.Trade.Seq = ev.Seq
+		ev.Trade.SymbolSeq = ev.SymbolSeq
+	}
+
+	me.events.add(ev)
+	me.eventMu.Unlock()
+
+	me.journalEvent(&JournalRecord{Type: RecordEvent, Event: &ev})
+
+	if ev.Type == EVENT_TRADE {
+		select {
+		case me.tradeChan <- ev.Trade:
+		default:
+			me.logger.Warn("Trade feed full, consumer must recover", "seq", ev.Seq)
+		}
+	}
+	select {
+	case me.eventChan <- ev:
+	default:
+		me.logger.Debug("Event feed full, consumer must recover", "seq", ev.Seq)
+	}
+}
+
+// restoreEvent loads a journaled event during recovery so sequencing
+// continues where the previous run stopped.
+func (me *MatchingEngine) restoreEvent(ev Event) {
+	me.eventMu.Lock()
+	defer me.eventMu.Unlock()
+
+	me.events.add(ev)
+	me.eventSeq = max(me.eventSeq, ev.Seq)
+	if ev.SymbolSeq == 0 {
+		return
+	}
+	if book := me.GetBook(ev.Symbol); book != nil && ev.SymbolSeq > book.seq.Load() {
+		book.seq.Store(ev.SymbolSeq)
+	}
+}
+
+func (me *MatchingEngine) emitOrder(book *OrderBook, kind EventType, order *Order, reason string) {
+	order.recordReason(reason)
+	me.accounts.settle(order)
+	me.retire(order)
+	copied := *order
+	copied.pending = nil
+	me.emit(book, Event{
+		Type:    kind,
+		Symbol:  order.Symbol,
+		OrderID: order.ID,
+		Order:   &copied,
+		Reason:  reason,
+	})
+}
+
+func (me *MatchingEngine) emitFill(book *OrderBook, order *Order, trade *Trade) {
+	order.recordFill(trade)
+	me.accounts.fill(order, trade.Price, trade.Qty, trade.FeeFor(order.ID))
+	me.accounts.settle(order)
+	me.retire(order)
+	copied := *order
+	copied.pending = nil
+	me.emit(book, Event{
+		Type:    EVENT_FILL,
+		Symbol:  order.Symbol,
+		OrderID: order.ID,
+		Order:   &copied,
+		Trade:   trade,
+	})
+}
+
+// flushBook emits one book event per price level changed by the last
+// command, best price first, and during an auction the indicative uncross
+// if it moved.
+func (me *MatchingEngine) flushBook(symbol string) {
+	book := me.GetBook(symbol)
+	if book == nil {
+		return
+	}
+
+	book.mu.Lock()
+	defer book.mu.Unlock()
+
+	for _, side := range []Side{BUY, SELL} {
+		for _, update := range book.side(side).drain(side) {
+			me.emit(book, Event{Type: EVENT_BOOK, Symbol: symbol, Level: &update})
+		}
+	}
+	if book.trading.State == AUCTION {
+		me.publishIndicative(book)
+	}
+}
+
+func (ob *OrderBook) side(side Side) *bookSide {
+	if side == BUY {
+		return ob.bids
+	}
+	return ob.asks
+}
+
+// Seq is the SymbolSeq of the last event on this book.
+func (ob *OrderBook) Seq() uint64 {
+	return ob.seq.Load()
+}
+
+func (bs *bookSide) touch(price Price) {
+	bs.dirty[price] = struct{}{}
+}
+
+func (bs *bookSide) drain(side Side) []LevelUpdate {
+	if len(bs.dirty) == 0 {
+		return nil
+	}
+
+	updates := make([]LevelUpdate, 0, len(bs.dirty))
+	for price := range bs.dirty {
+		updates = append(updates, LevelUpdate{Side: side, Price: price, Qty: bs.levelQty(price)})
+	}
+	clear(bs.dirty)
+
+	sort.Slice(updates, func(i, j int) bool {
+		return bs.better(updates[i].Price, updates[j].Price)
+	})
+	return updates
+}
+
+func (bs *bookSide) levelQty(price Price) int {
+	level, exists := bs.byPrice[price]
+	if !exists {
+		return 0
+	}
+	qty := 0
+	for e := level.orders.Front(); e != nil; e = e.Next() {
+		qty += e.Value.(*Order).displayedQty()
+	}
+	return qty
+}
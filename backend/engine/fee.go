@@ -0,0 +1,135 @@
+package engine
+
+import (
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// maxFeeBps bounds any configured rate to +/-10%.
+const maxFeeBps = 1000
+
+// FeeRates are charged on a trade's notional, in basis points. A negative
+// maker rate is a rebate.
+type FeeRates struct {
+	MakerBps float64 `json:"maker_bps"`
+	TakerBps float64 `json:"taker_bps"`
+}
+
+func (r FeeRates) validate() error {
+	if math.Abs(r.MakerBps) > maxFeeBps || math.Abs(r.TakerBps) > maxFeeBps {
+		return fmt.Errorf("fee rates must be within %d bps", maxFeeBps)
+	}
+	return nil
+}
+
+func (r FeeRates) fee(notional Price, maker bool) Price {
+	bps := r.TakerBps
+	if maker {
+		bps = r.MakerBps
+	}
+	return Price(math.Round(float64(notional) * bps / 10000))
+}
+
+// maxFee is the most an order could pay on notional, whichever side of
+// the trade it ends up on.
+func (r FeeRates) maxFee(notional Price) Price {
+	return max(r.fee(notional, true), r.fee(notional, false), 0)
+}
+
+// FeeSchedule picks the rates for an account trading a symbol: its tier's
+// rates if it has one, otherwise the instrument's, otherwise Default.
+type FeeSchedule struct {
+	Default     FeeRates            `json:"default"`
+	Instruments map[string]FeeRates `json:"instruments,omitempty"`
+	Tiers       map[string]FeeRates `json:"tiers,omitempty"`
+	Accounts    map[string]string   `json:"accounts,omitempty"`
+}
+
+func (s FeeSchedule) Validate() error {
+	if err := s.Default.validate(); err != nil {
+		return fmt.Errorf("default: %w", err)
+	}
+	for symbol, rates := range s.Instruments {
+		if err := rates.validate(); err != nil {
+			return fmt.Errorf("instrument %s: %w", symbol, err)
+		}
+	}
+	for tier, rates := range s.Tiers {
+		if err := rates.validate(); err != nil {
+			return fmt.Errorf("tier %s: %w", tier, err)
+		}
+	}
+	for userID, tier := range s.Accounts {
+		if _, exists := s.Tiers[tier]; !exists {
+			return fmt.Errorf("account %s: unknown tier %q", userID, tier)
+		}
+	}
+	return nil
+}
+
+func (s FeeSchedule) rates(userID, symbol string) FeeRates {
+	if tier, exists := s.Accounts[userID]; exists {
+		return s.Tiers[tier]
+	}
+	if rates, exists := s.Instruments[symbol]; exists {
+		return rates
+	}
+	return s.Default
+}
+
+type feeTable struct {
+	schedule FeeSchedule
+	mu       sync.RWMutex
+}
+
+func (me *MatchingEngine) SetFeeSchedule(s FeeSchedule) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+
+	me.fees.mu.Lock()
+	defer me.fees.mu.Unlock()
+	me.fees.schedule = s
+	return nil
+}
+
+// FeeRates returns the rates userID currently pays on symbol.
+func (me *MatchingEngine) FeeRates(userID, symbol string) FeeRates {
+	me.fees.mu.RLock()
+	defer me.fees.mu.RUnlock()
+	return me.fees.schedule.rates(userID, symbol)
+}
+
+// resolveFees fixes the order's rates before it is journaled, so a replay
+// charges what the original run did whatever the schedule is by then.
+func (me *MatchingEngine) resolveFees(order *Order) {
+	order.Fees = me.FeeRates(order.UserID, order.Symbol)
+}
+
+// chargeFees prices both sides of a trade from the orders' own rates.
+func (t *Trade) chargeFees(maker, taker *Order) {
+	amount := notional(t.Price, t.Qty)
+	t.Aggressor = taker.ID
+	t.MakerFee = maker.Fees.fee(amount, true)
+	t.TakerFee = taker.Fees.fee(amount, false)
+}
+
+// FeeFor returns what the given order paid on this trade. Negative is a
+// rebate.
+func (t *Trade) FeeFor(orderID uuid.UUID) Price {
+	if orderID == t.Aggressor {
+		return t.TakerFee
+	}
+	return t.MakerFee
+}
+
+func (t *Trade) BuyerFee() Price {
+	return t.FeeFor(t.BuyOrder)
+}
+
+func (t *Trade) SellerFee() Price {
+	return t.FeeFor(t.SellOrder)
+}
@@ -0,0 +1,74 @@
+package engine
+
+import (
+	"container/heap"
+
+	"github.com/google/uuid"
+)
+
+type orderHeap struct {
+	orders []*Order
+	index  map[uuid.UUID]int
+	less   func(a, b *Order) bool
+}
+
+func newOrderHeap(less func(a, b *Order) bool) *orderHeap {
+	h := &orderHeap{
+		orders: make([]*Order, 0),
+		index:  make(map[uuid.UUID]int),
+		less:   less,
+	}
+	heap.Init(h)
+	return h
+}
+
+func (h *orderHeap) Len() int { return len(h.orders) }
+
+func (h *orderHeap) Less(i, j int) bool {
+	return h.less(h.orders[i], h.orders[j])
+}
+
+func (h *orderHeap) Swap(i, j int) {
+	h.orders[i], h.orders[j] = h.orders[j], h.orders[i]
+	h.index[h.orders[i].ID] = i
+	h.index[h.orders[j].ID] = j
+}
+
+func (h *orderHeap) Push(x interface{}) {
+	order := x.(*Order)
+	h.index[order.ID] = len(h.orders)
+	h.orders = append(h.orders, order)
+}
+
+func (h *orderHeap) Pop() interface{} {
+	old := h.orders
+	n := len(old)
+	order := old[n-1]
+	old[n-1] = nil
+	h.orders = old[0 : n-1]
+	delete(h.index, order.ID)
+	return order
+}
+
+func (h *orderHeap) peek() *Order {
+	if len(h.orders) == 0 {
+		return nil
+	}
+	return h.orders[0]
+}
+
+func (h *orderHeap) get(id uuid.UUID) *Order {
+	i, exists := h.index[id]
+	if !exists {
+		return nil
+	}
+	return h.orders[i]
+}
+
+func (h *orderHeap) remove(id uuid.UUID) *Order {
+	i, exists := h.index[id]
+	if !exists {
+		return nil
+	}
+	return heap.Remove(h, i).(*Order)
+}
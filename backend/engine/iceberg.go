@@ -0,0 +1,25 @@
+package engine
+
+func NewIcebergOrder(symbol string, side Side, price Price, qty, displayQty int, userID string) *Order {
+	order := NewOrder(symbol, side, price, qty, userID)
+	order.DisplayQty = displayQty
+	return order
+}
+
+func (o *Order) isIceberg() bool {
+	return o.DisplayQty > 0
+}
+
+func (o *Order) displayedQty() int {
+	if !o.isIceberg() {
+		return o.Qty
+	}
+	return o.VisibleQty
+}
+
+// reloadPeak refreshes the displayed slice from the hidden reserve. The new
+// peak joins the back of the time queue at its price.
+func (o *Order) reloadPeak(now int64) {
+	o.VisibleQty = min(o.DisplayQty, o.Qty)
+	o.Timestamp = now
+}
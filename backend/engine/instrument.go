@@ -0,0 +1,149 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+type InstrumentStatus int
+
+const (
+	ACTIVE InstrumentStatus = iota
+	SUSPENDED
+)
+
+func (s InstrumentStatus) String() string {
+	if s == SUSPENDED {
+		return "SUSPENDED"
+	}
+	return "ACTIVE"
+}
+
+func ParseInstrumentStatus(s string) (InstrumentStatus, error) {
+	switch strings.ToUpper(s) {
+	case "", "ACTIVE":
+		return ACTIVE, nil
+	case "SUSPENDED":
+		return SUSPENDED, nil
+	default:
+		return ACTIVE, fmt.Errorf("invalid instrument status %q", s)
+	}
+}
+
+func (s InstrumentStatus) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *InstrumentStatus) UnmarshalText(data []byte) error {
+	status, err := ParseInstrumentStatus(string(data))
+	if err != nil {
+		return err
+	}
+	*s = status
+	return nil
+}
+
+type Instrument struct {
+	Symbol         string           `json:"symbol"`
+	ReferencePrice Price            `json:"reference_price"`
+	TickSize       Price            `json:"tick_size"`
+	LotSize        int              `json:"lot_size"`
+	PricePrecision int              `json:"price_precision"`
+	Currency       string           `json:"currency"`
+	Status         InstrumentStatus `json:"status"`
+	Matching       MatchingRule     `json:"matching"`
+}
+
+func DefaultInstrument(symbol string) Instrument {
+	return Instrument{
+		Symbol:         symbol,
+		ReferencePrice: PriceFromFloat(1000.0),
+		TickSize:       PriceFromFloat(0.05),
+		LotSize:        1,
+		PricePrecision: 2,
+		Currency:       "INR",
+		Status:         ACTIVE,
+	}
+}
+
+func (i Instrument) Validate() error {
+	if i.Symbol == "" {
+		return fmt.Errorf("symbol is required")
+	}
+	if i.TickSize <= 0 {
+		return fmt.Errorf("tick size must be positive")
+	}
+	if i.LotSize <= 0 {
+		return fmt.Errorf("lot size must be positive")
+	}
+	if i.PricePrecision < 0 || i.PricePrecision > priceDecimals {
+		return fmt.Errorf("price precision must be between 0 and %d", priceDecimals)
+	}
+	if i.ReferencePrice < 0 || i.ReferencePrice%i.TickSize != 0 {
+		return fmt.Errorf("reference price %s is not on the tick grid", i.ReferencePrice)
+	}
+	if err := i.Matching.Validate(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (i Instrument) ValidatePrice(price Price) error {
+	if price <= 0 {
+		return fmt.Errorf("price must be positive")
+	}
+	if price%i.TickSize != 0 {
+		return fmt.Errorf("price %s is not a multiple of tick size %s", price, i.TickSize)
+	}
+	return nil
+}
+
+func (i Instrument) ValidateQty(qty int) error {
+	if qty <= 0 {
+		return fmt.Errorf("qty must be positive")
+	}
+	if qty%i.LotSize != 0 {
+		return fmt.Errorf("qty %d is not a multiple of lot size %d", qty, i.LotSize)
+	}
+	return nil
+}
+
+func (i Instrument) ValidateOrder(order *Order) error {
+	if err := i.ValidateQty(order.Qty); err != nil {
+		return err
+	}
+	if order.DisplayQty > 0 {
+		if err := i.ValidateQty(order.DisplayQty); err != nil {
+			return fmt.Errorf("display %w", err)
+		}
+	}
+	if order.Type == LIMIT || order.Type == STOP_LIMIT {
+		if err := i.ValidatePrice(order.Price); err != nil {
+			return err
+		}
+	}
+	if order.isStop() {
+		if err := i.ValidatePrice(order.StopPrice); err != nil {
+			return fmt.Errorf("stop %w", err)
+		}
+	}
+	if order.TimeInForce == GTD {
+		if _, err := time.Parse(time.DateOnly, order.GoodTillDate); err != nil {
+			return fmt.Errorf("good till date must be YYYY-MM-DD")
+		}
+	}
+	return nil
+}
+
+func (i Instrument) RoundToTick(price Price) Price {
+	rounded := (price + i.TickSize/2) / i.TickSize * i.TickSize
+	if rounded < i.TickSize {
+		return i.TickSize
+	}
+	return rounded
+}
+
+func (i Instrument) FormatPrice(price Price) string {
+	return price.Format(i.PricePrecision)
+}
@@ -0,0 +1,198 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+var ErrJournalUnavailable = errors.New("journal unavailable")
+
+type RecordType string
+
+const (
+	// Commands, replayed on recovery.
+	RecordNewOrder RecordType = "new_order"
+	RecordCancel   RecordType = "cancel"
+	RecordAmend    RecordType = "amend"
+	RecordDeposit  RecordType = "deposit"
+	RecordWithdraw RecordType = "withdraw"
+	RecordTrading  RecordType = "trading_state"
+
+	// Sequenced engine events and command results, written for audit and
+	// so that recovery can restore the event log with its original Seqs.
+	RecordEvent        RecordType = "event"
+	RecordCancelResult RecordType = "cancel_result"
+	RecordAmendResult  RecordType = "amend_result"
+)
+
+func (t RecordType) isCommand() bool {
+	switch t {
+	case RecordNewOrder, RecordCancel, RecordAmend, RecordDeposit, RecordWithdraw, RecordTrading:
+		return true
+	}
+	return false
+}
+
+type JournalRecord struct {
+	Seq       uint64       `json:"seq"`
+	Time      int64        `json:"time"`
+	Type      RecordType   `json:"type"`
+	Order     *Order       `json:"order,omitempty"`
+	OrderID   uuid.UUID    `json:"order_id,omitzero"`
+	Account   string       `json:"account,omitempty"`
+	Symbol    string       `json:"symbol,omitempty"`
+	Price     Price        `json:"price,omitzero"`
+	Qty       int          `json:"qty,omitzero"`
+	State     TradingState `json:"state,omitzero"`
+	Until     int64        `json:"until,omitzero"`
+	Scheduled bool         `json:"scheduled,omitzero"`
+	Session   string       `json:"session,omitempty"`
+	Reason    string       `json:"reason,omitempty"`
+	Event     *Event       `json:"event,omitempty"`
+	Result    string       `json:"result,omitempty"`
+}
+
+// Journal persists records before the engine acts on them. Append must have
+// serialized the record by the time it returns.
+type Journal interface {
+	Append(rec *JournalRecord) error
+	LastSeq() uint64
+}
+
+type JournalReader interface {
+	ReplayFrom(after uint64, fn func(rec JournalRecord) error) error
+}
+
+func (me *MatchingEngine) SetJournal(j Journal) {
+	me.journal = j
+}
+
+func (me *MatchingEngine) journalCommand(cmd command) error {
+	if me.journal == nil || me.replaying {
+		return nil
+	}
+
+	rec := &JournalRecord{Time: cmd.at, OrderID: cmd.orderID}
+	switch cmd.kind {
+	case newOrderCommand:
+		rec.Type = RecordNewOrder
+		rec.Order = cmd.order
+		rec.OrderID = uuid.Nil
+	case cancelCommand:
+		rec.Type = RecordCancel
+	case amendCommand:
+		rec.Type = RecordAmend
+		rec.Price = cmd.price
+		rec.Qty = cmd.qty
+	case stateCommand:
+		rec.Type = RecordTrading
+		rec.OrderID = uuid.Nil
+		rec.Symbol = cmd.symbol
+		rec.State = cmd.state
+		rec.Until = cmd.until
+		rec.Scheduled = cmd.scheduled
+		rec.Session = cmd.session
+		rec.Reason = cmd.reason
+	default:
+		return nil
+	}
+	return me.journal.Append(rec)
+}
+
+func (me *MatchingEngine) journalEvent(rec *JournalRecord) {
+	if me.journal == nil || me.replaying {
+		return
+	}
+	rec.Time = me.now()
+	if err := me.journal.Append(rec); err != nil {
+		me.logger.Error("Journal write failed", "type", rec.Type, "error", err)
+	}
+}
+
+// Recover rebuilds every book by re-applying the journaled commands in
+// order. It must run before Start. Events regenerated during replay are not
+// published; the journaled originals are loaded into the event log instead,
+// so Seqs continue from where the previous run stopped.
+func (me *MatchingEngine) Recover(r JournalReader) (int, error) {
+	return me.RecoverFrom(r, 0)
+}
+
+// RecoverFrom replays only the commands journaled after seq, typically the
+// Seq of a restored snapshot.
+func (me *MatchingEngine) RecoverFrom(r JournalReader, after uint64) (int, error) {
+	me.replaying = true
+	defer func() { me.replaying = false }()
+
+	applied := 0
+	err := r.ReplayFrom(after, func(rec JournalRecord) error {
+		if rec.Seq <= after {
+			return nil
+		}
+		if rec.Type == RecordEvent && rec.Event != nil {
+			me.restoreEvent(*rec.Event)
+			return nil
+		}
+		ok, err := me.Apply(rec)
+		if ok {
+			applied++
+		}
+		return err
+	})
+	return applied, err
+}
+
+// Apply runs one journaled command synchronously on the calling goroutine
+// and reports whether it was a command. Events are ignored. The engine must
+// not be started; replay tools use this to get a single, reproducible
+// interleaving across shards.
+func (me *MatchingEngine) Apply(rec JournalRecord) (bool, error) {
+	if !rec.Type.isCommand() {
+		return false, nil
+	}
+
+	var s *shard
+	cmd := command{at: rec.Time, orderID: rec.OrderID, price: rec.Price, qty: rec.Qty}
+	if cmd.at == 0 {
+		cmd.at = me.now()
+	}
+	switch rec.Type {
+	case RecordNewOrder:
+		if rec.Order == nil {
+			return false, fmt.Errorf("record %d: new_order without order", rec.Seq)
+		}
+		cmd.kind = newOrderCommand
+		cmd.order = rec.Order
+		s = me.shardFor(rec.Order.Symbol)
+		me.indexMu.Lock()
+		me.orderIndex[rec.Order.ID] = s
+		me.indexMu.Unlock()
+	case RecordCancel:
+		cmd.kind = cancelCommand
+		s = me.shardOfOrder(rec.OrderID)
+	case RecordAmend:
+		cmd.kind = amendCommand
+		s = me.shardOfOrder(rec.OrderID)
+	case RecordTrading:
+		cmd.kind = stateCommand
+		cmd.symbol = rec.Symbol
+		cmd.state = rec.State
+		cmd.until = rec.Until
+		cmd.scheduled = rec.Scheduled
+		cmd.session = rec.Session
+		cmd.reason = rec.Reason
+		s = me.shardFor(rec.Symbol)
+	case RecordDeposit, RecordWithdraw:
+		me.accounts.mu.Lock()
+		me.accounts.apply(&rec)
+		me.accounts.mu.Unlock()
+		return true, nil
+	}
+	if s == nil {
+		return true, nil
+	}
+
+	me.apply(s, cmd)
+	return true, nil
+}
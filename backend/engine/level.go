@@ -0,0 +1,167 @@
+package engine
+
+import (
+	"container/list"
+	"iter"
+	"sort"
+
+	"github.com/google/uuid"
+)
+
+type priceLevel struct {
+	price  Price
+	orders *list.List
+}
+
+type levelEntry struct {
+	level *priceLevel
+	elem  *list.Element
+}
+
+// bookSide keeps one side of the book as price levels sorted worst to best,
+// so the touch is always the last level. Each level is a FIFO queue.
+type bookSide struct {
+	levels  []*priceLevel
+	byPrice map[Price]*priceLevel
+	index   map[uuid.UUID]levelEntry
+	dirty   map[Price]struct{}
+	better  func(a, b Price) bool
+}
+
+func newBidSide() *bookSide {
+	return newBookSide(func(a, b Price) bool { return a > b })
+}
+
+func newAskSide() *bookSide {
+	return newBookSide(func(a, b Price) bool { return a < b })
+}
+
+func newBookSide(better func(a, b Price) bool) *bookSide {
+	return &bookSide{
+		levels:  make([]*priceLevel, 0),
+		byPrice: make(map[Price]*priceLevel),
+		index:   make(map[uuid.UUID]levelEntry),
+		dirty:   make(map[Price]struct{}),
+		better:  better,
+	}
+}
+
+func (bs *bookSide) Len() int { return len(bs.index) }
+
+func (bs *bookSide) Push(order *Order) {
+	level, exists := bs.byPrice[order.Price]
+	if !exists {
+		level = &priceLevel{price: order.Price, orders: list.New()}
+		i := sort.Search(len(bs.levels), func(i int) bool {
+			return bs.better(bs.levels[i].price, level.price)
+		})
+		bs.levels = append(bs.levels, nil)
+		copy(bs.levels[i+1:], bs.levels[i:])
+		bs.levels[i] = level
+		bs.byPrice[order.Price] = level
+	}
+	bs.index[order.ID] = levelEntry{level: level, elem: level.orders.PushBack(order)}
+	bs.touch(order.Price)
+}
+
+func (bs *bookSide) Peek() *Order {
+	if len(bs.levels) == 0 {
+		return nil
+	}
+	return bs.levels[len(bs.levels)-1].orders.Front().Value.(*Order)
+}
+
+func (bs *bookSide) bestPrice() *Price {
+	if len(bs.levels) == 0 {
+		return nil
+	}
+	price := bs.levels[len(bs.levels)-1].price
+	return &price
+}
+
+func (bs *bookSide) Pop() *Order {
+	order := bs.Peek()
+	if order == nil {
+		return nil
+	}
+	return bs.Remove(order.ID)
+}
+
+func (bs *bookSide) Get(id uuid.UUID) *Order {
+	entry, exists := bs.index[id]
+	if !exists {
+		return nil
+	}
+	return entry.elem.Value.(*Order)
+}
+
+func (bs *bookSide) Remove(id uuid.UUID) *Order {
+	entry, exists := bs.index[id]
+	if !exists {
+		return nil
+	}
+	delete(bs.index, id)
+	order := entry.level.orders.Remove(entry.elem).(*Order)
+	bs.touch(order.Price)
+
+	if entry.level.orders.Len() == 0 {
+		bs.removeLevel(entry.level)
+	}
+	return order
+}
+
+func (bs *bookSide) removeLevel(level *priceLevel) {
+	delete(bs.byPrice, level.price)
+
+	last := len(bs.levels) - 1
+	if bs.levels[last] == level {
+		bs.levels[last] = nil
+		bs.levels = bs.levels[:last]
+		return
+	}
+
+	i := sort.Search(len(bs.levels), func(i int) bool {
+		return !bs.better(level.price, bs.levels[i].price)
+	})
+	copy(bs.levels[i:], bs.levels[i+1:])
+	bs.levels[last] = nil
+	bs.levels = bs.levels[:last]
+}
+
+// each visits orders best level first, FIFO within a level, until fn
+// returns false.
+func (bs *bookSide) each(fn func(order *Order) bool) {
+	for i := len(bs.levels) - 1; i >= 0; i-- {
+		for e := bs.levels[i].orders.Front(); e != nil; e = e.Next() {
+			if !fn(e.Value.(*Order)) {
+				return
+			}
+		}
+	}
+}
+
+// best yields the orders at the best price in time priority.
+func (bs *bookSide) best() iter.Seq[*Order] {
+	return func(yield func(*Order) bool) {
+		if len(bs.levels) == 0 {
+			return
+		}
+		for e := bs.levels[len(bs.levels)-1].orders.Front(); e != nil; e = e.Next() {
+			if !yield(e.Value.(*Order)) {
+				return
+			}
+		}
+	}
+}
+
+func (bs *bookSide) depth(levels int) []PriceLevel {
+	depth := make([]PriceLevel, 0, max(levels, 0))
+	for i := len(bs.levels) - 1; i >= 0 && len(depth) < levels; i-- {
+		qty := 0
+		for e := bs.levels[i].orders.Front(); e != nil; e = e.Next() {
+			qty += e.Value.(*Order).displayedQty()
+		}
+		depth = append(depth, PriceLevel{Price: bs.levels[i].price, Qty: qty})
+	}
+	return depth
+}
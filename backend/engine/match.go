@@ -1,35 +1,89 @@
 package engine
 
 import (
-	"container/heap"
+	"fmt"
+	"strings"
 	"sync"
-	"time"
+	"sync/atomic"
 
 	"github.com/AkshatMadhani/nanopulse/logger"
+	"github.com/google/uuid"
 )
 
 type MatchingEngine struct {
-	books       map[string]*OrderBook
-	orderChan   chan *Order
-	tradeChan   chan *Trade
-	metricsChan chan Metric
-	mu          sync.RWMutex
-	logger      *logger.Logger
+	shards       []*shard
+	orderChan    chan *Order
+	tradeChan    chan *Trade
+	metricsChan  chan Metric
+	eventChan    chan Event
+	events       *eventLog
+	eventSeq     uint64
+	eventMu      sync.Mutex
+	orderIndex   map[uuid.UUID]*shard
+	retainOrders int
+	indexMu      sync.RWMutex
+	logger       *logger.Logger
+	registry     *Registry
+	journal      Journal
+	clock        Clock
+	ids          IDGenerator
+	replaying    bool
+	quit         chan struct{}
+	routerDone   chan struct{}
+	shardQuit    chan struct{}
+	stopped      chan struct{}
+	wg           sync.WaitGroup
+	stopOnce     sync.Once
+	started      atomic.Bool
+	captureMu    sync.Mutex
+	stpModes     map[string]SelfTradeMode
+	stpMu        sync.RWMutex
+	accounts     *ledger
+	fees         feeTable
+	breakers     breakerTable
+	session      session
 }
 
 type Metric struct {
 	Type      string
 	Value     float64
+	Shard     int
 	Timestamp int64
 }
 
 func NewMatchingEngine(orderBufferSize int, log *logger.Logger) *MatchingEngine {
+	return NewShardedMatchingEngine(orderBufferSize, DefaultShardCount, log)
+}
+
+func NewShardedMatchingEngine(orderBufferSize, shardCount int, log *logger.Logger) *MatchingEngine {
+	if shardCount <= 0 {
+		shardCount = DefaultShardCount
+	}
+
+	shards := make([]*shard, shardCount)
+	for i := range shards {
+		shards[i] = newShard(i, orderBufferSize)
+	}
+
 	return &MatchingEngine{
-		books:       make(map[string]*OrderBook),
-		orderChan:   make(chan *Order, orderBufferSize),
-		tradeChan:   make(chan *Trade, 1000),
-		metricsChan: make(chan Metric, 1000),
-		logger:      log,
+		shards:       shards,
+		orderChan:    make(chan *Order, orderBufferSize),
+		tradeChan:    make(chan *Trade, 1000),
+		metricsChan:  make(chan Metric, 1000),
+		eventChan:    make(chan Event, 10000),
+		events:       newEventLog(DefaultEventLogSize),
+		orderIndex:   make(map[uuid.UUID]*shard),
+		retainOrders: DefaultRetainedOrders,
+		stpModes:     make(map[string]SelfTradeMode),
+		accounts:     newLedger(),
+		logger:       log,
+		registry:     NewRegistry(),
+		clock:        SystemClock,
+		ids:          RandomIDs,
+		quit:         make(chan struct{}),
+		routerDone:   make(chan struct{}),
+		shardQuit:    make(chan struct{}),
+		stopped:      make(chan struct{}),
 	}
 }
 
@@ -46,47 +100,193 @@ func (me *MatchingEngine) GetMetricsChan() <-chan Metric {
 }
 
 func (me *MatchingEngine) GetOrCreateBook(symbol string) *OrderBook {
-	me.mu.Lock()
-	defer me.mu.Unlock()
+	s := me.shardFor(symbol)
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	if book, exists := me.books[symbol]; exists {
+	if book, exists := s.books[symbol]; exists {
 		return book
 	}
 
-	book := NewOrderBook(symbol)
-	me.books[symbol] = book
-	me.logger.Info("Created order book", "symbol", symbol)
+	inst, exists := me.registry.Get(symbol)
+	if !exists {
+		inst = DefaultInstrument(symbol)
+	}
+	book := NewOrderBookFor(inst)
+	s.books[symbol] = book
+	me.logger.Info("Created order book", "symbol", symbol, "shard", s.id)
 	return book
 }
 
 func (me *MatchingEngine) GetBook(symbol string) *OrderBook {
-	me.mu.RLock()
-	defer me.mu.RUnlock()
-	return me.books[symbol]
+	s := me.shardFor(symbol)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.books[symbol]
+}
+
+func (me *MatchingEngine) Instruments() *Registry {
+	return me.registry
+}
+
+// SetInstrument adds or replaces an instrument. Its tick and lot cannot
+// change while its book holds orders, which were checked against the old
+// ones.
+func (me *MatchingEngine) SetInstrument(inst Instrument) error {
+	if book := me.GetBook(inst.Symbol); book != nil {
+		book.mu.Lock()
+		defer book.mu.Unlock()
+		old := book.Instrument
+		resting := book.bids.Len() + book.asks.Len() + len(book.stops) + len(book.triggered)
+		if resting > 0 && (inst.TickSize != old.TickSize || inst.LotSize != old.LotSize) {
+			return fmt.Errorf("cannot change tick or lot size of %s with %d orders resting", inst.Symbol, resting)
+		}
+		if err := me.registry.Set(inst); err != nil {
+			return err
+		}
+		inst, _ = me.registry.Get(inst.Symbol)
+		book.Instrument = inst
+		book.policy = inst.Matching.Policy()
+		return nil
+	}
+	if err := me.registry.Set(inst); err != nil {
+		return err
+	}
+	me.refreshBookInstrument(inst.Symbol)
+	return nil
+}
+
+func (me *MatchingEngine) SetInstrumentStatus(symbol string, status InstrumentStatus) error {
+	if err := me.registry.SetStatus(symbol, status); err != nil {
+		return err
+	}
+	me.refreshBookInstrument(symbol)
+	return nil
+}
+
+func (me *MatchingEngine) GetInstrument(symbol string) (Instrument, bool) {
+	return me.registry.Get(symbol)
+}
+
+func (me *MatchingEngine) refreshBookInstrument(symbol string) {
+	inst, exists := me.registry.Get(symbol)
+	book := me.GetBook(symbol)
+	if !exists || book == nil {
+		return
+	}
+
+	book.mu.Lock()
+	book.Instrument = inst
+	book.policy = inst.Matching.Policy()
+	book.mu.Unlock()
 }
 
 func (me *MatchingEngine) Start() {
-	me.logger.Info("Starting matching engine")
-	go me.processOrders()
+	me.logger.Info("Starting matching engine", "shards", len(me.shards))
+	me.started.Store(true)
+	me.wg.Add(len(me.shards))
+	for _, s := range me.shards {
+		go me.runShard(s)
+	}
+	go me.route()
+	me.resumeTimedHalts()
+	me.CheckSchedule()
+	if me.scheduled() {
+		go me.runSchedule()
+	}
 }
 
-func (me *MatchingEngine) processOrders() {
-	for order := range me.orderChan {
-		startTime := time.Now()
+func (me *MatchingEngine) processOrder(order *Order, at int64) {
+	inst, exists := me.registry.Get(order.Symbol)
+	if !exists {
+		me.rejectOrder(order, "unknown symbol")
+		return
+	}
+	if inst.Status != ACTIVE {
+		me.rejectOrder(order, "instrument "+inst.Status.String())
+		return
+	}
+	if err := inst.ValidateOrder(order); err != nil {
+		me.rejectOrder(order, err.Error())
+		return
+	}
 
-		me.matchOrder(order)
+	book := me.GetOrCreateBook(order.Symbol)
+	book.at = at
+	switch state := book.TradingStatus().State; state {
+	case CONTINUOUS:
+	case AUCTION:
+		if !order.canRest() && !order.isStop() {
+			me.rejectOrder(order, "not accepted during auction")
+			return
+		}
+	default:
+		me.rejectOrder(order, "trading "+strings.ToLower(state.String()))
+		return
+	}
+	me.emitOrder(book, EVENT_ACCEPTED, order, "")
+	me.runOrder(book, order)
+}
 
-		latency := time.Since(startTime).Microseconds()
-		me.metricsChan <- Metric{
-			Type:      "latency",
-			Value:     float64(latency),
-			Timestamp: time.Now().UnixNano(),
+// runOrder holds, matches or rests an order that has already been accepted.
+func (me *MatchingEngine) runOrder(book *OrderBook, order *Order) {
+	if order.isStop() {
+		if book.holdStop(order) {
+			me.logger.Debug("Stop order held",
+				"order_id", order.ID,
+				"symbol", order.Symbol,
+				"stop_price", order.StopPrice,
+			)
+			return
 		}
+		order.activate(me.now())
 	}
+
+	me.matchOrder(book, order)
+	me.releaseStops(book)
 }
 
-func (me *MatchingEngine) matchOrder(order *Order) {
-	book := me.GetOrCreateBook(order.Symbol)
+func (me *MatchingEngine) rejectOrder(order *Order, reason string) {
+	me.logger.Warn("Order rejected",
+		"order_id", order.ID,
+		"symbol", order.Symbol,
+		"reason", reason,
+	)
+	order.Status = REJECTED
+	me.emitOrder(me.GetBook(order.Symbol), EVENT_REJECTED, order, reason)
+}
+
+func (me *MatchingEngine) publishTrade(book *OrderBook, trade *Trade) {
+	me.emit(book, Event{Type: EVENT_TRADE, Symbol: trade.Symbol, Trade: trade})
+}
+
+func (me *MatchingEngine) matchOrder(book *OrderBook, order *Order) {
+	switch book.TradingStatus().State {
+	case CONTINUOUS:
+	case AUCTION:
+		me.callOrder(book, order)
+		return
+	default:
+		me.haltOrder(book, order)
+		return
+	}
+	if order.PostOnly != POST_ONLY_NONE && !me.applyPostOnly(book, order) {
+		return
+	}
+
+	if order.TimeInForce == FOK {
+		if reason := me.killFOK(book, order); reason != "" {
+			me.logger.Info("FOK order killed",
+				"order_id", order.ID,
+				"symbol", order.Symbol,
+				"qty", order.Qty,
+				"reason", reason,
+			)
+			order.Status = EXPIRED
+			me.emitOrder(book, EVENT_EXPIRED, order, reason)
+			return
+		}
+	}
 
 	if order.Side == BUY {
 		me.matchBuyOrder(book, order)
@@ -95,45 +295,93 @@ func (me *MatchingEngine) matchOrder(order *Order) {
 	}
 }
 
-func (me *MatchingEngine) matchBuyOrder(book *OrderBook, buyOrder *Order) {
-	book.mu.Lock()
-	defer book.mu.Unlock()
+// killFOK returns why a FOK order cannot fill in full, or "" if it can. An
+// order the circuit breaker would stop part way is killed without halting
+// the book, since nothing trades.
+func (me *MatchingEngine) killFOK(book *OrderBook, order *Order) string {
+	fills, levels := book.fillsInFull(order)
+	if !fills {
+		return "fok not fillable"
+	}
 
-	for buyOrder.Qty > 0 && book.SellHeap.Len() > 0 {
-		bestSell := book.SellHeap.Peek()
+	book.mu.RLock()
+	defer book.mu.RUnlock()
+	if !me.admitsAll(book, levels) {
+		return "fok would trip circuit breaker"
+	}
+	return ""
+}
 
-		if buyOrder.Price < bestSell.Price {
-			break
-		}
-		tradeQty := min(buyOrder.Qty, bestSell.Qty)
-		tradePrice := bestSell.Price
-		trade := NewTrade(
-			book.Symbol,
-			buyOrder.ID,
-			bestSell.ID,
-			tradePrice,
-			tradeQty,
-			buyOrder.Side,
+func (me *MatchingEngine) applyPostOnly(book *OrderBook, order *Order) bool {
+	touch := book.GetBestAsk()
+	if order.Side == SELL {
+		touch = book.GetBestBid()
+	}
+	if touch == nil || !order.crosses(*touch) {
+		return true
+	}
+
+	tick := book.GetInstrument().TickSize
+	price := *touch - tick
+	if order.Side == SELL {
+		price = *touch + tick
+	}
+
+	// A buy cannot slide below an ask of one tick, so it is rejected.
+	if order.PostOnly == POST_ONLY_REJECT || price <= 0 {
+		me.logger.Info("Post-only order rejected",
+			"order_id", order.ID,
+			"symbol", order.Symbol,
+			"price", order.Price,
+			"touch", *touch,
 		)
-		me.tradeChan <- trade
+		order.Status = REJECTED
+		me.emitOrder(book, EVENT_REJECTED, order, "post-only would cross")
+		return false
+	}
+	me.logger.Debug("Post-only order repriced",
+		"order_id", order.ID,
+		"symbol", order.Symbol,
+		"from", order.Price,
+		"to", price,
+	)
+	order.Price = price
+	return true
+}
 
-		buyOrder.Qty -= tradeQty
-		bestSell.Qty -= tradeQty
+func (me *MatchingEngine) matchBuyOrder(book *OrderBook, buyOrder *Order) {
+	book.mu.Lock()
+	defer book.mu.Unlock()
 
-		if bestSell.Qty == 0 {
-			heap.Pop(book.SellHeap)
-			me.logger.Debug("Sell order fully filled", "order_id", bestSell.ID)
+	for buyOrder.Qty > 0 && book.asks.Len() > 0 && buyOrder.crosses(book.asks.Peek().Price) {
+		halted, finished := me.fillLevel(book, book.asks, buyOrder)
+		if finished {
+			return
 		}
-
-		me.logger.Info("Trade executed",
-			"symbol", book.Symbol,
-			"price", tradePrice,
-			"qty", tradeQty,
-			"trade_id", trade.ID,
+		if halted {
+			break
+		}
+	}
+	if buyOrder.Qty > 0 && book.trading.State != CONTINUOUS {
+		me.haltOrder(book, buyOrder)
+		return
+	}
+	if buyOrder.Qty > 0 && !buyOrder.canRest() {
+		me.logger.Debug("Unfilled remainder cancelled",
+			"order_id", buyOrder.ID,
+			"type", buyOrder.Type,
+			"time_in_force", buyOrder.TimeInForce,
+			"remaining_qty", buyOrder.Qty,
 		)
+		buyOrder.Status = EXPIRED
+		me.emitOrder(book, EVENT_EXPIRED, buyOrder, "unfilled remainder")
+		return
 	}
 	if buyOrder.Qty > 0 {
-		heap.Push(book.BuyHeap, buyOrder)
+		if buyOrder.isIceberg() {
+			buyOrder.reloadPeak(me.now())
+		}
+		book.bids.Push(buyOrder)
 		me.logger.Debug("Buy order added to book",
 			"order_id", buyOrder.ID,
 			"remaining_qty", buyOrder.Qty,
@@ -143,41 +391,36 @@ func (me *MatchingEngine) matchBuyOrder(book *OrderBook, buyOrder *Order) {
 func (me *MatchingEngine) matchSellOrder(book *OrderBook, sellOrder *Order) {
 	book.mu.Lock()
 	defer book.mu.Unlock()
-	for sellOrder.Qty > 0 && book.BuyHeap.Len() > 0 {
-		bestBuy := book.BuyHeap.Peek()
-		if sellOrder.Price > bestBuy.Price {
-			break
-		}
-		tradeQty := min(sellOrder.Qty, bestBuy.Qty)
-		tradePrice := bestBuy.Price
-
-		trade := NewTrade(
-			book.Symbol,
-			bestBuy.ID,
-			sellOrder.ID,
-			tradePrice,
-			tradeQty,
-			sellOrder.Side,
-		)
-		me.tradeChan <- trade
 
-		sellOrder.Qty -= tradeQty
-		bestBuy.Qty -= tradeQty
-
-		if bestBuy.Qty == 0 {
-			heap.Pop(book.BuyHeap)
-			me.logger.Debug("Buy order fully filled", "order_id", bestBuy.ID)
+	for sellOrder.Qty > 0 && book.bids.Len() > 0 && sellOrder.crosses(book.bids.Peek().Price) {
+		halted, finished := me.fillLevel(book, book.bids, sellOrder)
+		if finished {
+			return
 		}
-
-		me.logger.Info("Trade executed",
-			"symbol", book.Symbol,
-			"price", tradePrice,
-			"qty", tradeQty,
-			"trade_id", trade.ID,
+		if halted {
+			break
+		}
+	}
+	if sellOrder.Qty > 0 && book.trading.State != CONTINUOUS {
+		me.haltOrder(book, sellOrder)
+		return
+	}
+	if sellOrder.Qty > 0 && !sellOrder.canRest() {
+		me.logger.Debug("Unfilled remainder cancelled",
+			"order_id", sellOrder.ID,
+			"type", sellOrder.Type,
+			"time_in_force", sellOrder.TimeInForce,
+			"remaining_qty", sellOrder.Qty,
 		)
+		sellOrder.Status = EXPIRED
+		me.emitOrder(book, EVENT_EXPIRED, sellOrder, "unfilled remainder")
+		return
 	}
 	if sellOrder.Qty > 0 {
-		heap.Push(book.SellHeap, sellOrder)
+		if sellOrder.isIceberg() {
+			sellOrder.reloadPeak(me.now())
+		}
+		book.asks.Push(sellOrder)
 		me.logger.Debug("Sell order added to book",
 			"order_id", sellOrder.ID,
 			"remaining_qty", sellOrder.Qty,
@@ -186,7 +429,11 @@ func (me *MatchingEngine) matchSellOrder(book *OrderBook, sellOrder *Order) {
 }
 
 func (me *MatchingEngine) GetQueueDepth() int {
-	return len(me.orderChan)
+	depth := len(me.orderChan)
+	for _, s := range me.shards {
+		depth += len(s.cmdChan)
+	}
+	return depth
 }
 func min(a, b int) int {
 	if a < b {
@@ -194,3 +441,77 @@ func min(a, b int) int {
 	}
 	return b
 }
+
+// fillLevel fills aggressor against the best price on resting as the
+// book's matching policy allocates it. A self-trade ends the pass, so the
+// level is allocated again with what is left. It reports whether the
+// circuit breaker halted the book and whether self-trade prevention
+// finished the aggressor. It must be called with book.mu held.
+func (me *MatchingEngine) fillLevel(book *OrderBook, resting *bookSide, aggressor *Order) (halted, finished bool) {
+	allocs := book.policy.Allocate(resting.best(), aggressor.Qty, book.Instrument.LotSize)
+	// Every allocation trades at the level's price, so the circuit breaker
+	// admits the level once, before its first trade, and the policy's split
+	// is applied whole or not at all.
+	admitted := false
+	for _, alloc := range allocs {
+		order, tradeQty := alloc.Order, alloc.Qty
+		if aggressor.selfTrades(order) {
+			return false, me.preventSelfTrade(book, resting, aggressor, order)
+		}
+		tradePrice := order.Price
+		if !admitted {
+			if !me.admitTrade(book, tradePrice) {
+				return true, false
+			}
+			admitted = true
+		}
+
+		buyOrder, sellOrder := aggressor, order
+		if aggressor.Side == SELL {
+			buyOrder, sellOrder = order, aggressor
+		}
+		trade := me.newTrade(
+			book.Symbol,
+			buyOrder,
+			sellOrder,
+			tradePrice,
+			tradeQty,
+			aggressor.Side,
+		)
+		me.publishTrade(book, trade)
+		book.recordTrade(tradePrice)
+
+		aggressor.Qty -= tradeQty
+		order.Qty -= tradeQty
+		if order.isIceberg() {
+			order.VisibleQty -= tradeQty
+		}
+		resting.touch(tradePrice)
+		aggressor.fill(tradeQty, tradePrice)
+		order.fill(tradeQty, tradePrice)
+		me.emitFill(book, aggressor, trade)
+		me.emitFill(book, order, trade)
+
+		if order.Qty == 0 {
+			resting.Remove(order.ID)
+			me.logger.Debug("Resting order fully filled", "order_id", order.ID, "side", order.Side)
+		} else if order.isIceberg() && order.VisibleQty == 0 {
+			resting.Remove(order.ID)
+			order.reloadPeak(me.now())
+			resting.Push(order)
+			me.logger.Debug("Iceberg peak reloaded",
+				"order_id", order.ID,
+				"visible_qty", order.VisibleQty,
+				"remaining_qty", order.Qty,
+			)
+		}
+
+		me.logger.Info("Trade executed",
+			"symbol", book.Symbol,
+			"price", tradePrice,
+			"qty", tradeQty,
+			"trade_id", trade.ID,
+		)
+	}
+	return false, false
+}
@@ -1,8 +1,6 @@
 package engine
 
 import (
-	"time"
-
 	"github.com/google/uuid"
 )
 
@@ -20,26 +18,171 @@ func (s Side) String() string {
 	return "SELL"
 }
 
+type OrderType int
+
+const (
+	LIMIT OrderType = iota
+	MARKET
+	STOP
+	STOP_LIMIT
+)
+
+func (t OrderType) String() string {
+	switch t {
+	case MARKET:
+		return "MARKET"
+	case STOP:
+		return "STOP"
+	case STOP_LIMIT:
+		return "STOP_LIMIT"
+	default:
+		return "LIMIT"
+	}
+}
+
+type TimeInForce int
+
+const (
+	GTC TimeInForce = iota
+	IOC
+	FOK
+	DAY
+	GTD
+)
+
+func (tif TimeInForce) String() string {
+	switch tif {
+	case IOC:
+		return "IOC"
+	case FOK:
+		return "FOK"
+	case DAY:
+		return "DAY"
+	case GTD:
+		return "GTD"
+	default:
+		return "GTC"
+	}
+}
+
+type PostOnlyMode int
+
+const (
+	POST_ONLY_NONE PostOnlyMode = iota
+	POST_ONLY_REJECT
+	POST_ONLY_SLIDE
+)
+
+func (m PostOnlyMode) String() string {
+	switch m {
+	case POST_ONLY_REJECT:
+		return "REJECT"
+	case POST_ONLY_SLIDE:
+		return "SLIDE"
+	default:
+		return "NONE"
+	}
+}
+
 type Order struct {
-	ID        uuid.UUID `json:"id"`
-	Symbol    string    `json:"symbol"`
-	Side      Side      `json:"side"`
-	Price     float64   `json:"price"`
-	Qty       int       `json:"qty"`
-	Timestamp int64     `json:"timestamp"`
-	UserID    string    `json:"user_id"`
+	ID           uuid.UUID     `json:"id"`
+	Symbol       string        `json:"symbol"`
+	Side         Side          `json:"side"`
+	Type         OrderType     `json:"type"`
+	TimeInForce  TimeInForce   `json:"time_in_force"`
+	GoodTillDate string        `json:"good_till_date,omitempty"`
+	PostOnly     PostOnlyMode  `json:"post_only"`
+	Price        Price         `json:"price"`
+	StopPrice    Price         `json:"stop_price"`
+	Qty          int           `json:"qty"`
+	DisplayQty   int           `json:"display_qty"`
+	VisibleQty   int           `json:"visible_qty"`
+	Timestamp    int64         `json:"timestamp"`
+	UserID       string        `json:"user_id"`
+	SelfTrade    SelfTradeMode `json:"self_trade,omitzero"`
+	Fees         FeeRates      `json:"fees,omitzero"`
+	Status       OrderStatus   `json:"status"`
+	FilledQty    int           `json:"filled_qty"`
+	AvgPrice     Price         `json:"avg_price"`
+	// Notional is the sum of price*qty over fills, kept so AvgPrice does
+	// not drift.
+	Notional int64 `json:"-"`
+
+	pending *submission
+	retired bool
+}
+
+// NewOrder, NewMarketOrder and NewStopOrder build orders outside any
+// engine, with a random ID and the wall-clock time. Orders for an engine
+// should come from its methods of the same names, which use its Clock and
+// IDGenerator.
+func NewOrder(symbol string, side Side, price Price, qty int, userID string) *Order {
+	return newOrder(SystemClock, RandomIDs, symbol, side, price, qty, userID)
 }
 
-func NewOrder(symbol string, side Side, price float64, qty int, userID string) *Order {
+func NewMarketOrder(symbol string, side Side, qty int, tif TimeInForce, userID string) *Order {
+	return newMarketOrder(SystemClock, RandomIDs, symbol, side, qty, tif, userID)
+}
+
+func NewStopOrder(symbol string, side Side, stopPrice, limitPrice Price, qty int, userID string) *Order {
+	return newStopOrder(SystemClock, RandomIDs, symbol, side, stopPrice, limitPrice, qty, userID)
+}
+
+func newOrder(clock Clock, ids IDGenerator, symbol string, side Side, price Price, qty int, userID string) *Order {
 	return &Order{
-		ID:        uuid.New(),
-		Symbol:    symbol,
-		Side:      side,
-		Price:     price,
-		Qty:       qty,
-		Timestamp: time.Now().UnixNano(),
-		UserID:    userID,
+		ID:          ids.NewID(),
+		Symbol:      symbol,
+		Side:        side,
+		Type:        LIMIT,
+		TimeInForce: GTC,
+		Price:       price,
+		Qty:         qty,
+		Timestamp:   clock.Now().UnixNano(),
+		UserID:      userID,
+	}
+}
+
+func newMarketOrder(clock Clock, ids IDGenerator, symbol string, side Side, qty int, tif TimeInForce, userID string) *Order {
+	order := newOrder(clock, ids, symbol, side, 0, qty, userID)
+	order.Type = MARKET
+	order.TimeInForce = tif
+	return order
+}
+
+func newStopOrder(clock Clock, ids IDGenerator, symbol string, side Side, stopPrice, limitPrice Price, qty int, userID string) *Order {
+	order := newOrder(clock, ids, symbol, side, limitPrice, qty, userID)
+	order.Type = STOP_LIMIT
+	if limitPrice == 0 {
+		order.Type = STOP
 	}
+	order.StopPrice = stopPrice
+	return order
+}
+
+func (o *Order) canRest() bool {
+	return o.Type == LIMIT && (o.TimeInForce == GTC || o.TimeInForce == DAY || o.TimeInForce == GTD)
+}
+
+// expiresBy reports whether the order ends with the session closing on
+// date: every DAY order, and GTD orders good until then or earlier.
+func (o *Order) expiresBy(date string) bool {
+	switch o.TimeInForce {
+	case DAY:
+		return true
+	case GTD:
+		return o.GoodTillDate <= date
+	}
+	return false
+}
+
+func (o *Order) crosses(price Price) bool {
+	if o.Type == MARKET {
+		return true
+	}
+	if o.Side == BUY {
+		return o.Price >= price
+	}
+	return o.Price <= price
 }
 
 type Trade struct {
@@ -47,21 +190,17 @@ type Trade struct {
 	Symbol    string    `json:"symbol"`
 	BuyOrder  uuid.UUID `json:"buy_order"`
 	SellOrder uuid.UUID `json:"sell_order"`
-	Price     float64   `json:"price"`
+	Buyer     string    `json:"buyer"`
+	Seller    string    `json:"seller"`
+	Price     Price     `json:"price"`
 	Qty       int       `json:"qty"`
 	Timestamp int64     `json:"timestamp"`
 	Side      Side      `json:"side"`
-}
-
-func NewTrade(symbol string, buyOrder, sellOrder uuid.UUID, price float64, qty int, side Side) *Trade {
-	return &Trade{
-		ID:        uuid.New(),
-		Symbol:    symbol,
-		BuyOrder:  buyOrder,
-		SellOrder: sellOrder,
-		Price:     price,
-		Qty:       qty,
-		Timestamp: time.Now().UnixNano(),
-		Side:      side,
-	}
+	// Aggressor is the order that took liquidity; the other order paid
+	// MakerFee.
+	Aggressor uuid.UUID `json:"aggressor"`
+	MakerFee  Price     `json:"maker_fee"`
+	TakerFee  Price     `json:"taker_fee"`
+	Seq       uint64    `json:"seq,omitzero"`
+	SymbolSeq uint64    `json:"symbol_seq,omitzero"`
 }
@@ -0,0 +1,240 @@
+package engine
+
+import (
+	"fmt"
+	"iter"
+	"math"
+	"strings"
+)
+
+// MatchingAlgorithm is how the orders resting at a price share an incoming
+// order.
+type MatchingAlgorithm int
+
+const (
+	MATCH_FIFO MatchingAlgorithm = iota
+	MATCH_PRO_RATA
+	MATCH_PRO_RATA_TOP
+	MATCH_SPLIT
+)
+
+func (a MatchingAlgorithm) String() string {
+	switch a {
+	case MATCH_PRO_RATA:
+		return "PRO_RATA"
+	case MATCH_PRO_RATA_TOP:
+		return "PRO_RATA_TOP"
+	case MATCH_SPLIT:
+		return "SPLIT"
+	default:
+		return "FIFO"
+	}
+}
+
+func ParseMatchingAlgorithm(s string) (MatchingAlgorithm, error) {
+	switch strings.ToUpper(s) {
+	case "", "FIFO":
+		return MATCH_FIFO, nil
+	case "PRO_RATA":
+		return MATCH_PRO_RATA, nil
+	case "PRO_RATA_TOP":
+		return MATCH_PRO_RATA_TOP, nil
+	case "SPLIT":
+		return MATCH_SPLIT, nil
+	default:
+		return MATCH_FIFO, fmt.Errorf("invalid matching algorithm %q", s)
+	}
+}
+
+func (a MatchingAlgorithm) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+func (a *MatchingAlgorithm) UnmarshalText(data []byte) error {
+	algorithm, err := ParseMatchingAlgorithm(string(data))
+	if err != nil {
+		return err
+	}
+	*a = algorithm
+	return nil
+}
+
+// MatchingRule picks an instrument's matching policy. MinQty is the
+// smallest pro-rata share an order is given, TopQty caps the top order's
+// priority share (zero for no cap) and FIFOPct is the part of each
+// incoming order SPLIT allocates in time priority. Fields an algorithm does
+// not use are ignored.
+type MatchingRule struct {
+	Algorithm MatchingAlgorithm `json:"algorithm"`
+	MinQty    int               `json:"min_qty,omitzero"`
+	TopQty    int               `json:"top_qty,omitzero"`
+	FIFOPct   float64           `json:"fifo_pct,omitzero"`
+}
+
+func (r MatchingRule) Validate() error {
+	if r.MinQty < 0 || r.TopQty < 0 {
+		return fmt.Errorf("matching quantities must not be negative")
+	}
+	if r.FIFOPct < 0 || r.FIFOPct > 100 {
+		return fmt.Errorf("matching fifo_pct must be between 0 and 100")
+	}
+	return nil
+}
+
+func (r MatchingRule) Policy() MatchingPolicy {
+	switch r.Algorithm {
+	case MATCH_PRO_RATA:
+		return ProRata{MinQty: r.MinQty}
+	case MATCH_PRO_RATA_TOP:
+		return TopOrderProRata{MaxTopQty: r.TopQty, MinQty: r.MinQty}
+	case MATCH_SPLIT:
+		return Split{FIFOPct: r.FIFOPct, MinQty: r.MinQty}
+	default:
+		return FIFO{}
+	}
+}
+
+// Allocation is the part of an incoming order one resting order fills.
+type Allocation struct {
+	Order *Order
+	Qty   int
+}
+
+// MatchingPolicy divides an incoming order's qty among the orders resting
+// at one price, which level yields in time priority. Each order gets at
+// most its displayed quantity, in multiples of lot. The allocations come
+// back in time priority, leave out orders given nothing, and add up to qty
+// or to everything displayed at the price if that is less.
+type MatchingPolicy interface {
+	Allocate(level iter.Seq[*Order], qty, lot int) []Allocation
+}
+
+// FIFO fills the oldest order first.
+type FIFO struct{}
+
+func (FIFO) Allocate(level iter.Seq[*Order], qty, lot int) []Allocation {
+	var allocs []Allocation
+	for order := range level {
+		if qty == 0 {
+			break
+		}
+		share := min(qty, order.displayedQty())
+		allocs = append(allocs, Allocation{Order: order, Qty: share})
+		qty -= share
+	}
+	return allocs
+}
+
+// ProRata gives each order a share in proportion to its displayed
+// quantity, rounded down to the lot. Shares smaller than MinQty are
+// dropped, and what rounding and dropping leave over goes in time
+// priority.
+type ProRata struct {
+	MinQty int
+}
+
+func (p ProRata) Allocate(level iter.Seq[*Order], qty, lot int) []Allocation {
+	orders, caps := collectLevel(level)
+	shares := make([]int, len(orders))
+	p.prorate(caps, shares, qty, lot)
+	return allocations(orders, shares)
+}
+
+// prorate adds qty to shares in proportion to what each order has left
+// under its cap.
+func (p ProRata) prorate(caps, shares []int, qty, lot int) {
+	lot = max(lot, 1)
+	total := 0
+	for i := range caps {
+		total += caps[i] - shares[i]
+	}
+	if qty >= total {
+		fillInOrder(caps, shares, total)
+		return
+	}
+
+	given := 0
+	for i := range caps {
+		share := int(int64(qty)*int64(caps[i]-shares[i])/int64(total)) / lot * lot
+		if share < p.MinQty {
+			continue
+		}
+		shares[i] += share
+		given += share
+	}
+	fillInOrder(caps, shares, qty-given)
+}
+
+// TopOrderProRata first fills the oldest order at the price, the one that
+// set it, up to MaxTopQty, and shares the rest pro-rata.
+type TopOrderProRata struct {
+	MaxTopQty int
+	MinQty    int
+}
+
+func (p TopOrderProRata) Allocate(level iter.Seq[*Order], qty, lot int) []Allocation {
+	orders, caps := collectLevel(level)
+	shares := make([]int, len(orders))
+	if len(orders) > 0 {
+		top := min(caps[0], qty)
+		if p.MaxTopQty > 0 {
+			top = min(top, p.MaxTopQty) / max(lot, 1) * max(lot, 1)
+		}
+		shares[0] = top
+		qty -= top
+	}
+	ProRata{MinQty: p.MinQty}.prorate(caps, shares, qty, lot)
+	return allocations(orders, shares)
+}
+
+// Split allocates FIFOPct of each incoming order, rounded down to the lot,
+// in time priority and the rest pro-rata.
+type Split struct {
+	FIFOPct float64
+	MinQty  int
+}
+
+func (p Split) Allocate(level iter.Seq[*Order], qty, lot int) []Allocation {
+	orders, caps := collectLevel(level)
+	shares := make([]int, len(orders))
+	lot = max(lot, 1)
+	// The epsilon keeps a whole percentage of qty from flooring a lot short.
+	fifo := int(math.Floor(float64(qty)*p.FIFOPct/100+1e-9)) / lot * lot
+	qty -= fifo - fillInOrder(caps, shares, fifo)
+	ProRata{MinQty: p.MinQty}.prorate(caps, shares, qty, lot)
+	return allocations(orders, shares)
+}
+
+func collectLevel(level iter.Seq[*Order]) ([]*Order, []int) {
+	var orders []*Order
+	var caps []int
+	for order := range level {
+		orders = append(orders, order)
+		caps = append(caps, order.displayedQty())
+	}
+	return orders, caps
+}
+
+// fillInOrder adds up to qty to shares in time priority, each up to its
+// cap, and returns what did not fit.
+func fillInOrder(caps, shares []int, qty int) int {
+	for i := range caps {
+		if qty == 0 {
+			break
+		}
+		extra := min(qty, caps[i]-shares[i])
+		shares[i] += extra
+		qty -= extra
+	}
+	return qty
+}
+
+func allocations(orders []*Order, shares []int) []Allocation {
+	allocs := make([]Allocation, 0, len(orders))
+	for i, order := range orders {
+		if shares[i] > 0 {
+			allocs = append(allocs, Allocation{Order: order, Qty: shares[i]})
+		}
+	}
+	return allocs
+}
@@ -0,0 +1,119 @@
+package engine
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Price is a fixed-point amount counted in 1/PriceScale units, so 2500.05
+// is stored as 25000500.
+type Price int64
+
+const (
+	PriceScale    = 10000
+	priceDecimals = 4
+)
+
+func PriceFromFloat(f float64) Price {
+	return Price(math.Round(f * PriceScale))
+}
+
+func ParsePrice(s string) (Price, error) {
+	s = strings.TrimSpace(s)
+	negative := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+
+	whole, frac, _ := strings.Cut(s, ".")
+	if whole == "" && frac == "" {
+		return 0, fmt.Errorf("invalid price %q", s)
+	}
+	if len(frac) > priceDecimals {
+		return 0, fmt.Errorf("price %q has more than %d decimals", s, priceDecimals)
+	}
+	// ParseInt would take a sign in either part, so "5.-3" would parse.
+	if !digitsOnly(whole) || !digitsOnly(frac) {
+		return 0, fmt.Errorf("invalid price %q", s)
+	}
+
+	units := int64(0)
+	if whole != "" {
+		w, err := strconv.ParseInt(whole, 10, 64)
+		if err != nil || w > math.MaxInt64/PriceScale {
+			return 0, fmt.Errorf("price %q out of range", s)
+		}
+		units = w * PriceScale
+	}
+	if frac != "" {
+		f, err := strconv.ParseInt(frac+strings.Repeat("0", priceDecimals-len(frac)), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid price %q", s)
+		}
+		if units > math.MaxInt64-f {
+			return 0, fmt.Errorf("price %q out of range", s)
+		}
+		units += f
+	}
+
+	if negative {
+		units = -units
+	}
+	return Price(units), nil
+}
+
+func digitsOnly(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func (p Price) Float() float64 {
+	return float64(p) / PriceScale
+}
+
+func (p Price) Format(decimals int) string {
+	sign := ""
+	units := int64(p)
+	if units < 0 {
+		sign = "-"
+		units = -units
+	}
+
+	whole := units / PriceScale
+	frac := fmt.Sprintf("%0*d", priceDecimals, units%PriceScale)
+	if decimals < priceDecimals {
+		frac = frac[:decimals]
+	}
+	if frac == "" {
+		return fmt.Sprintf("%s%d", sign, whole)
+	}
+	return fmt.Sprintf("%s%d.%s", sign, whole, frac)
+}
+
+func (p Price) String() string {
+	s := p.Format(priceDecimals)
+	if strings.Contains(s, ".") {
+		s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	}
+	return s
+}
+
+func (p Price) MarshalJSON() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+func (p *Price) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	price, err := ParsePrice(strings.Trim(string(data), `"`))
+	if err != nil {
+		return err
+	}
+	*p = price
+	return nil
+}
@@ -0,0 +1,86 @@
+package engine
+
+import (
+	"fmt"
+	"sync"
+)
+
+type Registry struct {
+	instruments map[string]Instrument
+	symbols     []string
+	mu          sync.RWMutex
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		instruments: make(map[string]Instrument),
+		symbols:     make([]string, 0),
+	}
+}
+
+func (r *Registry) Set(inst Instrument) error {
+	if err := inst.Validate(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.instruments[inst.Symbol]; !exists {
+		r.symbols = append(r.symbols, inst.Symbol)
+	}
+	r.instruments[inst.Symbol] = inst
+	return nil
+}
+
+func (r *Registry) SetStatus(symbol string, status InstrumentStatus) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	inst, exists := r.instruments[symbol]
+	if !exists {
+		return fmt.Errorf("unknown symbol %s", symbol)
+	}
+	inst.Status = status
+	r.instruments[symbol] = inst
+	return nil
+}
+
+func (r *Registry) Get(symbol string) (Instrument, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	inst, exists := r.instruments[symbol]
+	return inst, exists
+}
+
+// List returns instruments in the order they were first registered.
+func (r *Registry) List() []Instrument {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	list := make([]Instrument, 0, len(r.symbols))
+	for _, symbol := range r.symbols {
+		list = append(list, r.instruments[symbol])
+	}
+	return list
+}
+
+func (r *Registry) Active() []Instrument {
+	active := make([]Instrument, 0)
+	for _, inst := range r.List() {
+		if inst.Status == ACTIVE {
+			active = append(active, inst)
+		}
+	}
+	return active
+}
+
+func (r *Registry) Symbols() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	symbols := make([]string, len(r.symbols))
+	copy(symbols, r.symbols)
+	return symbols
+}
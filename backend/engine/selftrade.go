@@ -0,0 +1,168 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// SelfTradeMode decides what happens when an order would match a resting
+// order from the same user. The incoming order's mode applies. STP_DEFAULT
+// takes the user's configured mode, which is STP_OFF unless set.
+type SelfTradeMode int
+
+const (
+	STP_DEFAULT SelfTradeMode = iota
+	STP_OFF
+	STP_CANCEL_NEWEST
+	STP_CANCEL_OLDEST
+	STP_CANCEL_BOTH
+	STP_DECREMENT
+)
+
+func (m SelfTradeMode) String() string {
+	switch m {
+	case STP_OFF:
+		return "OFF"
+	case STP_CANCEL_NEWEST:
+		return "CANCEL_NEWEST"
+	case STP_CANCEL_OLDEST:
+		return "CANCEL_OLDEST"
+	case STP_CANCEL_BOTH:
+		return "CANCEL_BOTH"
+	case STP_DECREMENT:
+		return "DECREMENT"
+	default:
+		return "DEFAULT"
+	}
+}
+
+func ParseSelfTradeMode(s string) (SelfTradeMode, error) {
+	switch strings.ToUpper(s) {
+	case "", "DEFAULT":
+		return STP_DEFAULT, nil
+	case "OFF":
+		return STP_OFF, nil
+	case "CANCEL_NEWEST":
+		return STP_CANCEL_NEWEST, nil
+	case "CANCEL_OLDEST":
+		return STP_CANCEL_OLDEST, nil
+	case "CANCEL_BOTH":
+		return STP_CANCEL_BOTH, nil
+	case "DECREMENT":
+		return STP_DECREMENT, nil
+	default:
+		return STP_DEFAULT, fmt.Errorf("invalid self-trade mode %q", s)
+	}
+}
+
+func (m SelfTradeMode) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+func (m *SelfTradeMode) UnmarshalText(data []byte) error {
+	mode, err := ParseSelfTradeMode(string(data))
+	if err != nil {
+		return err
+	}
+	*m = mode
+	return nil
+}
+
+// SelfTradeMatch describes a match that was prevented instead of traded.
+type SelfTradeMatch struct {
+	Mode      SelfTradeMode `json:"mode"`
+	Aggressor uuid.UUID     `json:"aggressor"`
+	Resting   uuid.UUID     `json:"resting"`
+	Price     Price         `json:"price"`
+	Qty       int           `json:"qty"`
+}
+
+// SetSelfTradeMode sets the mode used by userID's orders that do not pick
+// one themselves.
+func (me *MatchingEngine) SetSelfTradeMode(userID string, mode SelfTradeMode) {
+	me.stpMu.Lock()
+	defer me.stpMu.Unlock()
+
+	if mode == STP_DEFAULT {
+		delete(me.stpModes, userID)
+		return
+	}
+	me.stpModes[userID] = mode
+}
+
+// resolveSelfTrade fixes the order's mode before it is journaled, so replay
+// does not depend on the configuration at the time.
+func (me *MatchingEngine) resolveSelfTrade(order *Order) {
+	if order.SelfTrade != STP_DEFAULT {
+		return
+	}
+
+	me.stpMu.RLock()
+	defer me.stpMu.RUnlock()
+	if mode, ok := me.stpModes[order.UserID]; ok {
+		order.SelfTrade = mode
+	}
+}
+
+func (o *Order) selfTrades(resting *Order) bool {
+	return o.UserID != "" && o.UserID == resting.UserID &&
+		o.SelfTrade != STP_DEFAULT && o.SelfTrade != STP_OFF
+}
+
+// preventSelfTrade applies the aggressor's mode to a match with its own
+// resting order on side. It must be called with book.mu held and reports
+// whether the aggressor is finished.
+func (me *MatchingEngine) preventSelfTrade(book *OrderBook, side *bookSide, aggressor, resting *Order) bool {
+	match := SelfTradeMatch{
+		Mode:      aggressor.SelfTrade,
+		Aggressor: aggressor.ID,
+		Resting:   resting.ID,
+		Price:     resting.Price,
+		Qty:       min(aggressor.Qty, resting.Qty),
+	}
+	me.logger.Info("Self-trade prevented",
+		"user_id", aggressor.UserID,
+		"mode", match.Mode,
+		"aggressor", aggressor.ID,
+		"resting", resting.ID,
+	)
+	me.emit(book, Event{Type: EVENT_SELF_TRADE, Symbol: book.Symbol, OrderID: aggressor.ID, SelfTrade: &match})
+
+	cancelAggressor := false
+	cancelResting := false
+	switch match.Mode {
+	case STP_CANCEL_NEWEST:
+		cancelAggressor = true
+	case STP_CANCEL_OLDEST:
+		cancelResting = true
+	case STP_CANCEL_BOTH:
+		cancelAggressor, cancelResting = true, true
+	case STP_DECREMENT:
+		aggressor.Qty -= match.Qty
+		resting.Qty -= match.Qty
+		cancelAggressor = aggressor.Qty == 0
+		cancelResting = resting.Qty == 0
+		// At least one side reaches zero. A resting order that survives
+		// keeps its place with the reduced quantity.
+		if !cancelResting {
+			side.touch(resting.Price)
+			me.accounts.settle(resting)
+			if resting.isIceberg() {
+				resting.VisibleQty = min(resting.VisibleQty, resting.Qty)
+			}
+		}
+	}
+
+	if cancelResting {
+		side.Remove(resting.ID)
+		resting.Status = CANCELLED
+		me.emitOrder(book, EVENT_CANCELLED, resting, "self-trade prevented")
+	}
+	if cancelAggressor {
+		aggressor.Status = CANCELLED
+		me.emitOrder(book, EVENT_CANCELLED, aggressor, "self-trade prevented")
+	}
+	return cancelAggressor
+}
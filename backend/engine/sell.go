@@ -1,44 +1,51 @@
 package engine
 
-import "container/heap"
+import (
+	"container/heap"
 
-type SellHeap []*Order
+	"github.com/google/uuid"
+)
 
-func (h SellHeap) Len() int { return len(h) }
+type SellHeap struct {
+	h *orderHeap
+}
 
-func (h SellHeap) Less(i, j int) bool {
-	if h[i].Price != h[j].Price {
-		return h[i].Price < h[j].Price
+func NewSellHeap() *SellHeap {
+	return &SellHeap{
+		h: newOrderHeap(func(a, b *Order) bool {
+			if a.Price != b.Price {
+				return a.Price < b.Price
+			}
+			return a.Timestamp < b.Timestamp
+		}),
 	}
-	return h[i].Timestamp < h[j].Timestamp
 }
 
-func (h SellHeap) Swap(i, j int) {
-	h[i], h[j] = h[j], h[i]
+func (s *SellHeap) Len() int { return s.h.Len() }
+
+func (s *SellHeap) Push(order *Order) {
+	heap.Push(s.h, order)
+}
+
+func (s *SellHeap) Pop() *Order {
+	if s.h.Len() == 0 {
+		return nil
+	}
+	return heap.Pop(s.h).(*Order)
 }
 
-func (h *SellHeap) Push(x interface{}) {
-	*h = append(*h, x.(*Order))
+func (s *SellHeap) Peek() *Order {
+	return s.h.peek()
 }
 
-func (h *SellHeap) Pop() interface{} {
-	old := *h
-	n := len(old)
-	order := old[n-1]
-	old[n-1] = nil
-	*h = old[0 : n-1]
-	return order
+func (s *SellHeap) Get(id uuid.UUID) *Order {
+	return s.h.get(id)
 }
 
-func (h *SellHeap) Peek() *Order {
-	if h.Len() == 0 {
-		return nil
-	}
-	return (*h)[0]
+func (s *SellHeap) Remove(id uuid.UUID) *Order {
+	return s.h.remove(id)
 }
 
-func NewSellHeap() *SellHeap {
-	h := &SellHeap{}
-	heap.Init(h)
-	return h
+func (s *SellHeap) Orders() []*Order {
+	return s.h.orders
 }
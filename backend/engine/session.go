@@ -0,0 +1,411 @@
+package engine
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SessionCheckInterval is how often a started engine compares its clock
+// with the schedule.
+const SessionCheckInterval = 100 * time.Millisecond
+
+// SessionPhase is a part of the trading day.
+type SessionPhase int
+
+const (
+	PHASE_CLOSED SessionPhase = iota
+	PHASE_PRE_OPEN
+	PHASE_CONTINUOUS
+	PHASE_CLOSING_AUCTION
+)
+
+func (p SessionPhase) String() string {
+	switch p {
+	case PHASE_PRE_OPEN:
+		return "PRE_OPEN"
+	case PHASE_CONTINUOUS:
+		return "CONTINUOUS"
+	case PHASE_CLOSING_AUCTION:
+		return "CLOSING_AUCTION"
+	default:
+		return "CLOSED"
+	}
+}
+
+func (p SessionPhase) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+// State is the trading state books are put in for the phase.
+func (p SessionPhase) State() TradingState {
+	switch p {
+	case PHASE_PRE_OPEN, PHASE_CLOSING_AUCTION:
+		return AUCTION
+	case PHASE_CONTINUOUS:
+		return CONTINUOUS
+	default:
+		return CLOSED
+	}
+}
+
+// maxCalendarScan bounds how many days a schedule looks back or ahead for
+// a trading day.
+const maxCalendarScan = 366
+
+// Schedule is a trading day: a pre-open call from PreOpen that uncrosses at
+// Open, continuous trading until ClosingAuction, and a closing call that
+// uncrosses at Close. Times are offsets from midnight in Location. Setting
+// PreOpen equal to Open, or ClosingAuction equal to Close, skips that
+// auction. Saturdays, Sundays and Holidays, given as YYYY-MM-DD, are closed
+// all day.
+type Schedule struct {
+	Location       *time.Location `json:"-"`
+	PreOpen        time.Duration  `json:"pre_open"`
+	Open           time.Duration  `json:"open"`
+	ClosingAuction time.Duration  `json:"closing_auction"`
+	Close          time.Duration  `json:"close"`
+	Holidays       []string       `json:"holidays,omitempty"`
+}
+
+func (s Schedule) Validate() error {
+	if s.PreOpen < 0 || s.Close > 24*time.Hour {
+		return fmt.Errorf("session times must be within the day")
+	}
+	if s.PreOpen > s.Open || s.Open >= s.ClosingAuction || s.ClosingAuction > s.Close {
+		return fmt.Errorf("session times must run pre-open, open, closing auction, close")
+	}
+	for _, day := range s.Holidays {
+		if _, err := time.Parse(time.DateOnly, day); err != nil {
+			return fmt.Errorf("holiday %q must be YYYY-MM-DD", day)
+		}
+	}
+	return nil
+}
+
+func (s Schedule) location() *time.Location {
+	if s.Location == nil {
+		return time.UTC
+	}
+	return s.Location
+}
+
+// TradingDay reports whether the day t falls on in Location has a session.
+func (s Schedule) TradingDay(t time.Time) bool {
+	t = t.In(s.location())
+	if t.Weekday() == time.Saturday || t.Weekday() == time.Sunday {
+		return false
+	}
+	return !slices.Contains(s.Holidays, t.Format(time.DateOnly))
+}
+
+func (s Schedule) midnight(t time.Time) time.Time {
+	t = t.In(s.location())
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// PhaseAt returns the phase of the trading day at t.
+func (s Schedule) PhaseAt(t time.Time) SessionPhase {
+	if !s.TradingDay(t) {
+		return PHASE_CLOSED
+	}
+	since := t.Sub(s.midnight(t))
+
+	switch {
+	case since < s.PreOpen:
+		return PHASE_CLOSED
+	case since < s.Open:
+		return PHASE_PRE_OPEN
+	case since < s.ClosingAuction:
+		return PHASE_CONTINUOUS
+	case since < s.Close:
+		return PHASE_CLOSING_AUCTION
+	default:
+		return PHASE_CLOSED
+	}
+}
+
+// LastClose returns the trading day, YYYY-MM-DD, of the last session to
+// close at or before t, or "" if there is none within a year.
+func (s Schedule) LastClose(t time.Time) string {
+	day := s.midnight(t)
+	for range maxCalendarScan {
+		if s.TradingDay(day) && !day.Add(s.Close).After(t) {
+			return day.Format(time.DateOnly)
+		}
+		day = day.AddDate(0, 0, -1)
+	}
+	return ""
+}
+
+// SessionDate returns the trading day, YYYY-MM-DD, of the session in
+// progress at t, or of the next one if none is. An order entered at t
+// expires no earlier than that day's close.
+func (s Schedule) SessionDate(t time.Time) string {
+	day := s.midnight(t)
+	for range maxCalendarScan {
+		if s.TradingDay(day) && day.Add(s.Close).After(t) {
+			return day.Format(time.DateOnly)
+		}
+		day = day.AddDate(0, 0, 1)
+	}
+	return ""
+}
+
+// Calendar holds the default schedule and per-instrument overrides.
+type Calendar struct {
+	Default     Schedule            `json:"default"`
+	Instruments map[string]Schedule `json:"instruments,omitempty"`
+}
+
+func (c Calendar) Validate() error {
+	if err := c.Default.Validate(); err != nil {
+		return fmt.Errorf("default: %w", err)
+	}
+	for symbol, s := range c.Instruments {
+		if err := s.Validate(); err != nil {
+			return fmt.Errorf("instrument %s: %w", symbol, err)
+		}
+	}
+	return nil
+}
+
+func (c Calendar) get(symbol string) Schedule {
+	if s, exists := c.Instruments[symbol]; exists {
+		return s
+	}
+	return c.Default
+}
+
+// sessionState is the phase last applied to a symbol's book and the
+// trading day of the last close applied.
+type sessionState struct {
+	phase  SessionPhase
+	closed string
+}
+
+// session's mu guards the calendar and symbols; checking serializes
+// CheckSchedule so phase changes are sent in the order they were found.
+type session struct {
+	calendar *Calendar
+	symbols  map[string]sessionState
+	mu       sync.Mutex
+	checking sync.Mutex
+}
+
+// phaseChange is a state command CheckSchedule owes a symbol.
+type phaseChange struct {
+	symbol string
+	phase  SessionPhase
+	closed string
+}
+
+// SetCalendar makes the engine's clock drive every book through its
+// instrument's trading day. It must be called before Start; nil turns the
+// calendar off.
+func (me *MatchingEngine) SetCalendar(c *Calendar) error {
+	if c != nil {
+		if err := c.Validate(); err != nil {
+			return err
+		}
+	}
+
+	me.session.mu.Lock()
+	defer me.session.mu.Unlock()
+	me.session.calendar = c
+	me.session.symbols = make(map[string]sessionState)
+	return nil
+}
+
+func (me *MatchingEngine) scheduled() bool {
+	me.session.mu.Lock()
+	defer me.session.mu.Unlock()
+	return me.session.calendar != nil
+}
+
+// SessionPhase returns the phase last applied to symbol, and false if there
+// is no calendar.
+func (me *MatchingEngine) SessionPhase(symbol string) (SessionPhase, bool) {
+	me.session.mu.Lock()
+	defer me.session.mu.Unlock()
+	return me.session.symbols[symbol].phase, me.session.calendar != nil
+}
+
+// SessionPhases returns the phase last applied to each symbol, or nil if
+// there is no calendar.
+func (me *MatchingEngine) SessionPhases() map[string]SessionPhase {
+	me.session.mu.Lock()
+	defer me.session.mu.Unlock()
+
+	if me.session.calendar == nil {
+		return nil
+	}
+	phases := make(map[string]SessionPhase, len(me.session.symbols))
+	for symbol, state := range me.session.symbols {
+		phases[symbol] = state.phase
+	}
+	return phases
+}
+
+// SessionDate returns symbol's Schedule.SessionDate now, and false if there
+// is no calendar.
+func (me *MatchingEngine) SessionDate(symbol string) (string, bool) {
+	me.session.mu.Lock()
+	defer me.session.mu.Unlock()
+
+	if me.session.calendar == nil {
+		return "", false
+	}
+	return me.session.calendar.get(symbol).SessionDate(me.clock.Now()), true
+}
+
+// CheckSession reports why order could not rest until its session ends, or
+// "" if it could. Without a calendar there is no close, so DAY and GTD
+// orders would never expire.
+func (me *MatchingEngine) CheckSession(order *Order) string {
+	if order.TimeInForce != DAY && order.TimeInForce != GTD {
+		return ""
+	}
+	session, scheduled := me.SessionDate(order.Symbol)
+	if !scheduled {
+		return "DAY and GTD orders need a session calendar"
+	}
+	if order.TimeInForce == GTD && order.GoodTillDate < session {
+		return "good till date is before the current session " + session
+	}
+	return ""
+}
+
+// admitSession rejects a new order CheckSession refuses. It runs before the
+// order is journaled, so replay never depends on the calendar.
+func (me *MatchingEngine) admitSession(cmd command) bool {
+	if cmd.kind != newOrderCommand {
+		return true
+	}
+	if reason := me.CheckSession(cmd.order); reason != "" {
+		me.rejectOrder(cmd.order, reason)
+		return false
+	}
+	return true
+}
+
+// CheckSchedule moves each instrument's book into the state for its current
+// phase if the phase has changed since the last check. Every close expires
+// the DAY and GTD orders due; a close the clock jumped past is still
+// applied, before the current phase. Books that are halted stay halted
+// until the close. A started engine checks every SessionCheckInterval;
+// simulations driving a SimClock can call it after moving the clock.
+func (me *MatchingEngine) CheckSchedule() {
+	me.session.checking.Lock()
+	defer me.session.checking.Unlock()
+
+	for _, change := range me.phaseChanges() {
+		me.applyPhase(change.symbol, change.phase, change.closed)
+	}
+}
+
+// phaseChanges records the phase each symbol is now in and returns the state
+// commands that takes. They are sent after session.mu is released, since
+// each waits on a shard.
+func (me *MatchingEngine) phaseChanges() []phaseChange {
+	me.session.mu.Lock()
+	defer me.session.mu.Unlock()
+
+	if me.session.calendar == nil {
+		return nil
+	}
+	var changes []phaseChange
+	now := me.clock.Now()
+	for _, symbol := range me.registry.Symbols() {
+		schedule := me.session.calendar.get(symbol)
+		next := sessionState{phase: schedule.PhaseAt(now), closed: schedule.LastClose(now)}
+		last, applied := me.session.symbols[symbol]
+		if applied && next == last {
+			continue
+		}
+
+		closes := next.closed != "" && (next.phase == PHASE_CLOSED || applied && next.closed != last.closed)
+		if closes {
+			changes = append(changes, phaseChange{symbol, PHASE_CLOSED, next.closed})
+		}
+		if next.phase != PHASE_CLOSED && (!applied || next.phase != last.phase || closes) {
+			changes = append(changes, phaseChange{symbol, next.phase, ""})
+		}
+		me.session.symbols[symbol] = next
+	}
+	return changes
+}
+
+// runSchedule checks the schedule every SessionCheckInterval until Stop,
+// away from the router so orders keep flowing while a close is applied.
+func (me *MatchingEngine) runSchedule() {
+	ticker := time.NewTicker(SessionCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			me.CheckSchedule()
+		case <-me.quit:
+			return
+		}
+	}
+}
+
+// applyPhase puts symbol's book into phase's state. A close carries its
+// trading day so the orders it expires are chosen the same way on replay.
+func (me *MatchingEngine) applyPhase(symbol string, phase SessionPhase, closed string) {
+	me.logger.Info("Session phase changed", "symbol", symbol, "phase", phase, "session", closed)
+	err := me.sendState(command{
+		kind:      stateCommand,
+		symbol:    symbol,
+		state:     phase.State(),
+		scheduled: true,
+		session:   closed,
+		reason:    "session " + strings.ToLower(phase.String()),
+	})
+	if err != nil {
+		me.logger.Error("Session phase not applied", "symbol", symbol, "phase", phase, "error", err)
+	}
+}
+
+// expireOrders removes every resting and stop order that ends with the
+// session closing on date and sends each its expiry report.
+func (me *MatchingEngine) expireOrders(book *OrderBook, date string) {
+	book.mu.Lock()
+	defer book.mu.Unlock()
+
+	var expired []*Order
+	due := func(order *Order) bool {
+		if order.expiresBy(date) {
+			expired = append(expired, order)
+		}
+		return true
+	}
+	book.bids.each(due)
+	book.asks.each(due)
+	for _, order := range book.stops {
+		due(order)
+	}
+
+	for _, order := range expired {
+		if book.side(order.Side).Remove(order.ID) == nil {
+			book.removeStop(order.ID)
+		}
+		me.logger.Info("Order expired",
+			"order_id", order.ID,
+			"symbol", order.Symbol,
+			"time_in_force", order.TimeInForce,
+			"session", date,
+			"remaining_qty", order.Qty,
+		)
+		order.Status = EXPIRED
+		reason := "day order expired"
+		if order.TimeInForce == GTD {
+			reason = "good till date reached"
+		}
+		me.emitOrder(book, EVENT_EXPIRED, order, reason)
+	}
+}
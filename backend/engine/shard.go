@@ -0,0 +1,307 @@
+package engine
+
+import (
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const DefaultShardCount = 4
+
+// DefaultRetainedOrders is how many finished orders each shard keeps so
+// their status can still be queried.
+const DefaultRetainedOrders = 10000
+
+// shard owns the books for a subset of symbols. Every command for a symbol
+// lands on the same shard and is applied by its single goroutine, so
+// ordering within a symbol is strict while symbols on other shards proceed
+// independently.
+type shard struct {
+	id      int
+	cmdChan chan command
+	books   map[string]*OrderBook
+	orders  map[uuid.UUID]*Order
+	// finished holds the IDs of finished orders still in orders, oldest
+	// first.
+	finished []uuid.UUID
+	mu       sync.RWMutex
+}
+
+func newShard(id, bufferSize int) *shard {
+	return &shard{
+		id:      id,
+		cmdChan: make(chan command, bufferSize),
+		books:   make(map[string]*OrderBook),
+		orders:  make(map[uuid.UUID]*Order),
+	}
+}
+
+func (me *MatchingEngine) shardFor(symbol string) *shard {
+	h := fnv.New32a()
+	h.Write([]byte(symbol))
+	return me.shards[h.Sum32()%uint32(len(me.shards))]
+}
+
+// ShardOf reports which shard handles symbol.
+func (me *MatchingEngine) ShardOf(symbol string) int {
+	return me.shardFor(symbol).id
+}
+
+func (me *MatchingEngine) ShardCount() int {
+	return len(me.shards)
+}
+
+// route is the single reader of orderChan. It forwards each order to the
+// shard owning its symbol, preserving arrival order per symbol.
+func (me *MatchingEngine) route() {
+	defer close(me.routerDone)
+
+	for {
+		select {
+		case order := <-me.orderChan:
+			me.dispatch(order)
+		case <-me.quit:
+			for {
+				select {
+				case order := <-me.orderChan:
+					me.dispatch(order)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (me *MatchingEngine) dispatch(order *Order) {
+	s := me.shardFor(order.Symbol)
+	me.resolveSelfTrade(order)
+	me.resolveFees(order)
+
+	me.indexMu.Lock()
+	me.orderIndex[order.ID] = s
+	me.indexMu.Unlock()
+
+	s.cmdChan <- command{kind: newOrderCommand, order: order}
+}
+
+func (me *MatchingEngine) shardOfOrder(id uuid.UUID) *shard {
+	me.indexMu.RLock()
+	defer me.indexMu.RUnlock()
+	return me.orderIndex[id]
+}
+
+func (me *MatchingEngine) runShard(s *shard) {
+	defer me.wg.Done()
+
+	for {
+		select {
+		case cmd := <-s.cmdChan:
+			me.execute(s, cmd)
+		case <-me.shardQuit:
+			for {
+				select {
+				case cmd := <-s.cmdChan:
+					me.execute(s, cmd)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// Stop drains every queued order and command through the shards and waits
+// for them to finish. Producers should be stopped first.
+func (me *MatchingEngine) Stop() {
+	me.stopOnce.Do(func() {
+		me.logger.Info("Draining matching engine", "queue_depth", me.GetQueueDepth())
+		close(me.quit)
+		<-me.routerDone
+		close(me.shardQuit)
+		me.wg.Wait()
+		close(me.stopped)
+		me.logger.Info("Matching engine stopped")
+	})
+}
+
+// Stopped reports whether Stop has begun. Orders sent after that may never
+// be read.
+func (me *MatchingEngine) Stopped() bool {
+	select {
+	case <-me.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+// send queues cmd on s, reporting false once the shards are shutting down.
+func (me *MatchingEngine) send(s *shard, cmd command) bool {
+	select {
+	case s.cmdChan <- cmd:
+		return true
+	case <-me.shardQuit:
+		return false
+	}
+}
+
+// await waits for the reply to a command, reporting false if the shards
+// stopped without sending one.
+func await[T any](me *MatchingEngine, reply <-chan T) (T, bool) {
+	select {
+	case r := <-reply:
+		return r, true
+	case <-me.stopped:
+		select {
+		case r := <-reply:
+			return r, true
+		default:
+			var zero T
+			return zero, false
+		}
+	}
+}
+
+func (me *MatchingEngine) execute(s *shard, cmd command) {
+	if cmd.kind == pauseCommand {
+		cmd.paused.Done()
+		<-cmd.resume
+		return
+	}
+	if cmd.kind == queryCommand {
+		cmd.queryReply <- me.lookupOrder(s, cmd.orderID)
+		return
+	}
+
+	startTime := time.Now()
+	cmd.at = me.now()
+
+	// A command is journaled before it touches the book; if that fails the
+	// caller is told it was rejected. An order whose submitter already gave
+	// up, that its session could not expire, or whose account cannot cover
+	// it, is rejected without being journaled.
+	if cmd.kind == newOrderCommand && !cmd.order.claim() {
+		me.rejectOrder(cmd.order, "submission cancelled")
+	} else if me.admitSession(cmd) && me.reserveFunds(s, cmd) {
+		if err := me.journalCommand(cmd); err != nil {
+			me.logger.Error("Journal write failed", "error", err)
+			me.refuse(s, cmd)
+		} else {
+			me.apply(s, cmd)
+		}
+	}
+	if cmd.kind == newOrderCommand {
+		me.finishSubmit(cmd.order)
+	}
+
+	// Latency samples are best effort; a slow reader must not stall the shard.
+	latency := time.Since(startTime).Microseconds()
+	select {
+	case me.metricsChan <- Metric{
+		Type:      "latency",
+		Value:     float64(latency),
+		Shard:     s.id,
+		Timestamp: time.Now().UnixNano(),
+	}:
+	default:
+	}
+}
+
+func (me *MatchingEngine) apply(s *shard, cmd command) {
+	switch cmd.kind {
+	case newOrderCommand:
+		s.orders[cmd.order.ID] = cmd.order
+		me.processOrder(cmd.order, cmd.at)
+		me.flushBook(cmd.order.Symbol)
+	case cancelCommand:
+		result := me.cancelOrder(s, cmd.orderID)
+		me.flushOrder(s, cmd.orderID)
+		me.journalEvent(&JournalRecord{Type: RecordCancelResult, OrderID: cmd.orderID, Result: result.String()})
+		if cmd.cancelReply != nil {
+			cmd.cancelReply <- result
+		}
+	case amendCommand:
+		result := me.amendOrder(s, cmd.orderID, cmd.price, cmd.qty, cmd.at)
+		me.settleOrder(s, cmd.orderID)
+		me.flushOrder(s, cmd.orderID)
+		me.journalEvent(&JournalRecord{Type: RecordAmendResult, OrderID: cmd.orderID, Result: result.String()})
+		if cmd.amendReply != nil {
+			cmd.amendReply <- result
+		}
+	case stateCommand:
+		me.setTradingState(cmd)
+		me.flushBook(cmd.symbol)
+		if cmd.stateReply != nil {
+			cmd.stateReply <- nil
+		}
+	}
+	me.prune(s)
+}
+
+// retire marks a finished order to be forgotten once it is among the
+// oldest finished orders past the retention limit.
+func (me *MatchingEngine) retire(order *Order) {
+	if order.retired || !order.Status.Done() {
+		return
+	}
+	order.retired = true
+	s := me.shardFor(order.Symbol)
+	s.finished = append(s.finished, order.ID)
+}
+
+func (me *MatchingEngine) prune(s *shard) {
+	excess := len(s.finished) - me.retainOrders
+	if excess <= 0 {
+		return
+	}
+
+	me.indexMu.Lock()
+	for _, id := range s.finished[:excess] {
+		delete(s.orders, id)
+		delete(me.orderIndex, id)
+	}
+	me.indexMu.Unlock()
+	s.finished = s.finished[excess:]
+}
+
+func (me *MatchingEngine) flushOrder(s *shard, id uuid.UUID) {
+	if order, exists := s.orders[id]; exists {
+		me.flushBook(order.Symbol)
+	}
+}
+
+// settleOrder releases whatever an amend reserved beyond what the order now
+// needs.
+func (me *MatchingEngine) settleOrder(s *shard, id uuid.UUID) {
+	if order, exists := s.orders[id]; exists {
+		me.accounts.settle(order)
+	}
+}
+
+func (me *MatchingEngine) refuse(s *shard, cmd command) {
+	switch cmd.kind {
+	case newOrderCommand:
+		me.rejectOrder(cmd.order, "journal unavailable")
+	case cancelCommand:
+		cmd.cancelReply <- CANCEL_REJECTED
+	case amendCommand:
+		me.settleOrder(s, cmd.orderID)
+		cmd.amendReply <- AMEND_REJECTED
+	case stateCommand:
+		if cmd.stateReply != nil {
+			cmd.stateReply <- ErrJournalUnavailable
+		}
+	}
+}
+
+// ShardQueueDepths returns the number of pending commands per shard.
+func (me *MatchingEngine) ShardQueueDepths() []int {
+	depths := make([]int, len(me.shards))
+	for i, s := range me.shards {
+		depths[i] = len(s.cmdChan)
+	}
+	return depths
+}
@@ -0,0 +1,174 @@
+package engine
+
+import (
+	"sort"
+	"sync"
+)
+
+// BookState is a point-in-time copy of one book. Bids and Asks are in
+// priority order (best level first, FIFO within a level); Stops are in
+// arrival order. Window is the circuit breaker's recent trades.
+type BookState struct {
+	Symbol    string
+	Seq       uint64
+	LastPrice *Price
+	Trading   TradingStatus
+	Window    []PricePoint
+	Bids      []Order
+	Asks      []Order
+	Stops     []Order
+}
+
+// EngineState is everything needed to rebuild the books without replaying
+// the journal up to Seq.
+type EngineState struct {
+	Seq      uint64
+	Time     int64
+	IDSeq    uint64
+	EventSeq uint64
+	Books    []BookState
+	Accounts []AccountState
+}
+
+func (st *EngineState) OrderCount() int {
+	count := 0
+	for _, book := range st.Books {
+		count += len(book.Bids) + len(book.Asks) + len(book.Stops)
+	}
+	return count
+}
+
+// Capture pauses every shard at a command boundary, copies the books and
+// resumes. The result is consistent with the journal up to its Seq.
+func (me *MatchingEngine) Capture() EngineState {
+	me.captureMu.Lock()
+	defer me.captureMu.Unlock()
+
+	if me.started.Load() {
+		resume := me.pause()
+		defer resume()
+	}
+
+	state := EngineState{Time: me.now()}
+	if ids, ok := me.ids.(positionedIDs); ok {
+		state.IDSeq = ids.Position()
+	}
+	// Deposits and withdrawals are journaled under the ledger lock, so the
+	// balances copied here match the journal position.
+	me.accounts.mu.Lock()
+	if me.journal != nil {
+		state.Seq = me.journal.LastSeq()
+	}
+	state.Accounts = me.accounts.states()
+	me.accounts.mu.Unlock()
+	state.EventSeq = me.LastSeq()
+
+	for _, s := range me.shards {
+		s.mu.RLock()
+		for _, book := range s.books {
+			state.Books = append(state.Books, book.state())
+		}
+		s.mu.RUnlock()
+	}
+	sort.Slice(state.Books, func(i, j int) bool {
+		return state.Books[i].Symbol < state.Books[j].Symbol
+	})
+	return state
+}
+
+func (me *MatchingEngine) pause() func() {
+	var paused sync.WaitGroup
+	release := make(chan struct{})
+
+	paused.Add(len(me.shards))
+	for _, s := range me.shards {
+		s.cmdChan <- command{kind: pauseCommand, paused: &paused, resume: release}
+	}
+	paused.Wait()
+
+	return func() { close(release) }
+}
+
+// Restore loads a captured state into an engine that has not been started.
+func (me *MatchingEngine) Restore(state EngineState) {
+	if ids, ok := me.ids.(positionedIDs); ok {
+		ids.SetPosition(state.IDSeq)
+	}
+	me.eventMu.Lock()
+	me.eventSeq = state.EventSeq
+	me.eventMu.Unlock()
+	me.accounts.restore(state.Accounts)
+
+	for _, bs := range state.Books {
+		book := me.GetOrCreateBook(bs.Symbol)
+		s := me.shardFor(bs.Symbol)
+
+		book.mu.Lock()
+		if bs.LastPrice != nil {
+			price := *bs.LastPrice
+			book.lastPrice = &price
+		}
+		book.seq.Store(bs.Seq)
+		book.trading = bs.Trading
+		book.window = append([]PricePoint(nil), bs.Window...)
+		for _, o := range bs.Bids {
+			order := o
+			book.bids.Push(&order)
+			me.track(s, &order)
+		}
+		for _, o := range bs.Asks {
+			order := o
+			book.asks.Push(&order)
+			me.track(s, &order)
+		}
+		for _, o := range bs.Stops {
+			order := o
+			book.stops = append(book.stops, &order)
+			me.track(s, &order)
+		}
+		clear(book.bids.dirty)
+		clear(book.asks.dirty)
+		book.mu.Unlock()
+	}
+}
+
+func (me *MatchingEngine) track(s *shard, order *Order) {
+	s.orders[order.ID] = order
+	me.accounts.settle(order)
+
+	me.indexMu.Lock()
+	me.orderIndex[order.ID] = s
+	me.indexMu.Unlock()
+}
+
+func (ob *OrderBook) state() BookState {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+
+	bs := BookState{
+		Symbol:  ob.Symbol,
+		Seq:     ob.seq.Load(),
+		Trading: ob.trading,
+		Window:  append([]PricePoint(nil), ob.window...),
+		Bids:    make([]Order, 0, ob.bids.Len()),
+		Asks:    make([]Order, 0, ob.asks.Len()),
+		Stops:   make([]Order, 0, len(ob.stops)),
+	}
+	if ob.lastPrice != nil {
+		price := *ob.lastPrice
+		bs.LastPrice = &price
+	}
+
+	ob.bids.each(func(o *Order) bool {
+		bs.Bids = append(bs.Bids, *o)
+		return true
+	})
+	ob.asks.each(func(o *Order) bool {
+		bs.Asks = append(bs.Asks, *o)
+		return true
+	})
+	for _, o := range ob.stops {
+		bs.Stops = append(bs.Stops, *o)
+	}
+	return bs
+}
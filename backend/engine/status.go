@@ -0,0 +1,102 @@
+package engine
+
+import "github.com/google/uuid"
+
+type OrderStatus int
+
+const (
+	NEW OrderStatus = iota
+	PARTIALLY_FILLED
+	FILLED
+	CANCELLED
+	REJECTED
+	EXPIRED
+)
+
+func (s OrderStatus) String() string {
+	switch s {
+	case PARTIALLY_FILLED:
+		return "PARTIALLY_FILLED"
+	case FILLED:
+		return "FILLED"
+	case CANCELLED:
+		return "CANCELLED"
+	case REJECTED:
+		return "REJECTED"
+	case EXPIRED:
+		return "EXPIRED"
+	default:
+		return "NEW"
+	}
+}
+
+// Done reports whether the order can no longer trade.
+func (s OrderStatus) Done() bool {
+	return s == FILLED || s == CANCELLED || s == REJECTED || s == EXPIRED
+}
+
+// fill records an execution against the order. Qty must already have been
+// reduced by qty.
+func (o *Order) fill(qty int, price Price) {
+	o.FilledQty += qty
+	o.Notional += int64(price) * int64(qty)
+	o.AvgPrice = Price((o.Notional + int64(o.FilledQty)/2) / int64(o.FilledQty))
+	if o.Qty == 0 {
+		o.Status = FILLED
+	} else {
+		o.Status = PARTIALLY_FILLED
+	}
+}
+
+// OrigQty is the quantity the order was entered (or last amended) with.
+func (o *Order) OrigQty() int {
+	return o.Qty + o.FilledQty
+}
+
+// LeavesQty is what is still open; zero once the order is done.
+func (o *Order) LeavesQty() int {
+	if o.Status.Done() {
+		return 0
+	}
+	return o.Qty
+}
+
+// SetOrderRetention sets how many finished orders each shard keeps for
+// GetOrder, cancels and amends to report on. It must be called before Start.
+func (me *MatchingEngine) SetOrderRetention(n int) {
+	me.retainOrders = max(n, 0)
+}
+
+// GetOrder returns a copy of the order as its shard currently sees it. Orders
+// still queued ahead of the shard are not found yet, and finished orders are
+// forgotten once the shard holds more than its retention limit of them.
+func (me *MatchingEngine) GetOrder(id uuid.UUID) (Order, bool) {
+	s := me.shardOfOrder(id)
+	if s == nil {
+		return Order{}, false
+	}
+
+	reply := make(chan *Order, 1)
+	cmd := command{
+		kind:       queryCommand,
+		orderID:    id,
+		queryReply: reply,
+	}
+	if !me.send(s, cmd) {
+		return Order{}, false
+	}
+	order, _ := await(me, reply)
+	if order == nil {
+		return Order{}, false
+	}
+	return *order, true
+}
+
+func (me *MatchingEngine) lookupOrder(s *shard, id uuid.UUID) *Order {
+	order, exists := s.orders[id]
+	if !exists {
+		return nil
+	}
+	copied := *order
+	return &copied
+}
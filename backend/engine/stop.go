@@ -0,0 +1,134 @@
+package engine
+
+import (
+	"sort"
+
+	"github.com/google/uuid"
+)
+
+func (o *Order) isStop() bool {
+	return o.Type == STOP || o.Type == STOP_LIMIT
+}
+
+func (o *Order) stopTriggeredBy(price Price) bool {
+	if o.Side == BUY {
+		return price >= o.StopPrice
+	}
+	return price <= o.StopPrice
+}
+
+func (o *Order) activate(now int64) {
+	if o.Type == STOP {
+		o.Type = MARKET
+		o.TimeInForce = IOC
+	} else {
+		o.Type = LIMIT
+	}
+	o.Timestamp = now
+}
+
+func (ob *OrderBook) GetLastPrice() *Price {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+
+	if ob.lastPrice == nil {
+		return nil
+	}
+	price := *ob.lastPrice
+	return &price
+}
+
+func (ob *OrderBook) holdStop(order *Order) bool {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	if ob.lastPrice != nil && order.stopTriggeredBy(*ob.lastPrice) {
+		return false
+	}
+	ob.stops = append(ob.stops, order)
+	return true
+}
+
+func (ob *OrderBook) getStop(id uuid.UUID) *Order {
+	for _, order := range ob.stops {
+		if order.ID == id {
+			return order
+		}
+	}
+	return nil
+}
+
+func (ob *OrderBook) removeStop(id uuid.UUID) *Order {
+	for i, order := range ob.stops {
+		if order.ID == id {
+			ob.stops = append(ob.stops[:i], ob.stops[i+1:]...)
+			return order
+		}
+	}
+	return nil
+}
+
+// recordTrade must be called with ob.mu held. Stops fired by this trade are
+// queued buys first, each side in the order the market would reach them.
+func (ob *OrderBook) recordTrade(price Price) {
+	ob.lastPrice = &price
+
+	var buys, sells []*Order
+	remaining := make([]*Order, 0, len(ob.stops))
+	for _, order := range ob.stops {
+		switch {
+		case !order.stopTriggeredBy(price):
+			remaining = append(remaining, order)
+		case order.Side == BUY:
+			buys = append(buys, order)
+		default:
+			sells = append(sells, order)
+		}
+	}
+	if len(buys) == 0 && len(sells) == 0 {
+		return
+	}
+	ob.stops = remaining
+
+	sort.SliceStable(buys, func(i, j int) bool {
+		if buys[i].StopPrice != buys[j].StopPrice {
+			return buys[i].StopPrice < buys[j].StopPrice
+		}
+		return buys[i].Timestamp < buys[j].Timestamp
+	})
+	sort.SliceStable(sells, func(i, j int) bool {
+		if sells[i].StopPrice != sells[j].StopPrice {
+			return sells[i].StopPrice > sells[j].StopPrice
+		}
+		return sells[i].Timestamp < sells[j].Timestamp
+	})
+
+	ob.triggered = append(ob.triggered, buys...)
+	ob.triggered = append(ob.triggered, sells...)
+}
+
+func (ob *OrderBook) nextTriggered() *Order {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	if len(ob.triggered) == 0 {
+		return nil
+	}
+	order := ob.triggered[0]
+	ob.triggered[0] = nil
+	ob.triggered = ob.triggered[1:]
+	return order
+}
+
+func (me *MatchingEngine) releaseStops(book *OrderBook) {
+	for order := book.nextTriggered(); order != nil; order = book.nextTriggered() {
+		order.activate(me.now())
+		me.logger.Info("Stop order triggered",
+			"order_id", order.ID,
+			"symbol", order.Symbol,
+			"side", order.Side,
+			"stop_price", order.StopPrice,
+		)
+		me.matchOrder(book, order)
+	}
+}
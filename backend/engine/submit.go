@@ -0,0 +1,102 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+// ErrEngineStopped is returned for work that arrives after Stop.
+var ErrEngineStopped = errors.New("engine stopped")
+
+// SubmitResult is the immediate outcome of a submitted order: its state once
+// the engine finished with it, every trade it took part in while doing so,
+// and the reason if it was rejected or expired.
+type SubmitResult struct {
+	Order  Order
+	Trades []Trade
+	Reason string
+}
+
+const (
+	submitPending int32 = iota
+	submitTaken
+	submitAbandoned
+)
+
+// submission rides along with an order submitted through Submit. The shard
+// claims it before journaling the order; a caller that gives up first
+// abandons it and the order is rejected without touching the book.
+type submission struct {
+	state  atomic.Int32
+	done   chan SubmitResult
+	trades []Trade
+	reason string
+}
+
+// Submit sends an order through the same queue as GetOrderChan and waits for
+// the result. If ctx ends before a shard picks the order up, the order is
+// rejected and ctx's error is returned. If it ends after, the order carries
+// on and the error says so; its outcome is then available from GetOrder and
+// the event feed.
+func (me *MatchingEngine) Submit(ctx context.Context, order *Order) (SubmitResult, error) {
+	if err := ctx.Err(); err != nil {
+		return SubmitResult{}, err
+	}
+
+	sub := &submission{done: make(chan SubmitResult, 1)}
+	order.pending = sub
+
+	select {
+	case me.orderChan <- order:
+	case <-me.quit:
+		return SubmitResult{}, ErrEngineStopped
+	case <-ctx.Done():
+		return SubmitResult{}, ctx.Err()
+	}
+
+	select {
+	case result := <-sub.done:
+		return result, nil
+	case <-me.stopped:
+		if sub.state.CompareAndSwap(submitPending, submitAbandoned) {
+			return SubmitResult{}, ErrEngineStopped
+		}
+		return <-sub.done, nil
+	case <-ctx.Done():
+		if sub.state.CompareAndSwap(submitPending, submitAbandoned) {
+			return SubmitResult{}, ctx.Err()
+		}
+		return SubmitResult{}, fmt.Errorf("order %s already processing: %w", order.ID, ctx.Err())
+	}
+}
+
+// claim reports whether the order should be processed.
+func (o *Order) claim() bool {
+	return o.pending == nil || o.pending.state.CompareAndSwap(submitPending, submitTaken)
+}
+
+func (o *Order) recordFill(trade *Trade) {
+	if o.pending != nil {
+		o.pending.trades = append(o.pending.trades, *trade)
+	}
+}
+
+func (o *Order) recordReason(reason string) {
+	if o.pending != nil && reason != "" {
+		o.pending.reason = reason
+	}
+}
+
+func (me *MatchingEngine) finishSubmit(order *Order) {
+	sub := order.pending
+	if sub == nil {
+		return
+	}
+	order.pending = nil
+
+	result := SubmitResult{Order: *order, Trades: sub.trades, Reason: sub.reason}
+	result.Order.pending = nil
+	sub.done <- result
+}
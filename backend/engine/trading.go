@@ -0,0 +1,354 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrUnknownSymbol = errors.New("unknown symbol")
+
+// TradingState is what a book does with incoming orders. Only CONTINUOUS
+// books match. AUCTION books collect limit orders without matching until
+// the call ends and the book uncrosses. HALTED and CLOSED books reject new
+// orders and amends but still accept cancels.
+type TradingState int
+
+const (
+	CONTINUOUS TradingState = iota
+	HALTED
+	AUCTION
+	CLOSED
+)
+
+func (s TradingState) String() string {
+	switch s {
+	case HALTED:
+		return "HALTED"
+	case AUCTION:
+		return "AUCTION"
+	case CLOSED:
+		return "CLOSED"
+	default:
+		return "CONTINUOUS"
+	}
+}
+
+func ParseTradingState(s string) (TradingState, error) {
+	switch strings.ToUpper(s) {
+	case "", "CONTINUOUS":
+		return CONTINUOUS, nil
+	case "HALTED":
+		return HALTED, nil
+	case "AUCTION":
+		return AUCTION, nil
+	case "CLOSED":
+		return CLOSED, nil
+	default:
+		return CONTINUOUS, fmt.Errorf("invalid trading state %q", s)
+	}
+}
+
+func (s TradingState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *TradingState) UnmarshalText(data []byte) error {
+	state, err := ParseTradingState(string(data))
+	if err != nil {
+		return err
+	}
+	*s = state
+	return nil
+}
+
+// TradingStatus is a book's trading state and, for a halt that ends by
+// itself, when it ends.
+type TradingStatus struct {
+	State TradingState `json:"state"`
+	Until int64        `json:"until,omitzero"`
+}
+
+// PricePoint is one trade in a circuit breaker's window.
+type PricePoint struct {
+	Time  int64
+	Price Price
+}
+
+// CircuitBreaker halts a symbol before it trades more than MovePct away
+// from any price it traded at in the last Window. The halt lifts after
+// Halt, or only by hand if Halt is zero. A zero MovePct turns it off.
+type CircuitBreaker struct {
+	MovePct float64       `json:"move_pct"`
+	Window  time.Duration `json:"window"`
+	Halt    time.Duration `json:"halt"`
+}
+
+func (cb CircuitBreaker) validate() error {
+	if cb.MovePct < 0 || cb.Window < 0 || cb.Halt < 0 {
+		return fmt.Errorf("circuit breaker settings must not be negative")
+	}
+	if cb.MovePct > 0 && cb.Window == 0 {
+		return fmt.Errorf("circuit breaker needs a window")
+	}
+	return nil
+}
+
+// CircuitBreakers holds the default breaker and per-instrument overrides.
+type CircuitBreakers struct {
+	Default     CircuitBreaker            `json:"default"`
+	Instruments map[string]CircuitBreaker `json:"instruments,omitempty"`
+}
+
+func (c CircuitBreakers) Validate() error {
+	if err := c.Default.validate(); err != nil {
+		return fmt.Errorf("default: %w", err)
+	}
+	for symbol, cb := range c.Instruments {
+		if err := cb.validate(); err != nil {
+			return fmt.Errorf("instrument %s: %w", symbol, err)
+		}
+	}
+	return nil
+}
+
+func (c CircuitBreakers) get(symbol string) CircuitBreaker {
+	if cb, exists := c.Instruments[symbol]; exists {
+		return cb
+	}
+	return c.Default
+}
+
+type breakerTable struct {
+	breakers CircuitBreakers
+	mu       sync.RWMutex
+}
+
+// SetCircuitBreakers replaces every breaker. Breakers decide where matching
+// stops, so recovery must run with the settings the journal was written
+// under.
+func (me *MatchingEngine) SetCircuitBreakers(c CircuitBreakers) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+
+	me.breakers.mu.Lock()
+	defer me.breakers.mu.Unlock()
+	me.breakers.breakers = c
+	return nil
+}
+
+func (me *MatchingEngine) CircuitBreaker(symbol string) CircuitBreaker {
+	me.breakers.mu.RLock()
+	defer me.breakers.mu.RUnlock()
+	return me.breakers.breakers.get(symbol)
+}
+
+// TradingStatus returns symbol's trading state. A symbol that has no book
+// yet is CONTINUOUS.
+func (me *MatchingEngine) TradingStatus(symbol string) TradingStatus {
+	if book := me.GetBook(symbol); book != nil {
+		return book.TradingStatus()
+	}
+	return TradingStatus{}
+}
+
+func (ob *OrderBook) TradingStatus() TradingStatus {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+	return ob.trading
+}
+
+// SetTradingState halts, closes, resumes or starts a call auction in symbol
+// by hand. The change is journaled and goes through the symbol's shard like
+// an order, so it takes effect between orders. A manual halt lasts until
+// resumed, and an auction until the book is moved to CONTINUOUS or CLOSED,
+// which uncrosses it.
+func (me *MatchingEngine) SetTradingState(symbol string, state TradingState, reason string) error {
+	return me.sendState(command{kind: stateCommand, symbol: symbol, state: state, reason: reason})
+}
+
+func (me *MatchingEngine) sendState(cmd command) error {
+	if _, exists := me.registry.Get(cmd.symbol); !exists {
+		return ErrUnknownSymbol
+	}
+
+	cmd.stateReply = make(chan error, 1)
+	if !me.send(me.shardFor(cmd.symbol), cmd) {
+		return ErrEngineStopped
+	}
+	if err, ok := await(me, cmd.stateReply); ok {
+		return err
+	}
+	return ErrEngineStopped
+}
+
+// setTradingState applies a state command. A command carrying Until is the
+// end of a timed halt and only applies if that halt is still in force; a
+// scheduled one leaves halted books alone unless it closes them. Leaving an
+// auction for CONTINUOUS or CLOSED uncrosses the book first, and stops its
+// trades trigger run once the new state is in force. A session close then
+// expires the orders it ends.
+func (me *MatchingEngine) setTradingState(cmd command) {
+	book := me.GetOrCreateBook(cmd.symbol)
+
+	book.mu.Lock()
+	if cmd.until != 0 && book.trading != (TradingStatus{State: HALTED, Until: cmd.until}) {
+		book.mu.Unlock()
+		return
+	}
+	if cmd.scheduled && cmd.state != CLOSED && book.trading.State == HALTED {
+		book.mu.Unlock()
+		return
+	}
+	book.at = cmd.at
+	if book.trading.State == AUCTION && (cmd.state == CONTINUOUS || cmd.state == CLOSED) {
+		me.uncross(book)
+	}
+	me.changeState(book, TradingStatus{State: cmd.state}, cmd.reason)
+	book.mu.Unlock()
+
+	me.releaseStops(book)
+	if cmd.session != "" {
+		me.expireOrders(book, cmd.session)
+	}
+}
+
+// changeState must be called with book.mu held.
+func (me *MatchingEngine) changeState(book *OrderBook, status TradingStatus, reason string) {
+	if book.trading == status {
+		return
+	}
+	book.trading = status
+	if status.State != CONTINUOUS {
+		book.window = book.window[:0]
+	}
+	if status.State != AUCTION {
+		book.indicative = Uncross{}
+	}
+
+	me.logger.Warn("Trading state changed",
+		"symbol", book.Symbol,
+		"state", status.State,
+		"until", status.Until,
+		"reason", reason,
+	)
+	me.emit(book, Event{Type: EVENT_TRADING_STATE, Symbol: book.Symbol, Trading: &status, Reason: reason})
+	if status.Until != 0 {
+		me.scheduleResume(book.Symbol, status.Until)
+	}
+}
+
+// admitTrade runs book's circuit breaker against a trade at price. If the
+// trade may go ahead it joins the window; otherwise the book is halted and
+// it must not happen. It must be called with book.mu held.
+func (me *MatchingEngine) admitTrade(book *OrderBook, price Price) bool {
+	cb := me.CircuitBreaker(book.Symbol)
+	if cb.MovePct <= 0 {
+		book.window = book.window[:0]
+		return true
+	}
+
+	cutoff := book.at - int64(cb.Window)
+	expired := 0
+	for expired < len(book.window) && book.window[expired].Time < cutoff {
+		expired++
+	}
+	book.window = append(book.window[:0], book.window[expired:]...)
+
+	if p, breached := cb.breach(book.window, price); breached {
+		status := TradingStatus{State: HALTED}
+		if cb.Halt > 0 {
+			status.Until = book.at + int64(cb.Halt)
+		}
+		me.changeState(book, status, fmt.Sprintf("circuit breaker: %s is more than %g%% from %s", price, cb.MovePct, p.Price))
+		return false
+	}
+	book.window = append(book.window, PricePoint{Time: book.at, Price: price})
+	return true
+}
+
+// admitsAll reports whether book's circuit breaker would let trades at each
+// of prices go ahead in turn. Unlike admitTrade it changes nothing. It must
+// be called with book.mu held.
+func (me *MatchingEngine) admitsAll(book *OrderBook, prices []Price) bool {
+	cb := me.CircuitBreaker(book.Symbol)
+	if cb.MovePct <= 0 {
+		return true
+	}
+
+	cutoff := book.at - int64(cb.Window)
+	var window []PricePoint
+	for _, p := range book.window {
+		if p.Time >= cutoff {
+			window = append(window, p)
+		}
+	}
+	for _, price := range prices {
+		if _, breached := cb.breach(window, price); breached {
+			return false
+		}
+		window = append(window, PricePoint{Time: book.at, Price: price})
+	}
+	return true
+}
+
+// breach returns a point in window that price is more than MovePct from.
+func (cb CircuitBreaker) breach(window []PricePoint, price Price) (PricePoint, bool) {
+	for _, p := range window {
+		if math.Abs(float64(price-p.Price)) > float64(p.Price)*cb.MovePct/100 {
+			return p, true
+		}
+	}
+	return PricePoint{}, false
+}
+
+// scheduleResume ends a timed halt once its time comes by sending the
+// shard a state command. Nothing is scheduled while replaying; the resume
+// is in the journal.
+func (me *MatchingEngine) scheduleResume(symbol string, until int64) {
+	if me.replaying || !me.started.Load() {
+		return
+	}
+
+	s := me.shardFor(symbol)
+	time.AfterFunc(time.Duration(until-me.now()), func() {
+		select {
+		case s.cmdChan <- command{kind: stateCommand, symbol: symbol, state: CONTINUOUS, until: until, reason: "halt expired"}:
+		case <-me.shardQuit:
+		}
+	})
+}
+
+// resumeTimedHalts schedules the end of every timed halt carried over from
+// a snapshot or the journal.
+func (me *MatchingEngine) resumeTimedHalts() {
+	for _, s := range me.shards {
+		s.mu.RLock()
+		for symbol, book := range s.books {
+			if status := book.TradingStatus(); status.Until != 0 {
+				me.scheduleResume(symbol, status.Until)
+			}
+		}
+		s.mu.RUnlock()
+	}
+}
+
+// haltOrder cancels an order that reached matching after its book stopped
+// trading, such as the rest of the order that tripped a circuit breaker or
+// a stop triggered by a closing uncross. Only the shard goroutine changes
+// book.trading, so it is read here without the lock.
+func (me *MatchingEngine) haltOrder(book *OrderBook, order *Order) {
+	state := book.trading.State
+	me.logger.Info("Order cancelled by trading state",
+		"order_id", order.ID,
+		"symbol", order.Symbol,
+		"state", state,
+		"remaining_qty", order.Qty,
+	)
+	order.Status = CANCELLED
+	me.emitOrder(book, EVENT_CANCELLED, order, "trading "+strings.ToLower(state.String()))
+}
@@ -3,7 +3,8 @@ module github.com/AkshatMadhani/nanopulse
 go 1.24.3
 
 require (
-	github.com/google/uuid v1.6.0 // indirect
-	github.com/gorilla/websocket v1.5.3 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
+	github.com/google/uuid v1.6.0
+	github.com/gorilla/websocket v1.5.3
 )
+
+require gopkg.in/yaml.v3 v3.0.1
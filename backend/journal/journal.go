@@ -0,0 +1,398 @@
+package journal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AkshatMadhani/nanopulse/engine"
+	"github.com/AkshatMadhani/nanopulse/logger"
+)
+
+type SyncPolicy int
+
+const (
+	// SYNC_ALWAYS fsyncs after every record.
+	SYNC_ALWAYS SyncPolicy = iota
+	// SYNC_INTERVAL flushes and fsyncs on a timer.
+	SYNC_INTERVAL
+	// SYNC_NEVER hands each record to the OS but never fsyncs.
+	SYNC_NEVER
+)
+
+func (p SyncPolicy) String() string {
+	switch p {
+	case SYNC_ALWAYS:
+		return "always"
+	case SYNC_INTERVAL:
+		return "interval"
+	default:
+		return "never"
+	}
+}
+
+func ParseSyncPolicy(s string) (SyncPolicy, error) {
+	switch strings.ToLower(s) {
+	case "always":
+		return SYNC_ALWAYS, nil
+	case "", "interval":
+		return SYNC_INTERVAL, nil
+	case "never":
+		return SYNC_NEVER, nil
+	default:
+		return SYNC_INTERVAL, fmt.Errorf("unknown sync policy %q", s)
+	}
+}
+
+const segmentExt = ".wal"
+
+type Options struct {
+	Dir          string
+	Sync         SyncPolicy
+	SyncInterval time.Duration
+	SegmentSize  int64
+}
+
+func DefaultOptions(dir string) Options {
+	return Options{
+		Dir:          dir,
+		Sync:         SYNC_INTERVAL,
+		SyncInterval: 100 * time.Millisecond,
+		SegmentSize:  64 << 20,
+	}
+}
+
+// Journal is an append-only log of engine records split into segments. Each
+// record is one JSON line; segment files are named by their first sequence
+// number so a lexical sort is replay order.
+type Journal struct {
+	opts   Options
+	logger *logger.Logger
+
+	mu     sync.Mutex
+	file   *os.File
+	writer *bufio.Writer
+	size   int64
+	seq    uint64
+	dirty  bool
+	closed bool
+	done   chan struct{}
+}
+
+func Open(opts Options, log *logger.Logger) (*Journal, error) {
+	if opts.SegmentSize <= 0 {
+		opts.SegmentSize = DefaultOptions(opts.Dir).SegmentSize
+	}
+	if opts.SyncInterval <= 0 {
+		opts.SyncInterval = DefaultOptions(opts.Dir).SyncInterval
+	}
+	if err := os.MkdirAll(opts.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create journal dir: %w", err)
+	}
+
+	j := &Journal{
+		opts:   opts,
+		logger: log,
+		done:   make(chan struct{}),
+	}
+
+	last, err := j.lastSeq()
+	if err != nil {
+		return nil, err
+	}
+	j.seq = last
+
+	// Always start a fresh segment so a torn tail from a crash is never
+	// appended to.
+	if err := j.openSegment(); err != nil {
+		return nil, err
+	}
+
+	if opts.Sync == SYNC_INTERVAL {
+		go j.syncLoop()
+	}
+
+	log.Info("Journal opened",
+		"dir", opts.Dir,
+		"sync", opts.Sync,
+		"last_seq", last,
+	)
+	return j, nil
+}
+
+func (j *Journal) Append(rec *engine.JournalRecord) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.closed {
+		return fmt.Errorf("journal closed")
+	}
+
+	rec.Seq = j.seq + 1
+	if rec.Time == 0 {
+		rec.Time = time.Now().UnixNano()
+	}
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("encode record: %w", err)
+	}
+	data = append(data, '\n')
+
+	if _, err := j.writer.Write(data); err != nil {
+		return fmt.Errorf("write record: %w", err)
+	}
+	j.seq = rec.Seq
+	j.size += int64(len(data))
+	j.dirty = true
+
+	switch j.opts.Sync {
+	case SYNC_ALWAYS, SYNC_NEVER:
+		if err := j.syncLocked(); err != nil {
+			return err
+		}
+	}
+
+	if j.size >= j.opts.SegmentSize {
+		return j.rotateLocked()
+	}
+	return nil
+}
+
+// LastSeq returns the sequence number of the most recent record.
+func (j *Journal) LastSeq() uint64 {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.seq
+}
+
+func (j *Journal) Sync() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.syncLocked()
+}
+
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.closed {
+		return nil
+	}
+	j.closed = true
+	close(j.done)
+
+	if err := j.syncLocked(); err != nil {
+		return err
+	}
+	return j.file.Close()
+}
+
+// Replay calls fn for every record in every segment, oldest first. A
+// truncated final line in a segment (a write cut short by a crash) is skipped.
+func (j *Journal) Replay(fn func(rec engine.JournalRecord) error) error {
+	return j.ReplayFrom(0, fn)
+}
+
+// ReplayFrom is Replay restricted to records with a sequence number above
+// after. Segments that end at or before after are not read at all.
+func (j *Journal) ReplayFrom(after uint64, fn func(rec engine.JournalRecord) error) error {
+	segments, err := listSegments(j.opts.Dir)
+	if err != nil {
+		return err
+	}
+	return (&Reader{files: segments, logger: j.logger}).ReplayFrom(after, fn)
+}
+
+// AdvanceTo moves the sequence forward so the next record follows seq. It
+// is used when a snapshot is newer than anything left in the journal.
+func (j *Journal) AdvanceTo(seq uint64) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if seq > j.seq {
+		j.seq = seq
+	}
+}
+
+func segmentFirstSeq(path string) uint64 {
+	name := strings.TrimSuffix(filepath.Base(path), segmentExt)
+	seq, err := strconv.ParseUint(name, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return seq
+}
+
+// Reader replays journal files without opening them for writing, for tools
+// that consume a journal directory or a single recorded file.
+type Reader struct {
+	files  []string
+	logger *logger.Logger
+}
+
+func NewReader(path string, log *logger.Logger) (*Reader, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return &Reader{files: []string{path}, logger: log}, nil
+	}
+
+	segments, err := listSegments(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{files: segments, logger: log}, nil
+}
+
+func (r *Reader) Replay(fn func(rec engine.JournalRecord) error) error {
+	return r.ReplayFrom(0, fn)
+}
+
+func (r *Reader) ReplayFrom(after uint64, fn func(rec engine.JournalRecord) error) error {
+	for i, path := range r.files {
+		if i+1 < len(r.files) && segmentFirstSeq(r.files[i+1]) <= after+1 {
+			continue
+		}
+		err := replayFile(path, r.logger, func(rec engine.JournalRecord) error {
+			if rec.Seq <= after {
+				return nil
+			}
+			return fn(rec)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func replayFile(path string, log *logger.Logger, fn func(rec engine.JournalRecord) error) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read segment: %w", err)
+	}
+
+	lines := bytes.Split(data, []byte{'\n'})
+	for i, line := range lines {
+		if len(line) == 0 {
+			continue
+		}
+
+		var rec engine.JournalRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			if i == len(lines)-1 {
+				log.Warn("Skipping torn journal record",
+					"segment", filepath.Base(path),
+					"error", err,
+				)
+				return nil
+			}
+			return fmt.Errorf("segment %s line %d: %w", filepath.Base(path), i+1, err)
+		}
+
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func listSegments(dir string) ([]string, error) {
+	segments, err := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(segments)
+	return segments, nil
+}
+
+func (j *Journal) lastSeq() (uint64, error) {
+	segments, err := listSegments(j.opts.Dir)
+	if err != nil {
+		return 0, err
+	}
+
+	for i := len(segments) - 1; i >= 0; i-- {
+		var last uint64
+		err := replayFile(segments[i], j.logger, func(rec engine.JournalRecord) error {
+			last = rec.Seq
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+		if last > 0 {
+			return last, nil
+		}
+	}
+	return 0, nil
+}
+
+func (j *Journal) openSegment() error {
+	// A segment with this name can only exist if it holds no complete
+	// record, so it is safe to truncate.
+	name := fmt.Sprintf("%020d%s", j.seq+1, segmentExt)
+	file, err := os.OpenFile(filepath.Join(j.opts.Dir, name), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("open segment: %w", err)
+	}
+
+	j.file = file
+	j.writer = bufio.NewWriterSize(file, 64<<10)
+	j.size = 0
+	return nil
+}
+
+func (j *Journal) rotateLocked() error {
+	if err := j.syncLocked(); err != nil {
+		return err
+	}
+	if err := j.file.Close(); err != nil {
+		return fmt.Errorf("close segment: %w", err)
+	}
+	j.logger.Debug("Journal segment rotated", "next_seq", j.seq+1)
+	return j.openSegment()
+}
+
+func (j *Journal) syncLocked() error {
+	if !j.dirty {
+		return nil
+	}
+	if err := j.writer.Flush(); err != nil {
+		return fmt.Errorf("flush journal: %w", err)
+	}
+	if j.opts.Sync != SYNC_NEVER {
+		if err := j.file.Sync(); err != nil {
+			return fmt.Errorf("fsync journal: %w", err)
+		}
+	}
+	j.dirty = false
+	return nil
+}
+
+func (j *Journal) syncLoop() {
+	ticker := time.NewTicker(j.opts.SyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := j.Sync(); err != nil {
+				j.logger.Error("Journal sync failed", "error", err)
+			}
+		case <-j.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package journal_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/AkshatMadhani/nanopulse/engine"
+	"github.com/AkshatMadhani/nanopulse/journal"
+	"github.com/AkshatMadhani/nanopulse/logger"
+)
+
+func replayAll(t *testing.T, j *journal.Journal) []engine.JournalRecord {
+	t.Helper()
+	var records []engine.JournalRecord
+	err := j.Replay(func(rec engine.JournalRecord) error {
+		records = append(records, rec)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+	return records
+}
+
+func TestJournalRotateAndReopen(t *testing.T) {
+	log := logger.New(logger.ERROR)
+	opts := journal.DefaultOptions(t.TempDir())
+	opts.Sync = journal.SYNC_ALWAYS
+	opts.SegmentSize = 512
+
+	j, err := journal.Open(opts, log)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	for i := 0; i < 20; i++ {
+		order := engine.NewOrder("TEST", engine.BUY, engine.PriceFromFloat(100), i+1, "user")
+		if err := j.Append(&engine.JournalRecord{Type: engine.RecordNewOrder, Order: order}); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+	j.Close()
+
+	segments, _ := filepath.Glob(filepath.Join(opts.Dir, "*.wal"))
+	if len(segments) < 2 {
+		t.Errorf("Expected rotation into several segments, got %d", len(segments))
+	}
+
+	j, err = journal.Open(opts, log)
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	defer j.Close()
+
+	if j.LastSeq() != 20 {
+		t.Errorf("Expected last seq 20, got %d", j.LastSeq())
+	}
+
+	records := replayAll(t, j)
+	if len(records) != 20 {
+		t.Fatalf("Expected 20 records, got %d", len(records))
+	}
+	for i, rec := range records {
+		if rec.Seq != uint64(i+1) || rec.Order.Qty != i+1 {
+			t.Errorf("Record %d: seq %d qty %d", i, rec.Seq, rec.Order.Qty)
+		}
+	}
+}
+
+func TestJournalSkipsTornTail(t *testing.T) {
+	log := logger.New(logger.ERROR)
+	opts := journal.DefaultOptions(t.TempDir())
+	opts.Sync = journal.SYNC_ALWAYS
+
+	j, err := journal.Open(opts, log)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	j.Append(&engine.JournalRecord{Type: engine.RecordCancel})
+	j.Close()
+
+	segments, _ := filepath.Glob(filepath.Join(opts.Dir, "*.wal"))
+	f, err := os.OpenFile(segments[0], os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"seq":2,"type":"can`)
+	f.Close()
+
+	j, err = journal.Open(opts, log)
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	defer j.Close()
+
+	if records := replayAll(t, j); len(records) != 1 {
+		t.Errorf("Expected torn record to be skipped, got %d records", len(records))
+	}
+	if j.LastSeq() != 1 {
+		t.Errorf("Expected last seq 1, got %d", j.LastSeq())
+	}
+}
@@ -1,19 +1,29 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/AkshatMadhani/nanopulse/api"
+	"github.com/AkshatMadhani/nanopulse/config"
 	"github.com/AkshatMadhani/nanopulse/engine"
+	"github.com/AkshatMadhani/nanopulse/journal"
 	"github.com/AkshatMadhani/nanopulse/logger"
 	"github.com/AkshatMadhani/nanopulse/market"
 	"github.com/AkshatMadhani/nanopulse/monitor"
+	"github.com/AkshatMadhani/nanopulse/positions"
+	"github.com/AkshatMadhani/nanopulse/risk"
 	"github.com/AkshatMadhani/nanopulse/simulator"
+	"github.com/AkshatMadhani/nanopulse/snapshot"
 )
 
+// shutdownTimeout bounds how long in-flight requests get to finish.
+const shutdownTimeout = 10 * time.Second
+
 type TradeBroadcaster struct {
 	input  <-chan *engine.Trade
 	output []chan *engine.Trade
@@ -42,7 +52,7 @@ func (tb *TradeBroadcaster) Start() {
 					select {
 					case ch <- trade:
 					default:
-						tb.logger.Warn("Trade channel full, dropping trade", "channel", i)
+						tb.logger.Warn("Trade channel full, dropping trade", "channel", i, "seq", trade.Seq)
 					}
 				}
 			}
@@ -65,6 +75,7 @@ func main() {
 	logLevel := flag.String("log-level", "info", "Log level (debug, info, warn, error)")
 	enableSimulator := flag.Bool("simulator", false, "Enable market simulator")
 	simRate := flag.Int("sim-rate", 10, "Simulator orders per second")
+	configPath := flag.String("config", "configs/config.yaml", "Path to config file")
 	flag.Parse()
 
 	level := logger.INFO
@@ -85,10 +96,115 @@ func main() {
 		"simulator_enabled", *enableSimulator,
 	)
 
-	matchingEngine := engine.NewMatchingEngine(10000, log)
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Error("Failed to load config", "path", *configPath, "error", err)
+		os.Exit(1)
+	}
+
+	matchingEngine := engine.NewShardedMatchingEngine(
+		cfg.MatchingEngine.OrderBufferSize,
+		cfg.MatchingEngine.Shards,
+		log,
+	)
+	matchingEngine.SetOrderRetention(cfg.MatchingEngine.RetainedOrders)
+	for _, instCfg := range cfg.Instruments {
+		inst, err := instCfg.Instrument()
+		if err != nil {
+			log.Error("Invalid instrument in config", "error", err)
+			os.Exit(1)
+		}
+		if err := matchingEngine.SetInstrument(inst); err != nil {
+			log.Error("Invalid instrument in config", "symbol", inst.Symbol, "error", err)
+			os.Exit(1)
+		}
+	}
+	log.Info("Instruments loaded", "count", len(cfg.Instruments))
+	if err := cfg.ApplySelfTradeModes(matchingEngine); err != nil {
+		log.Error("Invalid self-trade prevention config", "error", err)
+		os.Exit(1)
+	}
+	matchingEngine.SetAccountPolicy(cfg.Accounts.Enforce, cfg.Accounts.Exempt)
+	if err := matchingEngine.SetFeeSchedule(cfg.Fees.Schedule()); err != nil {
+		log.Error("Invalid fee config", "error", err)
+		os.Exit(1)
+	}
+	if err := matchingEngine.SetCircuitBreakers(cfg.CircuitBreakers.Breakers()); err != nil {
+		log.Error("Invalid circuit breaker config", "error", err)
+		os.Exit(1)
+	}
+	if cfg.Session.Enabled {
+		calendar, err := cfg.Session.Calendar()
+		if err == nil {
+			err = matchingEngine.SetCalendar(calendar)
+		}
+		if err != nil {
+			log.Error("Invalid session config", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	riskGateway := risk.NewGateway(matchingEngine)
+	if err := riskGateway.SetLimits(cfg.Risk.Limits()); err != nil {
+		log.Error("Invalid risk config", "error", err)
+		os.Exit(1)
+	}
+
+	var after uint64
+	if cfg.Snapshot.Enabled {
+		state, path, err := snapshot.LoadLatest(cfg.Snapshot.Dir, log)
+		if err != nil {
+			log.Error("Failed to load snapshot", "dir", cfg.Snapshot.Dir, "error", err)
+			os.Exit(1)
+		}
+		if state != nil {
+			matchingEngine.Restore(*state)
+			after = state.Seq
+			log.Info("Snapshot restored",
+				"path", path,
+				"seq", state.Seq,
+				"orders", state.OrderCount(),
+			)
+		}
+	}
+
+	var wal *journal.Journal
+	if cfg.Journal.Enabled {
+		opts, err := cfg.Journal.Options()
+		if err != nil {
+			log.Error("Invalid journal config", "error", err)
+			os.Exit(1)
+		}
+		wal, err = journal.Open(opts, log)
+		if err != nil {
+			log.Error("Failed to open journal", "dir", opts.Dir, "error", err)
+			os.Exit(1)
+		}
+		replayed, err := matchingEngine.RecoverFrom(wal, after)
+		if err != nil {
+			log.Error("Journal replay failed", "error", err)
+			os.Exit(1)
+		}
+		wal.AdvanceTo(after)
+		log.Info("Journal replayed", "after_seq", after, "commands", replayed)
+		matchingEngine.SetJournal(wal)
+	}
+	if err := cfg.OpenAccounts(matchingEngine); err != nil {
+		log.Error("Failed to open accounts", "error", err)
+		os.Exit(1)
+	}
+	// The keeper books trades from the engine's current seq, so it must
+	// exist before the first session phase can uncross a book.
+	tradeBroadcaster := NewTradeBroadcaster(matchingEngine.GetTradeChan(), 4, log)
+	positionKeeper := positions.NewKeeper(matchingEngine, tradeBroadcaster.GetChannel(3), log)
 	matchingEngine.Start()
 
-	tradeBroadcaster := NewTradeBroadcaster(matchingEngine.GetTradeChan(), 3, log)
+	var snapshots *snapshot.Manager
+	if cfg.Snapshot.Enabled {
+		snapshots = snapshot.NewManager(cfg.Snapshot.Dir, cfg.Snapshot.Keep, matchingEngine, log)
+		snapshots.Start(time.Duration(cfg.Snapshot.IntervalSec) * time.Second)
+	}
+
 	tradeBroadcaster.Start()
 
 	monitorConfig := monitor.DefaultConfig()
@@ -103,15 +219,19 @@ func main() {
 	selfHealer := monitor.NewSelfHealer(systemMonitor, matchingEngine, log)
 	selfHealer.Start()
 
+	positionKeeper.Start()
+
 	marketMaker := market.NewBot(
 		matchingEngine,
 		tradeBroadcaster.GetChannel(1),
+		positionKeeper,
 		log,
 	)
 	marketMaker.Start()
 
+	var sim *simulator.Simulator
 	if *enableSimulator {
-		sim := simulator.NewSimulator(matchingEngine, log, *simRate)
+		sim = simulator.NewSimulator(matchingEngine, log, *simRate)
 		sim.Start()
 		log.Info("Market simulator started", "rate", *simRate)
 	}
@@ -121,8 +241,15 @@ func main() {
 		systemMonitor,
 		marketMaker,
 		selfHealer,
+		positionKeeper,
+		riskGateway,
+		tradeBroadcaster.GetChannel(2),
 		log,
 	)
+	apiServer.SetAdminToken(cfg.Server.AdminToken)
+	if snapshots != nil {
+		apiServer.SetSnapshotter(snapshots)
+	}
 
 	go func() {
 		log.Info("API server listening", "port", *port)
@@ -136,5 +263,28 @@ func main() {
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 	<-sigChan
 
+	// Stop everything that feeds the engine before draining it, so every
+	// order that was accepted is journaled and nothing arrives after.
+	log.Info("Shutting down")
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	if err := apiServer.Shutdown(ctx); err != nil {
+		log.Error("API server shutdown failed", "error", err)
+	}
+	cancel()
+	if sim != nil {
+		sim.Stop()
+	}
+	marketMaker.Stop()
+	selfHealer.Stop()
+	if snapshots != nil {
+		snapshots.Stop()
+	}
+	matchingEngine.Stop()
+	if wal != nil {
+		if err := wal.Close(); err != nil {
+			log.Error("Failed to close journal", "error", err)
+		}
+	}
+
 	log.Info("Final stats", "monitor", systemMonitor.GetStats())
 }
@@ -7,34 +7,50 @@ import (
 
 	"github.com/AkshatMadhani/nanopulse/engine"
 	"github.com/AkshatMadhani/nanopulse/logger"
+	"github.com/AkshatMadhani/nanopulse/positions"
 )
 
+// UserID is the account the bot quotes from.
+const UserID = "market-maker"
+
 type Bot struct {
 	engine       *engine.MatchingEngine
 	tradeChan    <-chan *engine.Trade
+	positions    *positions.Keeper
 	logger       *logger.Logger
-	profit       float64
-	profitMu     sync.RWMutex
 	totalOrders  int64
+	quotedQty    int64
+	filledQty    int64
 	activeOrders map[string]bool
 	mu           sync.Mutex
+	quit         chan struct{}
+	wg           sync.WaitGroup
 }
 
-func NewBot(eng *engine.MatchingEngine, tradeChan <-chan *engine.Trade, log *logger.Logger) *Bot {
+func NewBot(eng *engine.MatchingEngine, tradeChan <-chan *engine.Trade, pos *positions.Keeper, log *logger.Logger) *Bot {
 	return &Bot{
 		engine:       eng,
 		tradeChan:    tradeChan,
+		positions:    pos,
 		logger:       log,
 		activeOrders: make(map[string]bool),
+		quit:         make(chan struct{}),
 	}
 }
 
 func (b *Bot) Start() {
 	b.logger.Info("Starting market maker bot")
 	go b.trackTrades()
+	b.wg.Add(1)
 	go b.provideQuotes()
 }
 
+// Stop ends quoting and waits for any quote in flight to be sent.
+func (b *Bot) Stop() {
+	close(b.quit)
+	b.wg.Wait()
+}
+
 func (b *Bot) trackTrades() {
 	for trade := range b.tradeChan {
 		b.logger.Debug("Trade observed",
@@ -42,60 +58,90 @@ func (b *Bot) trackTrades() {
 			"price", trade.Price,
 			"qty", trade.Qty,
 		)
+		if trade.Buyer == UserID || trade.Seller == UserID {
+			b.mu.Lock()
+			for _, user := range []string{trade.Buyer, trade.Seller} {
+				if user == UserID {
+					b.filledQty += int64(trade.Qty)
+				}
+			}
+			b.mu.Unlock()
+			b.logger.Info("Market maker filled",
+				"symbol", trade.Symbol,
+				"price", trade.Price,
+				"qty", trade.Qty,
+			)
+		}
 	}
 }
 
 func (b *Bot) provideQuotes() {
+	defer b.wg.Done()
 	ticker := time.NewTicker(time.Second * 3)
 	defer ticker.Stop()
 
-	symbols := []string{"RELIANCE", "TCS", "INFY"}
-
-	for range ticker.C {
-		symbol := symbols[rand.Intn(len(symbols))]
-		b.makeMarket(symbol)
+	for {
+		select {
+		case <-ticker.C:
+		case <-b.quit:
+			return
+		}
+		instruments := b.engine.Instruments().Active()
+		if len(instruments) == 0 {
+			continue
+		}
+		inst := instruments[rand.Intn(len(instruments))]
+		b.makeMarket(inst)
 	}
 }
 
-func (b *Bot) makeMarket(symbol string) {
+func (b *Bot) makeMarket(inst engine.Instrument) {
+	symbol := inst.Symbol
 	book := b.engine.GetBook(symbol)
 	if book == nil {
-		b.createInitialQuotes(symbol, 2500.0)
+		b.createInitialQuotes(symbol, inst.ReferencePrice)
 		return
 	}
 
 	bestBid := book.GetBestBid()
 	bestAsk := book.GetBestAsk()
 
-	var fairValue float64
+	var fairValue engine.Price
 	if bestBid != nil && bestAsk != nil {
 		fairValue = (*bestBid + *bestAsk) / 2
 	} else if bestBid != nil {
-		fairValue = *bestBid + 1.0
+		fairValue = *bestBid + engine.PriceFromFloat(1.0)
 	} else if bestAsk != nil {
-		fairValue = *bestAsk - 1.0
+		fairValue = *bestAsk - engine.PriceFromFloat(1.0)
 	} else {
-		fairValue = 2500.0
+		fairValue = inst.ReferencePrice
 	}
 
-	spread := 2.0
-	bidPrice := fairValue - spread/2
-	askPrice := fairValue + spread/2
+	// The bot only posts, so it always pays the maker rate. A rebate lets it
+	// quote tighter and a fee pushes it wider, never inside one tick.
+	rates := b.engine.FeeRates(UserID, symbol)
+	halfSpread := engine.PriceFromFloat(1.0) + engine.Price(float64(fairValue)*rates.MakerBps/10000)
+	halfSpread = max(halfSpread, inst.TickSize)
+	bidPrice := inst.RoundToTick(fairValue - halfSpread)
+	askPrice := inst.RoundToTick(fairValue + halfSpread)
 
 	b.placeQuote(symbol, engine.BUY, bidPrice, 10)
 	b.placeQuote(symbol, engine.SELL, askPrice, 10)
 }
-func (b *Bot) createInitialQuotes(symbol string, basePrice float64) {
-	spread := 2.0
+func (b *Bot) createInitialQuotes(symbol string, basePrice engine.Price) {
+	spread := engine.PriceFromFloat(2.0)
 
-	buyOrder := engine.NewOrder(symbol, engine.BUY, basePrice-spread/2, 10, "market-maker")
-	sellOrder := engine.NewOrder(symbol, engine.SELL, basePrice+spread/2, 10, "market-maker")
+	buyOrder := b.engine.NewOrder(symbol, engine.BUY, basePrice-spread/2, 10, UserID)
+	sellOrder := b.engine.NewOrder(symbol, engine.SELL, basePrice+spread/2, 10, UserID)
+	buyOrder.PostOnly = engine.POST_ONLY_SLIDE
+	sellOrder.PostOnly = engine.POST_ONLY_SLIDE
 
 	b.engine.GetOrderChan() <- buyOrder
 	b.engine.GetOrderChan() <- sellOrder
 
 	b.mu.Lock()
 	b.totalOrders += 2
+	b.quotedQty += int64(buyOrder.Qty + sellOrder.Qty)
 	b.mu.Unlock()
 
 	b.logger.Info("Created initial quotes",
@@ -104,13 +150,15 @@ func (b *Bot) createInitialQuotes(symbol string, basePrice float64) {
 		"ask", basePrice+spread/2,
 	)
 }
-func (b *Bot) placeQuote(symbol string, side engine.Side, price float64, qty int) {
-	order := engine.NewOrder(symbol, side, price, qty, "market-maker")
+func (b *Bot) placeQuote(symbol string, side engine.Side, price engine.Price, qty int) {
+	order := b.engine.NewOrder(symbol, side, price, qty, UserID)
+	order.PostOnly = engine.POST_ONLY_SLIDE
 
 	b.engine.GetOrderChan() <- order
 
 	b.mu.Lock()
 	b.totalOrders++
+	b.quotedQty += int64(qty)
 	b.mu.Unlock()
 
 	b.logger.Debug("Market maker quote",
@@ -121,35 +169,36 @@ func (b *Bot) placeQuote(symbol string, side engine.Side, price float64, qty int
 	)
 }
 
+// GetProfit is the bot's realized plus unrealized PnL across all symbols.
 func (b *Bot) GetProfit() float64 {
-	b.profitMu.RLock()
-	defer b.profitMu.RUnlock()
-	return b.profit
-}
-
-func (b *Bot) AddProfit(amount float64) {
-	b.profitMu.Lock()
-	b.profit += amount
-	b.profitMu.Unlock()
-
-	b.logger.Info("Market maker profit",
-		"amount", amount,
-		"total", b.profit,
-	)
+	if b.positions == nil {
+		return 0
+	}
+	return b.positions.Get(UserID).TotalPnL.Float()
 }
 
 func (b *Bot) GetStats() Stats {
 	b.mu.Lock()
-	totalOrders := b.totalOrders
+	stats := Stats{
+		TotalOrders: b.totalOrders,
+		QuotedQty:   b.quotedQty,
+		FilledQty:   b.filledQty,
+	}
 	b.mu.Unlock()
 
-	return Stats{
-		Profit:      b.GetProfit(),
-		TotalOrders: totalOrders,
+	stats.Profit = b.GetProfit()
+	if stats.QuotedQty > 0 {
+		stats.FillRate = float64(stats.FilledQty) / float64(stats.QuotedQty)
 	}
+	return stats
 }
 
+// Stats are the bot's totals since it started. FillRate is the share of
+// the quantity it quoted that traded, for comparing matching policies.
 type Stats struct {
 	Profit      float64 `json:"profit"`
 	TotalOrders int64   `json:"total_orders"`
+	QuotedQty   int64   `json:"quoted_qty"`
+	FilledQty   int64   `json:"filled_qty"`
+	FillRate    float64 `json:"fill_rate"`
 }
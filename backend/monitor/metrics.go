@@ -1,6 +1,7 @@
 package monitor
 
 import (
+	"sort"
 	"sync"
 	"time"
 
@@ -34,6 +35,7 @@ type Monitor struct {
 	latencyWindowMu  sync.RWMutex
 	avgLatency       float64
 	maxLatency       float64
+	shardLatency     map[int]*shardLatency
 	queueDepth       int
 	currentMode      SystemMode
 	modeMu           sync.RWMutex
@@ -42,6 +44,7 @@ type Monitor struct {
 	logger           *logger.Logger
 	config           Config
 	totalTrades      int64
+	fees             FeeStats
 	safeModeTriggers int64
 	throttleCount    int64
 	mu               sync.RWMutex
@@ -68,6 +71,7 @@ func DefaultConfig() Config {
 func NewMonitor(tradeChan <-chan *engine.Trade, metricsChan <-chan engine.Metric, log *logger.Logger, cfg Config) *Monitor {
 	return &Monitor{
 		latencyWindow: make([]float64, 0, cfg.WindowSize),
+		shardLatency:  make(map[int]*shardLatency),
 		tradeChan:     tradeChan,
 		metricsChan:   metricsChan,
 		logger:        log,
@@ -88,6 +92,7 @@ func (m *Monitor) collectMetrics() {
 		switch metric.Type {
 		case "latency":
 			m.recordLatency(metric.Value)
+			m.recordShardLatency(metric.Shard, metric.Value)
 		}
 	}
 }
@@ -95,6 +100,7 @@ func (m *Monitor) collectTrades() {
 	for trade := range m.tradeChan {
 		if trade != nil {
 			m.IncrementTrades()
+			m.recordFees(trade)
 			m.logger.Debug("Monitor tracked trade",
 				"id", trade.ID.String(),
 				"symbol", trade.Symbol,
@@ -126,6 +132,33 @@ func (m *Monitor) recordLatency(latencyUs float64) {
 	m.avgLatency = sum / float64(len(m.latencyWindow))
 }
 
+type shardLatency struct {
+	window []float64
+	sum    float64
+	max    float64
+}
+
+func (m *Monitor) recordShardLatency(shard int, latencyUs float64) {
+	m.latencyWindowMu.Lock()
+	defer m.latencyWindowMu.Unlock()
+
+	sl, exists := m.shardLatency[shard]
+	if !exists {
+		sl = &shardLatency{window: make([]float64, 0, m.config.WindowSize)}
+		m.shardLatency[shard] = sl
+	}
+
+	sl.window = append(sl.window, latencyUs)
+	sl.sum += latencyUs
+	if len(sl.window) > m.config.WindowSize {
+		sl.sum -= sl.window[0]
+		sl.window = sl.window[1:]
+	}
+	if latencyUs > sl.max {
+		sl.max = latencyUs
+	}
+}
+
 func (m *Monitor) checkHealth() {
 	ticker := time.NewTicker(m.config.CheckInterval)
 	defer ticker.Stop()
@@ -177,7 +210,16 @@ func (m *Monitor) GetStats() Stats {
 	m.latencyWindowMu.RLock()
 	avgLatency := m.avgLatency
 	maxLatency := m.maxLatency
+	shards := make([]ShardStats, 0, len(m.shardLatency))
+	for id, sl := range m.shardLatency {
+		shards = append(shards, ShardStats{
+			Shard:        id,
+			AvgLatencyUs: sl.sum / float64(len(sl.window)),
+			MaxLatencyUs: sl.max,
+		})
+	}
 	m.latencyWindowMu.RUnlock()
+	sort.Slice(shards, func(i, j int) bool { return shards[i].Shard < shards[j].Shard })
 
 	m.mu.RLock()
 	defer m.mu.RUnlock()
@@ -189,16 +231,52 @@ func (m *Monitor) GetStats() Stats {
 		SafeModeTriggers: m.safeModeTriggers,
 		ThrottleCount:    m.throttleCount,
 		CurrentMode:      m.GetMode(),
+		Shards:           shards,
 	}
 }
 
 type Stats struct {
-	AvgLatencyUs     float64    `json:"avg_latency_us"`
-	MaxLatencyUs     float64    `json:"max_latency_us"`
-	TotalTrades      int64      `json:"total_trades"`
-	SafeModeTriggers int64      `json:"safe_mode_triggers"`
-	ThrottleCount    int64      `json:"throttle_count"`
-	CurrentMode      SystemMode `json:"current_mode"`
+	AvgLatencyUs     float64      `json:"avg_latency_us"`
+	MaxLatencyUs     float64      `json:"max_latency_us"`
+	TotalTrades      int64        `json:"total_trades"`
+	SafeModeTriggers int64        `json:"safe_mode_triggers"`
+	ThrottleCount    int64        `json:"throttle_count"`
+	CurrentMode      SystemMode   `json:"current_mode"`
+	Shards           []ShardStats `json:"shards"`
+}
+
+// FeeStats sums the fees on trades seen since start. Rebates are the
+// negative maker fees, as a positive amount; Net is what the venue kept.
+type FeeStats struct {
+	MakerFees engine.Price `json:"maker_fees"`
+	TakerFees engine.Price `json:"taker_fees"`
+	Rebates   engine.Price `json:"rebates"`
+	Net       engine.Price `json:"net"`
+}
+
+func (m *Monitor) recordFees(trade *engine.Trade) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if trade.MakerFee < 0 {
+		m.fees.Rebates -= trade.MakerFee
+	} else {
+		m.fees.MakerFees += trade.MakerFee
+	}
+	m.fees.TakerFees += trade.TakerFee
+	m.fees.Net += trade.MakerFee + trade.TakerFee
+}
+
+func (m *Monitor) GetFeeStats() FeeStats {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.fees
+}
+
+type ShardStats struct {
+	Shard        int     `json:"shard"`
+	AvgLatencyUs float64 `json:"avg_latency_us"`
+	MaxLatencyUs float64 `json:"max_latency_us"`
 }
 
 func (m *Monitor) IncrementTrades() {
@@ -1,6 +1,7 @@
 package monitor
 
 import (
+	"sync"
 	"time"
 
 	"github.com/AkshatMadhani/nanopulse/engine"
@@ -12,12 +13,14 @@ type SelfHealer struct {
 	engine       *engine.MatchingEngine
 	logger       *logger.Logger
 	injectionLog []LiquidityInjection
+	quit         chan struct{}
+	wg           sync.WaitGroup
 }
 
 type LiquidityInjection struct {
 	Symbol    string
 	Side      engine.Side
-	Price     float64
+	Price     engine.Price
 	Qty       int
 	Reason    string
 	Timestamp int64
@@ -29,22 +32,38 @@ func NewSelfHealer(mon *Monitor, eng *engine.MatchingEngine, log *logger.Logger)
 		engine:       eng,
 		logger:       log,
 		injectionLog: make([]LiquidityInjection, 0),
+		quit:         make(chan struct{}),
 	}
 }
 
 func (sh *SelfHealer) Start() {
 	sh.logger.Info("Starting self-healing system")
+	sh.wg.Add(1)
 	go sh.monitorLiquidity()
 }
 
+// Stop ends liquidity checks and waits for any injection in flight.
+func (sh *SelfHealer) Stop() {
+	close(sh.quit)
+	sh.wg.Wait()
+}
+
 func (sh *SelfHealer) monitorLiquidity() {
+	defer sh.wg.Done()
 	ticker := time.NewTicker(time.Second * 5)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		book := sh.engine.GetBook("RELIANCE")
-		if book != nil {
-			sh.checkAndInjectLiquidity(book)
+	for {
+		select {
+		case <-ticker.C:
+		case <-sh.quit:
+			return
+		}
+		for _, symbol := range sh.engine.Instruments().Symbols() {
+			book := sh.engine.GetBook(symbol)
+			if book != nil {
+				sh.checkAndInjectLiquidity(book)
+			}
 		}
 	}
 }
@@ -66,7 +85,7 @@ func (sh *SelfHealer) checkAndInjectLiquidity(book *engine.OrderBook) {
 	if bestBid != nil && bestAsk != nil {
 		spread := *bestAsk - *bestBid
 		midPrice := (*bestBid + *bestAsk) / 2
-		spreadPct := (spread / midPrice) * 100
+		spreadPct := (spread.Float() / midPrice.Float()) * 100
 
 		if spreadPct > 1.0 {
 			sh.logger.Warn("Wide spread detected",
@@ -77,11 +96,11 @@ func (sh *SelfHealer) checkAndInjectLiquidity(book *engine.OrderBook) {
 	}
 }
 
-func (sh *SelfHealer) injectSellOrder(book *engine.OrderBook, basedOnBid float64) {
-	price := basedOnBid + 2.0
+func (sh *SelfHealer) injectSellOrder(book *engine.OrderBook, basedOnBid engine.Price) {
+	price := basedOnBid + engine.PriceFromFloat(2.0)
 	qty := 5
 
-	order := engine.NewOrder(book.Symbol, engine.SELL, price, qty, "self-healer")
+	order := sh.engine.NewOrder(book.Symbol, engine.SELL, price, qty, "self-healer")
 
 	sh.logger.Info("Injecting liquidity - SELL",
 		"symbol", book.Symbol,
@@ -101,11 +120,11 @@ func (sh *SelfHealer) injectSellOrder(book *engine.OrderBook, basedOnBid float64
 	})
 }
 
-func (sh *SelfHealer) injectBuyOrder(book *engine.OrderBook, basedOnAsk float64) {
-	price := basedOnAsk - 2.0
+func (sh *SelfHealer) injectBuyOrder(book *engine.OrderBook, basedOnAsk engine.Price) {
+	price := basedOnAsk - engine.PriceFromFloat(2.0)
 	qty := 5
 
-	order := engine.NewOrder(book.Symbol, engine.BUY, price, qty, "self-healer")
+	order := sh.engine.NewOrder(book.Symbol, engine.BUY, price, qty, "self-healer")
 
 	sh.logger.Info("Injecting liquidity - BUY",
 		"symbol", book.Symbol,
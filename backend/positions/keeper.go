@@ -0,0 +1,227 @@
+package positions
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/AkshatMadhani/nanopulse/engine"
+	"github.com/AkshatMadhani/nanopulse/logger"
+)
+
+// Position is one user's net holding in a symbol. Qty is negative when
+// short. AvgCost is the average entry price of the open quantity, and Mark
+// the price it is valued at: the mid if the book has both sides, otherwise
+// the last trade.
+type Position struct {
+	Symbol        string       `json:"symbol"`
+	Qty           int          `json:"qty"`
+	AvgCost       engine.Price `json:"avg_cost"`
+	Mark          engine.Price `json:"mark"`
+	RealizedPnL   engine.Price `json:"realized_pnl"`
+	UnrealizedPnL engine.Price `json:"unrealized_pnl"`
+}
+
+type Summary struct {
+	User          string       `json:"user"`
+	Positions     []Position   `json:"positions"`
+	RealizedPnL   engine.Price `json:"realized_pnl"`
+	UnrealizedPnL engine.Price `json:"unrealized_pnl"`
+	TotalPnL      engine.Price `json:"total_pnl"`
+}
+
+// position keeps the open quantity's total entry cost rather than its
+// average, so partial closes do not compound rounding.
+type position struct {
+	qty      int
+	cost     engine.Price
+	realized engine.Price
+}
+
+// catchUpBatch is how many events the keeper reads from the event log at a
+// time.
+const catchUpBatch = 1024
+
+// Keeper builds every user's positions from the engine's trades. It holds
+// what has traded since it was created; positions are not persisted.
+type Keeper struct {
+	engine    *engine.MatchingEngine
+	tradeChan <-chan *engine.Trade
+	seq       uint64 // last event read from the engine
+	updates   chan Summary
+	logger    *logger.Logger
+	users     map[string]map[string]*position
+	lastPrice map[string]engine.Price
+	mu        sync.RWMutex
+}
+
+func NewKeeper(eng *engine.MatchingEngine, tradeChan <-chan *engine.Trade, log *logger.Logger) *Keeper {
+	return &Keeper{
+		engine:    eng,
+		tradeChan: tradeChan,
+		seq:       eng.LastSeq(),
+		updates:   make(chan Summary, 1000),
+		logger:    log,
+		users:     make(map[string]map[string]*position),
+		lastPrice: make(map[string]engine.Price),
+	}
+}
+
+func (k *Keeper) Start() {
+	k.logger.Info("Starting position keeper")
+	go k.trackTrades()
+}
+
+// trackTrades books trades in Seq order. The trade feed can drop trades or
+// deliver them out of order, so a trade received only says how far to read:
+// the keeper books every trade up to it from the engine's event log.
+func (k *Keeper) trackTrades() {
+	for trade := range k.tradeChan {
+		for _, booked := range k.catchUp(trade.Seq) {
+			k.publish(booked)
+		}
+	}
+	close(k.updates)
+}
+
+// catchUp books the trades among the events after the last one read, up to
+// seq, and returns them.
+func (k *Keeper) catchUp(seq uint64) []*engine.Trade {
+	var booked []*engine.Trade
+	for k.seq < seq {
+		events, first, _ := k.engine.Events(k.seq+1, seq, "", catchUpBatch)
+		if first > k.seq+1 {
+			k.logger.Error("Trades dropped from the event log before positions booked them",
+				"from_seq", k.seq+1,
+				"first_seq", first,
+			)
+		}
+		if len(events) == 0 {
+			k.seq = seq
+			break
+		}
+		for _, ev := range events {
+			if ev.Type == engine.EVENT_TRADE {
+				k.Apply(ev.Trade)
+				booked = append(booked, ev.Trade)
+			}
+			k.seq = ev.Seq
+		}
+	}
+	return booked
+}
+
+func (k *Keeper) publish(trade *engine.Trade) {
+	users := []string{trade.Buyer}
+	if trade.Seller != trade.Buyer {
+		users = append(users, trade.Seller)
+	}
+	for _, user := range users {
+		select {
+		case k.updates <- k.Get(user):
+		default:
+			k.logger.Debug("Position update feed full", "user", user)
+		}
+	}
+}
+
+// Updates carries a user's summary after every trade they take part in.
+func (k *Keeper) Updates() <-chan Summary {
+	return k.updates
+}
+
+// Apply books both sides of a trade. Fees paid come off realized PnL and
+// rebates add to it.
+func (k *Keeper) Apply(trade *engine.Trade) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.lastPrice[trade.Symbol] = trade.Price
+	buyer := k.position(trade.Buyer, trade.Symbol)
+	buyer.fill(trade.Price, trade.Qty)
+	buyer.realized -= trade.BuyerFee()
+	seller := k.position(trade.Seller, trade.Symbol)
+	seller.fill(trade.Price, -trade.Qty)
+	seller.realized -= trade.SellerFee()
+}
+
+func (k *Keeper) position(user, symbol string) *position {
+	symbols, exists := k.users[user]
+	if !exists {
+		symbols = make(map[string]*position)
+		k.users[user] = symbols
+	}
+	pos, exists := symbols[symbol]
+	if !exists {
+		pos = &position{}
+		symbols[symbol] = pos
+	}
+	return pos
+}
+
+// fill adds delta at price, positive for a buy. Whatever reduces the open
+// quantity realizes PnL against its average cost; any excess opens a new
+// position the other way.
+func (p *position) fill(price engine.Price, delta int) {
+	if p.qty != 0 && (p.qty > 0) != (delta > 0) {
+		side := sign(p.qty)
+		closing := min(abs(delta), abs(p.qty))
+		// cost * closing / |qty| without overflowing the product.
+		open := engine.Price(abs(p.qty))
+		portion := p.cost/open*engine.Price(closing) + p.cost%open*engine.Price(closing)/open
+		p.realized += price*engine.Price(closing*side) - portion
+		p.cost -= portion
+		p.qty -= closing * side
+		delta += closing * side
+	}
+	p.qty += delta
+	p.cost += price * engine.Price(delta)
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func sign(n int) int {
+	if n < 0 {
+		return -1
+	}
+	return 1
+}
+
+// Get returns user's positions marked to the current book.
+func (k *Keeper) Get(user string) Summary {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	summary := Summary{User: user, Positions: make([]Position, 0, len(k.users[user]))}
+	for symbol, pos := range k.users[user] {
+		p := Position{Symbol: symbol, Qty: pos.qty, RealizedPnL: pos.realized}
+		if pos.qty != 0 {
+			p.AvgCost = pos.cost / engine.Price(pos.qty)
+			p.Mark = k.mark(symbol)
+			p.UnrealizedPnL = p.Mark*engine.Price(pos.qty) - pos.cost
+		}
+		summary.Positions = append(summary.Positions, p)
+		summary.RealizedPnL += p.RealizedPnL
+		summary.UnrealizedPnL += p.UnrealizedPnL
+	}
+	summary.TotalPnL = summary.RealizedPnL + summary.UnrealizedPnL
+
+	sort.Slice(summary.Positions, func(i, j int) bool {
+		return summary.Positions[i].Symbol < summary.Positions[j].Symbol
+	})
+	return summary
+}
+
+func (k *Keeper) mark(symbol string) engine.Price {
+	if book := k.engine.GetBook(symbol); book != nil {
+		bid, ask := book.GetBestBid(), book.GetBestAsk()
+		if bid != nil && ask != nil {
+			return (*bid + *ask) / 2
+		}
+	}
+	return k.lastPrice[symbol]
+}
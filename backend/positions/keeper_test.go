@@ -0,0 +1,127 @@
+package positions_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/AkshatMadhani/nanopulse/engine"
+	"github.com/AkshatMadhani/nanopulse/logger"
+	"github.com/AkshatMadhani/nanopulse/positions"
+	"github.com/google/uuid"
+)
+
+func px(f float64) engine.Price {
+	return engine.PriceFromFloat(f)
+}
+
+func trade(buyer, seller string, price float64, qty int) *engine.Trade {
+	return &engine.Trade{Symbol: "TEST", Buyer: buyer, Seller: seller, Price: px(price), Qty: qty}
+}
+
+func TestKeeperRealizedAndUnrealized(t *testing.T) {
+	log := logger.New(logger.ERROR)
+	k := positions.NewKeeper(engine.NewMatchingEngine(10, log), nil, log)
+
+	steps := []struct {
+		trade      *engine.Trade
+		qty        int
+		avgCost    engine.Price
+		realized   engine.Price
+		unrealized engine.Price
+	}{
+		{trade("alice", "bob", 100, 10), 10, px(100), 0, 0},
+		{trade("alice", "bob", 110, 10), 20, px(105), 0, px(100)},
+		{trade("bob", "alice", 120, 15), 5, px(105), px(225), px(75)},
+		// Sells through flat: closes 5 at a loss and opens a short.
+		{trade("bob", "alice", 100, 10), -5, px(100), px(200), 0},
+		{trade("alice", "bob", 90, 5), 0, 0, px(250), 0},
+	}
+
+	for i, step := range steps {
+		k.Apply(step.trade)
+		alice := k.Get("alice")
+		if len(alice.Positions) != 1 {
+			t.Fatalf("Step %d: expected one position, got %+v", i, alice.Positions)
+		}
+		pos := alice.Positions[0]
+		if pos.Qty != step.qty || pos.AvgCost != step.avgCost || pos.RealizedPnL != step.realized || pos.UnrealizedPnL != step.unrealized {
+			t.Errorf("Step %d: expected qty %d avg %v realized %v unrealized %v, got %+v",
+				i, step.qty, step.avgCost, step.realized, step.unrealized, pos)
+		}
+
+		bob := k.Get("bob")
+		if alice.TotalPnL+bob.TotalPnL != 0 {
+			t.Errorf("Step %d: PnL should net to zero, alice %v bob %v", i, alice.TotalPnL, bob.TotalPnL)
+		}
+	}
+}
+
+func TestKeeperMarksToMid(t *testing.T) {
+	log := logger.New(logger.ERROR)
+	eng := engine.NewMatchingEngine(10, log)
+	eng.SetInstrument(engine.DefaultInstrument("TEST"))
+	book := eng.GetOrCreateBook("TEST")
+	book.AddOrder(engine.NewOrder("TEST", engine.BUY, px(101), 1, "mm"))
+	book.AddOrder(engine.NewOrder("TEST", engine.SELL, px(105), 1, "mm"))
+
+	k := positions.NewKeeper(eng, nil, log)
+	k.Apply(trade("alice", "bob", 100, 10))
+
+	pos := k.Get("alice").Positions[0]
+	if pos.Mark != px(103) || pos.UnrealizedPnL != px(30) {
+		t.Errorf("Expected mark at mid 103 and unrealized 30, got %+v", pos)
+	}
+}
+
+func TestKeeperChargesFees(t *testing.T) {
+	log := logger.New(logger.ERROR)
+	k := positions.NewKeeper(engine.NewMatchingEngine(10, log), nil, log)
+
+	tr := trade("alice", "mm", 100, 10)
+	tr.BuyOrder, tr.SellOrder = uuid.New(), uuid.New()
+	tr.Aggressor = tr.BuyOrder
+	tr.TakerFee = px(0.2)
+	tr.MakerFee = px(-0.05)
+	k.Apply(tr)
+
+	if alice := k.Get("alice"); alice.RealizedPnL != px(-0.2) {
+		t.Errorf("Expected taker fee off realized PnL, got %v", alice.RealizedPnL)
+	}
+	if mm := k.Get("mm"); mm.RealizedPnL != px(0.05) {
+		t.Errorf("Expected maker rebate added to realized PnL, got %v", mm.RealizedPnL)
+	}
+}
+
+func TestKeeperRecoversDroppedTrades(t *testing.T) {
+	log := logger.New(logger.ERROR)
+	eng := engine.NewMatchingEngine(10, log)
+	eng.SetInstrument(engine.DefaultInstrument("TEST"))
+	trades := make(chan *engine.Trade, 10)
+	k := positions.NewKeeper(eng, trades, log)
+	eng.Start()
+	defer eng.Stop()
+	k.Start()
+
+	ctx := context.Background()
+	eng.Submit(ctx, engine.NewOrder("TEST", engine.SELL, px(100), 30, "bob"))
+	var fills []engine.Trade
+	for range 3 {
+		result, _ := eng.Submit(ctx, engine.NewOrder("TEST", engine.BUY, px(100), 10, "alice"))
+		fills = append(fills, result.Trades...)
+	}
+
+	// The second trade is dropped and the first arrives late; each trade
+	// must still be booked exactly once.
+	trades <- &fills[2]
+	trades <- &fills[0]
+	close(trades)
+	for range k.Updates() {
+	}
+
+	if alice := k.Get("alice"); len(alice.Positions) != 1 || alice.Positions[0].Qty != 30 {
+		t.Errorf("Expected alice long 30 from all three trades, got %+v", alice.Positions)
+	}
+	if bob := k.Get("bob"); len(bob.Positions) != 1 || bob.Positions[0].Qty != -30 {
+		t.Errorf("Expected bob short 30, got %+v", bob.Positions)
+	}
+}
@@ -0,0 +1,349 @@
+package risk
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/AkshatMadhani/nanopulse/engine"
+)
+
+// Code says which limit an order broke.
+type Code string
+
+const (
+	REJECT_MAX_ORDER_QTY   Code = "max_order_qty"
+	REJECT_MAX_NOTIONAL    Code = "max_notional"
+	REJECT_PRICE_COLLAR    Code = "price_collar"
+	REJECT_MAX_OPEN_ORDERS Code = "max_open_orders"
+	REJECT_ORDER_RATE      Code = "order_rate"
+)
+
+// Rejection is why the gateway refused an order.
+type Rejection struct {
+	Code    Code   `json:"code"`
+	Message string `json:"message"`
+}
+
+func (r *Rejection) Error() string {
+	return string(r.Code) + ": " + r.Message
+}
+
+func reject(code Code, format string, args ...any) *Rejection {
+	return &Rejection{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// InstrumentLimits apply to every order on a symbol. PriceCollarPct bounds
+// how far a limit price may be from the last trade, or the instrument's
+// reference price before the first one. Zero turns a check off.
+type InstrumentLimits struct {
+	MaxOrderQty    int          `json:"max_order_qty"`
+	MaxNotional    engine.Price `json:"max_notional"`
+	PriceCollarPct float64      `json:"price_collar_pct"`
+}
+
+// AccountLimits apply to every order from one account. Zero turns a check
+// off.
+type AccountLimits struct {
+	MaxOrderQty     int          `json:"max_order_qty"`
+	MaxNotional     engine.Price `json:"max_notional"`
+	MaxOpenOrders   int          `json:"max_open_orders"`
+	MaxOrdersPerSec int          `json:"max_orders_per_sec"`
+}
+
+// Limits holds the defaults and the per-instrument and per-account
+// overrides. An override replaces the default as a whole.
+type Limits struct {
+	Instrument  InstrumentLimits            `json:"instrument"`
+	Account     AccountLimits               `json:"account"`
+	Instruments map[string]InstrumentLimits `json:"instruments"`
+	Accounts    map[string]AccountLimits    `json:"accounts"`
+}
+
+func (l InstrumentLimits) Validate() error {
+	if l.MaxOrderQty < 0 || l.MaxNotional < 0 || l.PriceCollarPct < 0 {
+		return fmt.Errorf("limits must not be negative")
+	}
+	return nil
+}
+
+func (l AccountLimits) Validate() error {
+	if l.MaxOrderQty < 0 || l.MaxNotional < 0 || l.MaxOpenOrders < 0 || l.MaxOrdersPerSec < 0 {
+		return fmt.Errorf("limits must not be negative")
+	}
+	return nil
+}
+
+func (l Limits) Validate() error {
+	if err := l.Instrument.Validate(); err != nil {
+		return fmt.Errorf("instrument default: %w", err)
+	}
+	if err := l.Account.Validate(); err != nil {
+		return fmt.Errorf("account default: %w", err)
+	}
+	for symbol, limits := range l.Instruments {
+		if err := limits.Validate(); err != nil {
+			return fmt.Errorf("instrument %s: %w", symbol, err)
+		}
+	}
+	for id, limits := range l.Accounts {
+		if err := limits.Validate(); err != nil {
+			return fmt.Errorf("account %s: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// bucket allows rate orders a second with bursts of up to rate.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func (b *bucket) take(rate int, now time.Time) bool {
+	if b.last.IsZero() {
+		b.tokens = float64(rate)
+	} else {
+		b.tokens = min(float64(rate), b.tokens+now.Sub(b.last).Seconds()*float64(rate))
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Gateway checks orders against their instrument's and account's limits
+// before they are handed to the engine. Open orders are counted as the
+// engine last saw them, so orders still queued are not included.
+type Gateway struct {
+	engine *engine.MatchingEngine
+	limits Limits
+	rates  map[string]*bucket
+	now    func() time.Time
+	mu     sync.Mutex
+}
+
+func NewGateway(eng *engine.MatchingEngine) *Gateway {
+	return &Gateway{
+		engine: eng,
+		limits: Limits{
+			Instruments: make(map[string]InstrumentLimits),
+			Accounts:    make(map[string]AccountLimits),
+		},
+		rates: make(map[string]*bucket),
+		now:   time.Now,
+	}
+}
+
+// SetClock replaces the clock used for order rates, for tests.
+func (g *Gateway) SetClock(now func() time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.now = now
+}
+
+func (g *Gateway) SetLimits(l Limits) error {
+	if err := l.Validate(); err != nil {
+		return err
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.limits = Limits{
+		Instrument:  l.Instrument,
+		Account:     l.Account,
+		Instruments: make(map[string]InstrumentLimits, len(l.Instruments)),
+		Accounts:    make(map[string]AccountLimits, len(l.Accounts)),
+	}
+	for symbol, limits := range l.Instruments {
+		g.limits.Instruments[symbol] = limits
+	}
+	for id, limits := range l.Accounts {
+		g.limits.Accounts[id] = limits
+	}
+	return nil
+}
+
+// Limits returns a copy of the current limits.
+func (g *Gateway) Limits() Limits {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	l := Limits{
+		Instrument:  g.limits.Instrument,
+		Account:     g.limits.Account,
+		Instruments: make(map[string]InstrumentLimits, len(g.limits.Instruments)),
+		Accounts:    make(map[string]AccountLimits, len(g.limits.Accounts)),
+	}
+	for symbol, limits := range g.limits.Instruments {
+		l.Instruments[symbol] = limits
+	}
+	for id, limits := range g.limits.Accounts {
+		l.Accounts[id] = limits
+	}
+	return l
+}
+
+func (g *Gateway) SetInstrumentLimits(symbol string, l InstrumentLimits) error {
+	if err := l.Validate(); err != nil {
+		return err
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.limits.Instruments[symbol] = l
+	return nil
+}
+
+// ClearInstrumentLimits puts symbol back on the default limits.
+func (g *Gateway) ClearInstrumentLimits(symbol string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.limits.Instruments, symbol)
+}
+
+func (g *Gateway) SetAccountLimits(id string, l AccountLimits) error {
+	if err := l.Validate(); err != nil {
+		return err
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.limits.Accounts[id] = l
+	return nil
+}
+
+// ClearAccountLimits puts the account back on the default limits.
+func (g *Gateway) ClearAccountLimits(id string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.limits.Accounts, id)
+}
+
+func (g *Gateway) instrumentLimits(symbol string) InstrumentLimits {
+	if l, exists := g.limits.Instruments[symbol]; exists {
+		return l
+	}
+	return g.limits.Instrument
+}
+
+func (g *Gateway) accountLimits(id string) AccountLimits {
+	if l, exists := g.limits.Accounts[id]; exists {
+		return l
+	}
+	return g.limits.Account
+}
+
+// Check returns why order may not be sent to the engine, or nil if it may.
+// Only orders that pass count towards the account's order rate.
+func (g *Gateway) Check(order *engine.Order) *Rejection {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	inst := g.instrumentLimits(order.Symbol)
+	acct := g.accountLimits(order.UserID)
+	if r := g.checkOrder(order, order.Price, order.Qty, inst, acct); r != nil {
+		return r
+	}
+
+	rests := order.TimeInForce != engine.IOC && order.TimeInForce != engine.FOK
+	if rests && acct.MaxOpenOrders > 0 && g.engine.OpenOrders(order.UserID) >= acct.MaxOpenOrders {
+		return reject(REJECT_MAX_OPEN_ORDERS, "account is at its limit of %d open orders", acct.MaxOpenOrders)
+	}
+
+	if acct.MaxOrdersPerSec > 0 {
+		b, exists := g.rates[order.UserID]
+		if !exists {
+			b = &bucket{}
+			g.rates[order.UserID] = b
+		}
+		if !b.take(acct.MaxOrdersPerSec, g.now()) {
+			return reject(REJECT_ORDER_RATE, "more than %d orders per second", acct.MaxOrdersPerSec)
+		}
+	}
+	return nil
+}
+
+// CheckAmend returns why order may not be amended to price and qty, or nil
+// if it may. A zero price or qty leaves that field unchanged, as in
+// MatchingEngine.AmendOrder.
+func (g *Gateway) CheckAmend(order engine.Order, price engine.Price, qty int) *Rejection {
+	if price == 0 {
+		price = order.Price
+	}
+	if qty == 0 {
+		qty = order.Qty
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.checkOrder(&order, price, qty, g.instrumentLimits(order.Symbol), g.accountLimits(order.UserID))
+}
+
+// checkOrder applies the size and price checks to order as if it had price
+// and qty. Orders without a limit price are sized at their stop price, or
+// at the reference price for market orders.
+func (g *Gateway) checkOrder(order *engine.Order, price engine.Price, qty int, inst InstrumentLimits, acct AccountLimits) *Rejection {
+	if r := checkQty(qty, inst.MaxOrderQty, acct.MaxOrderQty); r != nil {
+		return r
+	}
+
+	ref := g.reference(order.Symbol)
+	sizing := price
+	if sizing == 0 {
+		sizing = order.StopPrice
+	}
+	if sizing == 0 {
+		sizing = ref
+	}
+	if r := checkNotional(sizing, qty, inst.MaxNotional, acct.MaxNotional); r != nil {
+		return r
+	}
+
+	if price > 0 && ref > 0 && inst.PriceCollarPct > 0 {
+		if math.Abs(float64(price-ref)) > float64(ref)*inst.PriceCollarPct/100 {
+			return reject(REJECT_PRICE_COLLAR, "price %s is more than %g%% from reference %s", price, inst.PriceCollarPct, ref)
+		}
+	}
+	return nil
+}
+
+func checkQty(qty int, limits ...int) *Rejection {
+	for _, limit := range limits {
+		if limit > 0 && qty > limit {
+			return reject(REJECT_MAX_ORDER_QTY, "qty %d exceeds limit of %d", qty, limit)
+		}
+	}
+	return nil
+}
+
+// checkNotional compares qty against limit/price so large orders cannot
+// overflow the product.
+func checkNotional(price engine.Price, qty int, limits ...engine.Price) *Rejection {
+	if price <= 0 {
+		return nil
+	}
+	for _, limit := range limits {
+		if limit > 0 && engine.Price(qty) > limit/price {
+			return reject(REJECT_MAX_NOTIONAL, "notional exceeds limit of %s", limit)
+		}
+	}
+	return nil
+}
+
+// reference is the price orders on symbol are collared and sized against:
+// the last trade, or the instrument's reference price before the first.
+func (g *Gateway) reference(symbol string) engine.Price {
+	if book := g.engine.GetBook(symbol); book != nil {
+		if last := book.GetLastPrice(); last != nil {
+			return *last
+		}
+	}
+	if inst, exists := g.engine.GetInstrument(symbol); exists {
+		return inst.ReferencePrice
+	}
+	return 0
+}
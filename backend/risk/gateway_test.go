@@ -0,0 +1,128 @@
+package risk_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/AkshatMadhani/nanopulse/engine"
+	"github.com/AkshatMadhani/nanopulse/logger"
+	"github.com/AkshatMadhani/nanopulse/risk"
+)
+
+func px(f float64) engine.Price {
+	return engine.PriceFromFloat(f)
+}
+
+func newTestGateway(t *testing.T, limits risk.Limits) (*engine.MatchingEngine, *risk.Gateway) {
+	me := engine.NewMatchingEngine(100, logger.New(logger.ERROR))
+	inst := engine.DefaultInstrument("TEST")
+	inst.ReferencePrice = px(2500.0)
+	me.SetInstrument(inst)
+	me.Start()
+	t.Cleanup(me.Stop)
+
+	gw := risk.NewGateway(me)
+	if err := gw.SetLimits(limits); err != nil {
+		t.Fatalf("SetLimits failed: %v", err)
+	}
+	return me, gw
+}
+
+func code(r *risk.Rejection) risk.Code {
+	if r == nil {
+		return ""
+	}
+	return r.Code
+}
+
+func TestGatewaySizeAndCollar(t *testing.T) {
+	me, gw := newTestGateway(t, risk.Limits{
+		Instrument: risk.InstrumentLimits{MaxOrderQty: 2500, PriceCollarPct: 5},
+		Account:    risk.AccountLimits{MaxNotional: px(1000000.0)},
+		Accounts: map[string]risk.AccountLimits{
+			"desk": {MaxNotional: px(10000000.0)},
+		},
+	})
+
+	tests := []struct {
+		name  string
+		order *engine.Order
+		want  risk.Code
+	}{
+		{"within limits", engine.NewOrder("TEST", engine.BUY, px(2500.0), 400, "alice"), ""},
+		{"fat finger", engine.NewOrder("TEST", engine.BUY, px(2500.0), 25000, "desk"), risk.REJECT_MAX_ORDER_QTY},
+		{"account notional", engine.NewOrder("TEST", engine.BUY, px(2500.0), 401, "alice"), risk.REJECT_MAX_NOTIONAL},
+		{"account override", engine.NewOrder("TEST", engine.BUY, px(2500.0), 2500, "desk"), ""},
+		{"market sized at reference", engine.NewMarketOrder("TEST", engine.BUY, 401, engine.IOC, "alice"), risk.REJECT_MAX_NOTIONAL},
+		{"inside collar", engine.NewOrder("TEST", engine.SELL, px(2625.0), 1, "alice"), ""},
+		{"outside collar", engine.NewOrder("TEST", engine.SELL, px(2625.05), 1, "alice"), risk.REJECT_PRICE_COLLAR},
+	}
+	for _, tt := range tests {
+		if got := code(gw.Check(tt.order)); got != tt.want {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.want, got)
+		}
+	}
+
+	// Once the symbol trades, the collar follows the last price.
+	ctx := context.Background()
+	me.Submit(ctx, engine.NewOrder("TEST", engine.SELL, px(2000.0), 1, "bob"))
+	me.Submit(ctx, engine.NewOrder("TEST", engine.BUY, px(2000.0), 1, "carol"))
+	if got := code(gw.Check(engine.NewOrder("TEST", engine.BUY, px(2090.0), 1, "alice"))); got != "" {
+		t.Errorf("Expected order near last trade to pass, got %q", got)
+	}
+	if got := code(gw.Check(engine.NewOrder("TEST", engine.BUY, px(2500.0), 1, "alice"))); got != risk.REJECT_PRICE_COLLAR {
+		t.Errorf("Expected order at old reference to be collared, got %q", got)
+	}
+
+	resting, _ := me.Submit(ctx, engine.NewOrder("TEST", engine.BUY, px(1990.0), 10, "alice"))
+	if got := code(gw.CheckAmend(resting.Order, 0, 3000)); got != risk.REJECT_MAX_ORDER_QTY {
+		t.Errorf("Expected amend to 3000 to break max qty, got %q", got)
+	}
+	if got := code(gw.CheckAmend(resting.Order, px(1800.0), 0)); got != risk.REJECT_PRICE_COLLAR {
+		t.Errorf("Expected amend outside collar to be rejected, got %q", got)
+	}
+}
+
+func TestGatewayOpenOrdersAndRate(t *testing.T) {
+	me, gw := newTestGateway(t, risk.Limits{
+		Account: risk.AccountLimits{MaxOpenOrders: 2, MaxOrdersPerSec: 3},
+	})
+	now := time.Unix(0, 0)
+	gw.SetClock(func() time.Time { return now })
+	ctx := context.Background()
+
+	for i := range 2 {
+		order := engine.NewOrder("TEST", engine.BUY, px(2400.0+float64(i)), 1, "alice")
+		if r := gw.Check(order); r != nil {
+			t.Fatalf("Order %d rejected: %v", i, r)
+		}
+		me.Submit(ctx, order)
+	}
+	if got := code(gw.Check(engine.NewOrder("TEST", engine.BUY, px(2400.0), 1, "alice"))); got != risk.REJECT_MAX_OPEN_ORDERS {
+		t.Errorf("Expected third resting order to be rejected, got %q", got)
+	}
+
+	// An IOC never rests, so only the rate applies: one order is left this
+	// second.
+	ioc := engine.NewOrder("TEST", engine.SELL, px(2401.0), 1, "bob")
+	ioc.TimeInForce = engine.IOC
+	if r := gw.Check(ioc); r != nil {
+		t.Fatalf("IOC rejected: %v", r)
+	}
+	me.Submit(ctx, ioc)
+	if n := me.OpenOrders("alice"); n != 1 {
+		t.Errorf("Expected 1 open order after fill, got %d", n)
+	}
+	if r := gw.Check(engine.NewOrder("TEST", engine.BUY, px(2400.0), 1, "alice")); r != nil {
+		t.Fatalf("Order after fill rejected: %v", r)
+	}
+	if got := code(gw.Check(engine.NewOrder("TEST", engine.BUY, px(2400.0), 1, "alice"))); got != risk.REJECT_ORDER_RATE {
+		t.Errorf("Expected fourth order in a second to be rate limited, got %q", got)
+	}
+
+	now = now.Add(time.Second)
+	if r := gw.Check(engine.NewOrder("TEST", engine.SELL, px(2600.0), 1, "alice")); r != nil {
+		t.Errorf("Order a second later rejected: %v", r)
+	}
+}
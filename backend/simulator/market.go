@@ -1,60 +1,79 @@
 package simulator
 
 import (
+	"context"
 	"math/rand"
+	"sync"
 	"time"
+
 	"github.com/AkshatMadhani/nanopulse/engine"
 	"github.com/AkshatMadhani/nanopulse/logger"
+	"github.com/google/uuid"
 )
 
+// MaxRestingOrders is how many GTC orders the simulator leaves working when
+// there is no session calendar to expire them.
+const MaxRestingOrders = 500
+
 type Simulator struct {
 	engine       *engine.MatchingEngine
 	logger       *logger.Logger
-	enabled      bool
 	ordersPerSec int
+	maxResting   int
+	working      []uuid.UUID
+	quit         chan struct{}
+	wg           sync.WaitGroup
 }
 
 func NewSimulator(eng *engine.MatchingEngine, log *logger.Logger, ordersPerSec int) *Simulator {
 	return &Simulator{
 		engine:       eng,
 		logger:       log,
-		enabled:      false,
 		ordersPerSec: ordersPerSec,
+		maxResting:   MaxRestingOrders,
+		quit:         make(chan struct{}),
 	}
 }
 
 func (s *Simulator) Start() {
-	s.enabled = true
 	s.logger.Info("Starting market simulator", "orders_per_sec", s.ordersPerSec)
+	s.wg.Add(1)
 	go s.generateOrders()
 }
 
+// Stop ends generation and waits for any order in flight to be submitted.
 func (s *Simulator) Stop() {
-	s.enabled = false
 	s.logger.Info("Stopping market simulator")
+	close(s.quit)
+	s.wg.Wait()
 }
 
 func (s *Simulator) generateOrders() {
-	symbols := []string{"RELIANCE", "TCS", "INFY", "HDFC", "ICICI"}
+	defer s.wg.Done()
 	interval := time.Second / time.Duration(s.ordersPerSec)
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		if !s.enabled {
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.quit:
 			return
 		}
 
-		symbol := symbols[rand.Intn(len(symbols))]
-		s.generateRandomOrder(symbol)
+		instruments := s.engine.Instruments().Active()
+		if len(instruments) == 0 {
+			continue
+		}
+		s.generateRandomOrder(instruments[rand.Intn(len(instruments))])
 	}
 }
 
-func (s *Simulator) generateRandomOrder(symbol string) {
-
+func (s *Simulator) generateRandomOrder(inst engine.Instrument) {
+	symbol := inst.Symbol
 	book := s.engine.GetBook(symbol)
 
-	var basePrice float64
+	var basePrice engine.Price
 	if book != nil {
 		bid := book.GetBestBid()
 		ask := book.GetBestAsk()
@@ -66,10 +85,10 @@ func (s *Simulator) generateRandomOrder(symbol string) {
 		} else if ask != nil {
 			basePrice = *ask
 		} else {
-			basePrice = s.getDefaultPrice(symbol)
+			basePrice = inst.ReferencePrice
 		}
 	} else {
-		basePrice = s.getDefaultPrice(symbol)
+		basePrice = inst.ReferencePrice
 	}
 
 	side := engine.BUY
@@ -77,13 +96,27 @@ func (s *Simulator) generateRandomOrder(symbol string) {
 		side = engine.SELL
 	}
 
-	priceVariation := basePrice * 0.02 * (rand.Float64()*2 - 1)
-	price := basePrice + priceVariation
+	priceVariation := engine.PriceFromFloat(basePrice.Float() * 0.02 * (rand.Float64()*2 - 1))
+	price := inst.RoundToTick(basePrice + priceVariation)
 
-	qty := rand.Intn(50) + 1
+	qty := (rand.Intn(50) + 1) * inst.LotSize
 
-	order := engine.NewOrder(symbol, side, price, qty, "simulator")
-	s.engine.GetOrderChan() <- order
+	// With a calendar simulated flow is DAY, so each session close clears
+	// what went unfilled. Without one it is GTC and the oldest orders still
+	// working are cancelled instead.
+	order := s.engine.NewOrder(symbol, side, price, qty, "simulator")
+	_, scheduled := s.engine.SessionDate(symbol)
+	if scheduled {
+		order.TimeInForce = engine.DAY
+	}
+	result, err := s.engine.Submit(context.Background(), order)
+	if err != nil {
+		s.logger.Warn("Simulated order not submitted", "symbol", symbol, "error", err)
+		return
+	}
+	if !scheduled && !result.Order.Status.Done() {
+		s.track(order.ID)
+	}
 
 	s.logger.Debug("Simulated order",
 		"symbol", symbol,
@@ -93,19 +126,14 @@ func (s *Simulator) generateRandomOrder(symbol string) {
 	)
 }
 
-func (s *Simulator) getDefaultPrice(symbol string) float64 {
-	defaults := map[string]float64{
-		"RELIANCE": 2500.0,
-		"TCS":      3500.0,
-		"INFY":     1500.0,
-		"HDFC":     1600.0,
-		"ICICI":    900.0,
-	}
-
-	if price, exists := defaults[symbol]; exists {
-		return price
+// track records a resting GTC order and cancels the oldest once more than
+// maxResting are tracked. Cancelling one that has since filled does nothing.
+func (s *Simulator) track(id uuid.UUID) {
+	s.working = append(s.working, id)
+	for len(s.working) > s.maxResting {
+		s.engine.CancelOrder(s.working[0])
+		s.working = s.working[1:]
 	}
-	return 1000.0
 }
 
 func (s *Simulator) SetRate(ordersPerSec int) {
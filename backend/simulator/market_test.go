@@ -0,0 +1,79 @@
+package simulator
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/AkshatMadhani/nanopulse/engine"
+	"github.com/AkshatMadhani/nanopulse/logger"
+)
+
+func newEngine(clock engine.Clock) *engine.MatchingEngine {
+	me := engine.NewMatchingEngine(1000, logger.New(logger.ERROR))
+	me.SetClock(clock)
+	me.SetInstrument(engine.DefaultInstrument("TEST"))
+	return me
+}
+
+// generate sends n simulated orders and waits until the engine has applied
+// them, which it has once a later order on the same symbol returns.
+func generate(t *testing.T, s *Simulator, me *engine.MatchingEngine, n int) {
+	t.Helper()
+	inst, _ := me.GetInstrument("TEST")
+	for range n {
+		s.generateRandomOrder(inst)
+	}
+	barrier := me.NewMarketOrder("TEST", engine.BUY, 1, engine.IOC, "barrier")
+	if _, err := me.Submit(context.Background(), barrier); err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+}
+
+func TestSimulatorBookClearsAtClose(t *testing.T) {
+	at := func(day, hour int) time.Time {
+		return time.Date(2024, 1, day, hour, 0, 0, 0, time.UTC)
+	}
+	clock := engine.NewSimClock(at(3, 10)) // a Wednesday
+	me := newEngine(clock)
+	err := me.SetCalendar(&engine.Calendar{Default: engine.Schedule{
+		PreOpen:        9 * time.Hour,
+		Open:           9 * time.Hour,
+		ClosingAuction: 15 * time.Hour,
+		Close:          15 * time.Hour,
+	}})
+	if err != nil {
+		t.Fatalf("SetCalendar failed: %v", err)
+	}
+	me.Start()
+	defer me.Stop()
+	s := NewSimulator(me, logger.New(logger.ERROR), 10)
+
+	for day := 3; day <= 4; day++ {
+		clock.Set(at(day, 10))
+		me.CheckSchedule()
+		generate(t, s, me, 100)
+		if me.OpenOrders("simulator") == 0 {
+			t.Fatalf("Day %d: expected simulated orders resting before the close", day)
+		}
+
+		clock.Set(at(day, 16))
+		me.CheckSchedule()
+		if open := me.OpenOrders("simulator"); open != 0 {
+			t.Errorf("Day %d: expected the close to clear the simulator's orders, %d still open", day, open)
+		}
+	}
+}
+
+func TestSimulatorCapsOrdersWithoutCalendar(t *testing.T) {
+	me := newEngine(engine.NewSimClock(time.Unix(1700000000, 0)))
+	me.Start()
+	defer me.Stop()
+	s := NewSimulator(me, logger.New(logger.ERROR), 10)
+	s.maxResting = 20
+
+	generate(t, s, me, 200)
+	if open := me.OpenOrders("simulator"); open == 0 || open > s.maxResting {
+		t.Errorf("Expected between 1 and %d simulated orders open, got %d", s.maxResting, open)
+	}
+}
@@ -0,0 +1,287 @@
+package snapshot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/AkshatMadhani/nanopulse/engine"
+	"github.com/google/uuid"
+)
+
+// File layout, all integers little-endian:
+//
+//	magic   [4]byte "NPSS"
+//	version uint16
+//	body    (see Encode)
+//	crc32   uint32 IEEE checksum of everything before it
+//
+// Bump Version when the body layout changes and keep decoders for older
+// versions readable.
+//
+//	v1: seq, time, books
+//	v2: seq, time, id position, books
+//	v3: seq, time, id position, event seq, books with symbol seq
+//	v4: orders carry status, filled qty, average price and notional
+//	v5: orders carry their self-trade mode
+//	v6: account cash and positions follow the books
+//	v7: orders carry their maker and taker fee rates
+//	v8: books carry their trading state and circuit breaker window
+//	v9: orders carry their good-till date
+const (
+	Version uint16 = 9
+	magic          = "NPSS"
+)
+
+var ErrCorrupt = errors.New("snapshot corrupt")
+
+func Encode(state *engine.EngineState) []byte {
+	w := &writer{}
+	w.buf.WriteString(magic)
+	w.u16(Version)
+
+	w.u64(state.Seq)
+	w.i64(state.Time)
+	w.u64(state.IDSeq)
+	w.u64(state.EventSeq)
+	w.u32(uint32(len(state.Books)))
+	for _, book := range state.Books {
+		w.str(book.Symbol)
+		w.u64(book.Seq)
+		if book.LastPrice != nil {
+			w.u8(1)
+			w.i64(int64(*book.LastPrice))
+		} else {
+			w.u8(0)
+		}
+		w.u8(uint8(book.Trading.State))
+		w.i64(book.Trading.Until)
+		w.u32(uint32(len(book.Window)))
+		for _, p := range book.Window {
+			w.i64(p.Time)
+			w.i64(int64(p.Price))
+		}
+		w.orders(book.Bids)
+		w.orders(book.Asks)
+		w.orders(book.Stops)
+	}
+	w.u32(uint32(len(state.Accounts)))
+	for _, acct := range state.Accounts {
+		w.str(acct.ID)
+		w.i64(int64(acct.Cash))
+		symbols := make([]string, 0, len(acct.Positions))
+		for symbol := range acct.Positions {
+			symbols = append(symbols, symbol)
+		}
+		sort.Strings(symbols)
+		w.u32(uint32(len(symbols)))
+		for _, symbol := range symbols {
+			w.str(symbol)
+			w.i64(int64(acct.Positions[symbol]))
+		}
+	}
+
+	w.u32(crc32.ChecksumIEEE(w.buf.Bytes()))
+	return w.buf.Bytes()
+}
+
+func Decode(data []byte) (*engine.EngineState, error) {
+	if len(data) < len(magic)+2+4 {
+		return nil, ErrCorrupt
+	}
+	body, sum := data[:len(data)-4], binary.LittleEndian.Uint32(data[len(data)-4:])
+	if crc32.ChecksumIEEE(body) != sum {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrCorrupt)
+	}
+	if string(body[:len(magic)]) != magic {
+		return nil, fmt.Errorf("%w: bad magic", ErrCorrupt)
+	}
+
+	r := &reader{r: bytes.NewReader(body[len(magic):])}
+	version := r.u16()
+	if version == 0 || version > Version {
+		return nil, fmt.Errorf("unsupported snapshot version %d", version)
+	}
+
+	state := &engine.EngineState{
+		Seq:  r.u64(),
+		Time: r.i64(),
+	}
+	if version >= 2 {
+		state.IDSeq = r.u64()
+	}
+	if version >= 3 {
+		state.EventSeq = r.u64()
+	}
+	books := r.u32()
+	for i := uint32(0); i < books && r.err == nil; i++ {
+		book := engine.BookState{Symbol: r.str()}
+		if version >= 3 {
+			book.Seq = r.u64()
+		}
+		if r.u8() == 1 {
+			price := engine.Price(r.i64())
+			book.LastPrice = &price
+		}
+		if version >= 8 {
+			book.Trading = engine.TradingStatus{State: engine.TradingState(r.u8()), Until: r.i64()}
+			points := r.u32()
+			for j := uint32(0); j < points && r.err == nil; j++ {
+				book.Window = append(book.Window, engine.PricePoint{Time: r.i64(), Price: engine.Price(r.i64())})
+			}
+		}
+		book.Bids = r.orders(book.Symbol, version)
+		book.Asks = r.orders(book.Symbol, version)
+		book.Stops = r.orders(book.Symbol, version)
+		state.Books = append(state.Books, book)
+	}
+	if version >= 6 {
+		accounts := r.u32()
+		for i := uint32(0); i < accounts && r.err == nil; i++ {
+			acct := engine.AccountState{ID: r.str(), Cash: engine.Price(r.i64()), Positions: make(map[string]int)}
+			positions := r.u32()
+			for j := uint32(0); j < positions && r.err == nil; j++ {
+				symbol := r.str()
+				acct.Positions[symbol] = int(r.i64())
+			}
+			state.Accounts = append(state.Accounts, acct)
+		}
+	}
+
+	if r.err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorrupt, r.err)
+	}
+	return state, nil
+}
+
+type writer struct {
+	buf bytes.Buffer
+}
+
+func (w *writer) u8(v uint8) { w.buf.WriteByte(v) }
+
+func (w *writer) u16(v uint16) { w.buf.Write(binary.LittleEndian.AppendUint16(nil, v)) }
+
+func (w *writer) u32(v uint32) { w.buf.Write(binary.LittleEndian.AppendUint32(nil, v)) }
+
+func (w *writer) u64(v uint64) { w.buf.Write(binary.LittleEndian.AppendUint64(nil, v)) }
+
+func (w *writer) i64(v int64) { w.u64(uint64(v)) }
+
+func (w *writer) f64(v float64) { w.u64(math.Float64bits(v)) }
+
+func (w *writer) str(s string) {
+	w.u32(uint32(len(s)))
+	w.buf.WriteString(s)
+}
+
+func (w *writer) orders(orders []engine.Order) {
+	w.u32(uint32(len(orders)))
+	for _, o := range orders {
+		w.buf.Write(o.ID[:])
+		w.u8(uint8(o.Side))
+		w.u8(uint8(o.Type))
+		w.u8(uint8(o.TimeInForce))
+		w.u8(uint8(o.PostOnly))
+		w.i64(int64(o.Price))
+		w.i64(int64(o.StopPrice))
+		w.i64(int64(o.Qty))
+		w.i64(int64(o.DisplayQty))
+		w.i64(int64(o.VisibleQty))
+		w.i64(o.Timestamp)
+		w.str(o.UserID)
+		w.u8(uint8(o.Status))
+		w.i64(int64(o.FilledQty))
+		w.i64(int64(o.AvgPrice))
+		w.i64(o.Notional)
+		w.u8(uint8(o.SelfTrade))
+		w.f64(o.Fees.MakerBps)
+		w.f64(o.Fees.TakerBps)
+		w.str(o.GoodTillDate)
+	}
+}
+
+// reader records the first error and turns every later read into a no-op,
+// so decoding code can read straight through and check once at the end.
+type reader struct {
+	r   *bytes.Reader
+	err error
+}
+
+func (r *reader) read(n int) []byte {
+	if r.err != nil {
+		return make([]byte, n)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r.r, b); err != nil {
+		r.err = err
+	}
+	return b
+}
+
+func (r *reader) u8() uint8 { return r.read(1)[0] }
+
+func (r *reader) u16() uint16 { return binary.LittleEndian.Uint16(r.read(2)) }
+
+func (r *reader) u32() uint32 { return binary.LittleEndian.Uint32(r.read(4)) }
+
+func (r *reader) u64() uint64 { return binary.LittleEndian.Uint64(r.read(8)) }
+
+func (r *reader) i64() int64 { return int64(r.u64()) }
+
+func (r *reader) f64() float64 { return math.Float64frombits(r.u64()) }
+
+func (r *reader) str() string {
+	n := r.u32()
+	if r.err == nil && int64(n) > int64(r.r.Len()) {
+		r.err = io.ErrUnexpectedEOF
+	}
+	if r.err != nil {
+		return ""
+	}
+	return string(r.read(int(n)))
+}
+
+func (r *reader) orders(symbol string, version uint16) []engine.Order {
+	n := r.u32()
+	orders := make([]engine.Order, 0, min(int(n), r.r.Len()/16))
+	for i := uint32(0); i < n && r.err == nil; i++ {
+		var o engine.Order
+		copy(o.ID[:], r.read(len(uuid.UUID{})))
+		o.Symbol = symbol
+		o.Side = engine.Side(r.u8())
+		o.Type = engine.OrderType(r.u8())
+		o.TimeInForce = engine.TimeInForce(r.u8())
+		o.PostOnly = engine.PostOnlyMode(r.u8())
+		o.Price = engine.Price(r.i64())
+		o.StopPrice = engine.Price(r.i64())
+		o.Qty = int(r.i64())
+		o.DisplayQty = int(r.i64())
+		o.VisibleQty = int(r.i64())
+		o.Timestamp = r.i64()
+		o.UserID = r.str()
+		if version >= 4 {
+			o.Status = engine.OrderStatus(r.u8())
+			o.FilledQty = int(r.i64())
+			o.AvgPrice = engine.Price(r.i64())
+			o.Notional = r.i64()
+		}
+		if version >= 5 {
+			o.SelfTrade = engine.SelfTradeMode(r.u8())
+		}
+		if version >= 7 {
+			o.Fees.MakerBps = r.f64()
+			o.Fees.TakerBps = r.f64()
+		}
+		if version >= 9 {
+			o.GoodTillDate = r.str()
+		}
+		orders = append(orders, o)
+	}
+	return orders
+}
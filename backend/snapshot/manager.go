@@ -0,0 +1,185 @@
+package snapshot
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/AkshatMadhani/nanopulse/engine"
+	"github.com/AkshatMadhani/nanopulse/logger"
+)
+
+const fileExt = ".snap"
+
+type Info struct {
+	Path       string  `json:"path"`
+	Seq        uint64  `json:"seq"`
+	Books      int     `json:"books"`
+	Orders     int     `json:"orders"`
+	Bytes      int     `json:"bytes"`
+	DurationMs float64 `json:"duration_ms"`
+}
+
+// Manager writes engine snapshots to a directory, keeping the newest few.
+type Manager struct {
+	dir    string
+	keep   int
+	engine *engine.MatchingEngine
+	logger *logger.Logger
+	mu     sync.Mutex
+	done   chan struct{}
+}
+
+func NewManager(dir string, keep int, eng *engine.MatchingEngine, log *logger.Logger) *Manager {
+	if keep <= 0 {
+		keep = 1
+	}
+	return &Manager{
+		dir:    dir,
+		keep:   keep,
+		engine: eng,
+		logger: log,
+		done:   make(chan struct{}),
+	}
+}
+
+func (m *Manager) Start(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	m.logger.Info("Starting periodic snapshots", "interval", interval, "dir", m.dir)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := m.Take(); err != nil {
+					m.logger.Error("Snapshot failed", "error", err)
+				}
+			case <-m.done:
+				return
+			}
+		}
+	}()
+}
+
+func (m *Manager) Stop() {
+	close(m.done)
+}
+
+// Take captures the engine and writes it atomically: the file only appears
+// under its final name once fully written and synced.
+func (m *Manager) Take() (Info, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	start := time.Now()
+	state := m.engine.Capture()
+	data := Encode(&state)
+
+	if err := os.MkdirAll(m.dir, 0o755); err != nil {
+		return Info{}, fmt.Errorf("create snapshot dir: %w", err)
+	}
+
+	path := filepath.Join(m.dir, fmt.Sprintf("%020d-%d%s", state.Seq, state.Time, fileExt))
+	if err := writeFile(path, data); err != nil {
+		return Info{}, err
+	}
+
+	info := Info{
+		Path:       path,
+		Seq:        state.Seq,
+		Books:      len(state.Books),
+		Orders:     state.OrderCount(),
+		Bytes:      len(data),
+		DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	m.logger.Info("Snapshot written",
+		"path", path,
+		"seq", info.Seq,
+		"orders", info.Orders,
+		"bytes", info.Bytes,
+	)
+
+	m.prune()
+	return info, nil
+}
+
+func (m *Manager) prune() {
+	files, err := list(m.dir)
+	if err != nil {
+		return
+	}
+	for len(files) > m.keep {
+		if err := os.Remove(files[0]); err != nil {
+			m.logger.Warn("Failed to remove old snapshot", "path", files[0], "error", err)
+		}
+		files = files[1:]
+	}
+}
+
+// LoadLatest returns the newest snapshot in dir that decodes cleanly,
+// skipping damaged ones. It returns nil if there is none.
+func LoadLatest(dir string, log *logger.Logger) (*engine.EngineState, string, error) {
+	files, err := list(dir)
+	if err != nil {
+		return nil, "", err
+	}
+
+	for i := len(files) - 1; i >= 0; i-- {
+		data, err := os.ReadFile(files[i])
+		if err != nil {
+			return nil, "", fmt.Errorf("read snapshot: %w", err)
+		}
+		state, err := Decode(data)
+		if err != nil {
+			log.Warn("Skipping unreadable snapshot", "path", files[i], "error", err)
+			continue
+		}
+		return state, files[i], nil
+	}
+	return nil, "", nil
+}
+
+// list returns snapshot files oldest first. Names start with the zero-padded
+// journal sequence, so lexical order is age order.
+func list(dir string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*"+fileExt))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func writeFile(path string, data []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("create snapshot: %w", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("write snapshot: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("sync snapshot: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("close snapshot: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("rename snapshot: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package snapshot_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/AkshatMadhani/nanopulse/engine"
+	"github.com/AkshatMadhani/nanopulse/logger"
+	"github.com/AkshatMadhani/nanopulse/snapshot"
+)
+
+func testState() *engine.EngineState {
+	last := engine.PriceFromFloat(2500)
+	iceberg := engine.NewIcebergOrder("TEST", engine.SELL, engine.PriceFromFloat(2501), 100, 10, "mm")
+	iceberg.VisibleQty = 10
+	iceberg.Status = engine.PARTIALLY_FILLED
+	iceberg.FilledQty = 20
+	iceberg.AvgPrice = engine.PriceFromFloat(2501)
+	iceberg.Notional = int64(iceberg.AvgPrice) * 20
+	iceberg.SelfTrade = engine.STP_CANCEL_OLDEST
+	iceberg.Fees = engine.FeeRates{MakerBps: -0.5, TakerBps: 2.5}
+	iceberg.TimeInForce = engine.GTD
+	iceberg.GoodTillDate = "2023-11-17"
+
+	return &engine.EngineState{
+		Seq:      42,
+		Time:     1700000000000000000,
+		IDSeq:    9,
+		EventSeq: 120,
+		Books: []engine.BookState{{
+			Symbol:    "TEST",
+			Seq:       57,
+			LastPrice: &last,
+			Trading:   engine.TradingStatus{State: engine.HALTED, Until: 1700000000900000000},
+			Window:    []engine.PricePoint{{Time: 1699999999000000000, Price: last}},
+			Bids:      []engine.Order{*engine.NewOrder("TEST", engine.BUY, engine.PriceFromFloat(2499.5), 7, "alice")},
+			Asks:      []engine.Order{*iceberg},
+			Stops:     []engine.Order{*engine.NewStopOrder("TEST", engine.BUY, engine.PriceFromFloat(2510), 0, 3, "bob")},
+		}},
+		Accounts: []engine.AccountState{
+			{ID: "alice", Cash: engine.PriceFromFloat(100000), Positions: map[string]int{"TEST": 15}},
+			{ID: "mm", Cash: engine.PriceFromFloat(-5002), Positions: map[string]int{}},
+		},
+	}
+}
+
+func TestEncodeDecodeRoundTrip(t *testing.T) {
+	state := testState()
+
+	decoded, err := snapshot.Decode(snapshot.Encode(state))
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, state) {
+		t.Errorf("Round trip mismatch:\n got %+v\nwant %+v", decoded, state)
+	}
+}
+
+func TestDecodeRejectsCorruption(t *testing.T) {
+	data := snapshot.Encode(testState())
+	data[len(data)/2] ^= 0xff
+
+	if _, err := snapshot.Decode(data); !errors.Is(err, snapshot.ErrCorrupt) {
+		t.Errorf("Expected ErrCorrupt, got %v", err)
+	}
+}
+
+func TestLoadLatestSkipsDamaged(t *testing.T) {
+	log := logger.New(logger.ERROR)
+	dir := t.TempDir()
+
+	good := testState()
+	os.WriteFile(filepath.Join(dir, "00000000000000000042-1.snap"), snapshot.Encode(good), 0o644)
+	os.WriteFile(filepath.Join(dir, "00000000000000000050-2.snap"), []byte("NPSS garbage"), 0o644)
+
+	state, path, err := snapshot.LoadLatest(dir, log)
+	if err != nil {
+		t.Fatalf("LoadLatest failed: %v", err)
+	}
+	if state == nil || state.Seq != 42 {
+		t.Fatalf("Expected snapshot at seq 42, got %+v from %s", state, path)
+	}
+}
@@ -38,6 +38,19 @@ class APIService {
     });
   }
 
+  async cancelOrder(orderId) {
+    return this.fetch(`/order/${orderId}`, {
+      method: 'DELETE',
+    });
+  }
+
+  async amendOrder(orderId, changes) {
+    return this.fetch(`/order/${orderId}`, {
+      method: 'PATCH',
+      body: JSON.stringify(changes),
+    });
+  }
+
   async getHealth() {
     return this.fetch('/health');
   }